@@ -0,0 +1,67 @@
+package httpeasy
+
+import (
+	"strconv"
+	"strings"
+)
+
+// acceptItem is a single element of an `Accept`-style header (`Accept`,
+// `Accept-Encoding`, etc), e.g., `text/html;level=1;q=0.5`.
+type acceptItem struct {
+	// value is the lowercased element value, e.g., `text/html` or `gzip`.
+	value string
+
+	// params holds any parameters other than the weight, e.g., `level=1`.
+	params map[string]string
+
+	// q is the element's weight, between 0 and 1 inclusive.
+	q float64
+}
+
+// parseAccept parses the elements from each of the provided header values.
+// Elements with an invalid weight are dropped since RFC 9110 gives no
+// guidance about how to interpret them.
+func parseAccept(values []string) []acceptItem {
+	var items []acceptItem
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			parts := strings.Split(element, ";")
+			item := acceptItem{
+				value: strings.ToLower(strings.TrimSpace(parts[0])),
+				q:     1,
+			}
+			if item.value == "" {
+				continue
+			}
+
+			valid := true
+			for _, param := range parts[1:] {
+				key, val := param, ""
+				if i := strings.Index(param, "="); i >= 0 {
+					key, val = param[:i], param[i+1:]
+				}
+				key = strings.ToLower(strings.TrimSpace(key))
+				val = strings.Trim(strings.TrimSpace(val), `"`)
+				if key == "" {
+					continue
+				}
+				if key == "q" {
+					q, err := strconv.ParseFloat(val, 64)
+					if err != nil || q < 0 || q > 1 {
+						valid = false
+					}
+					item.q = q
+					continue
+				}
+				if item.params == nil {
+					item.params = map[string]string{}
+				}
+				item.params[key] = val
+			}
+			if valid {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
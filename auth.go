@@ -0,0 +1,304 @@
+package httpeasy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request
+	// doesn't carry credentials for its scheme, so the next authenticator
+	// should be tried.
+	ErrNoCredentials = errors.New("httpeasy: no credentials")
+
+	// ErrInvalidCredentials is returned (possibly wrapped) by an
+	// Authenticator when the request carries credentials for its scheme
+	// which aren't valid.
+	ErrInvalidCredentials = errors.New("httpeasy: invalid credentials")
+)
+
+// Principal is an authenticated identity: a user, service account, API
+// client, etc.
+type Principal struct {
+	// Subject identifies the principal, e.g., a user name or key ID.
+	Subject string `json:"subject"`
+
+	// Scheme is the authentication scheme which identified the principal,
+	// e.g., `basic` or `bearer`.
+	Scheme string `json:"scheme"`
+
+	// Roles holds the principal's roles and permissions, e.g., `admin` or
+	// `billing:write`. See `AnyOf` and `AllOf`.
+	Roles []string `json:"roles,omitempty"`
+
+	// Scopes holds the OAuth scopes delegated to the client acting for the
+	// principal, e.g., `billing:read`. Clients can request any scope, so
+	// scopes only narrow what the principal's roles permit; they're kept
+	// apart from Roles. See `AnyScope` and `AllScopes`.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Claims holds additional attributes of the principal, e.g., the claims
+	// of a token.
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// Principal returns the principal authenticated by the `Authenticate`
+// middleware, or nil if the request isn't authenticated.
+func (r Request) Principal() *Principal { return r.principal }
+
+// Authenticator identifies the principal making a request using one
+// authentication scheme. See `BasicAuth`, `BearerAuth`, `APIKeyAuth` and
+// `ClientCertAuth`.
+type Authenticator interface {
+	// Authenticate returns the request's principal. It returns
+	// ErrNoCredentials if the request has no credentials for the scheme and
+	// an error wrapping ErrInvalidCredentials if they're invalid; other
+	// errors are treated as server errors.
+	Authenticate(r Request) (*Principal, error)
+
+	// Challenge returns the `WWW-Authenticate` challenge for the scheme, or
+	// "" if it has none. `err` is ErrNoCredentials, or the authenticator's
+	// own error if it rejected the request's credentials, e.g., so bearer
+	// challenges can report `invalid_token`.
+	Challenge(err error) string
+}
+
+// authLog records an authentication failure in the request log.
+type authLog struct {
+	Context string `json:"context"`
+	Error   string `json:"error"`
+}
+
+// Authenticate returns middleware which authenticates requests with the
+// provided authenticators, in order, making the principal available via
+// `Request.Principal`. The first authenticator which finds credentials
+// decides: if they're invalid, or no authenticator finds credentials, the
+// request is rejected with 401 Unauthorized and a `WWW-Authenticate` header
+// per authenticator.
+func Authenticate(authenticators ...Authenticator) Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) Response {
+			var authErr error = ErrNoCredentials
+			rejected := -1
+			for i, authenticator := range authenticators {
+				principal, err := authenticator.Authenticate(r)
+				if err == nil {
+					r.principal = principal
+					return next(r)
+				}
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+				if !errors.Is(err, ErrInvalidCredentials) {
+					return HandleError("Error authenticating request", err)
+				}
+				authErr, rejected = err, i
+				break
+			}
+
+			headers := http.Header{}
+			for i, authenticator := range authenticators {
+				var err error = ErrNoCredentials
+				if i == rejected {
+					err = authErr
+				}
+				if value := authenticator.Challenge(err); value != "" {
+					headers.Add("WWW-Authenticate", value)
+				}
+			}
+			detail := "Authentication is required."
+			if authErr != ErrNoCredentials {
+				detail = "The provided credentials are invalid."
+			}
+			return HandleError(
+				"Rejected unauthenticated request",
+				&HTTPError{Status: http.StatusUnauthorized, Detail: detail},
+				authLog{"Authentication failed", authErr.Error()},
+			).WithHeaders(headers)
+		}
+	}
+}
+
+// challenge formats a `WWW-Authenticate` challenge with quoted parameters,
+// omitting empty ones. `params` alternates names and values.
+func challenge(scheme string, params ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(params); i += 2 {
+		if params[i+1] != "" {
+			parts = append(
+				parts,
+				fmt.Sprintf("%s=%q", params[i], params[i+1]),
+			)
+		}
+	}
+	if len(parts) < 1 {
+		return scheme
+	}
+	return scheme + " " + strings.Join(parts, ", ")
+}
+
+// BearerAuth authenticates requests with a bearer token in the
+// `Authorization` header (RFC 6750). See also `JWTVerifier`.
+type BearerAuth struct {
+	// Realm is the protection space reported in challenges.
+	Realm string
+
+	// Verify returns the principal for a token, or an error wrapping
+	// ErrInvalidCredentials if the token isn't valid. See `StaticKeys`.
+	Verify func(token string) (*Principal, error)
+}
+
+// Authenticate implements Authenticator.
+func (a BearerAuth) Authenticate(r Request) (*Principal, error) {
+	token, ok := authorization(r, "Bearer")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	principal, err := a.Verify(token)
+	return verified(principal, err, "bearer")
+}
+
+// Challenge implements Authenticator.
+func (a BearerAuth) Challenge(err error) string {
+	if errors.Is(err, ErrInvalidCredentials) {
+		return challenge("Bearer", "realm", a.Realm, "error", "invalid_token")
+	}
+	return challenge("Bearer", "realm", a.Realm)
+}
+
+// verified completes the result of a Verify function, defaulting the
+// principal's Scheme. A nil principal without an error is treated as invalid
+// credentials rather than crashing or letting the request through.
+func verified(principal *Principal, err error, scheme string) (
+	*Principal,
+	error,
+) {
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil {
+		return nil, fmt.Errorf(
+			"%w: no principal for credentials",
+			ErrInvalidCredentials,
+		)
+	}
+	if principal.Scheme == "" {
+		principal.Scheme = scheme
+	}
+	return principal, nil
+}
+
+// authorization returns the credentials from the request's `Authorization`
+// header if it uses `scheme`.
+func authorization(r Request, scheme string) (string, bool) {
+	header := r.Headers.Get("Authorization")
+	if len(header) <= len(scheme) || header[len(scheme)] != ' ' ||
+		!strings.EqualFold(header[:len(scheme)], scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(scheme)+1:]), true
+}
+
+// APIKeyAuth authenticates requests with an API key in a header or query
+// parameter. At least one of Header and Query must be set; the header is
+// checked first.
+type APIKeyAuth struct {
+	// Header is the name of the header holding the key, e.g., `X-API-Key`.
+	Header string
+
+	// Query is the name of the query parameter holding the key, e.g.,
+	// `api_key`. Keys in URLs tend to end up in logs, so prefer Header.
+	Query string
+
+	// Verify returns the principal for a key, or an error wrapping
+	// ErrInvalidCredentials if the key isn't valid. See `StaticKeys`.
+	Verify func(key string) (*Principal, error)
+}
+
+// Authenticate implements Authenticator.
+func (a APIKeyAuth) Authenticate(r Request) (*Principal, error) {
+	var key string
+	if a.Header != "" {
+		key = r.Headers.Get(a.Header)
+	}
+	if key == "" && a.Query != "" && r.URL != nil {
+		key = r.URL.Query().Get(a.Query)
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	principal, err := a.Verify(key)
+	return verified(principal, err, "apikey")
+}
+
+// Challenge implements Authenticator. There's no standard scheme for API
+// keys, so the challenge just names where the key goes.
+func (a APIKeyAuth) Challenge(error) string {
+	return challenge("APIKey", "header", a.Header, "query", a.Query)
+}
+
+// StaticKeys returns a Verify function for `BearerAuth` or `APIKeyAuth`
+// which accepts a fixed set of keys, mapped to their principals' subjects.
+// Keys are compared in constant time.
+func StaticKeys(keys map[string]string) func(string) (*Principal, error) {
+	type entry struct {
+		digest  [sha256.Size]byte
+		subject string
+	}
+	entries := make([]entry, 0, len(keys))
+	for key, subject := range keys {
+		entries = append(entries, entry{sha256.Sum256([]byte(key)), subject})
+	}
+	return func(key string) (*Principal, error) {
+		digest := sha256.Sum256([]byte(key))
+		var found *Principal
+		for _, entry := range entries {
+			// Check every entry so the timing doesn't reveal which matched
+			if subtle.ConstantTimeCompare(digest[:], entry.digest[:]) == 1 {
+				found = &Principal{Subject: entry.subject}
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("%w: unknown key", ErrInvalidCredentials)
+		}
+		return found, nil
+	}
+}
+
+// ClientCertAuth authenticates requests with TLS client certificates (mutual
+// TLS). The server's `tls.Config` must request and verify client
+// certificates (e.g., `ClientAuth: tls.VerifyClientCertIfGiven` with
+// `ClientCAs`); only verified certificates are accepted.
+type ClientCertAuth struct {
+	// Verify returns the principal for a verified certificate. Defaults to
+	// a principal whose Subject is the certificate's common name.
+	Verify func(cert *x509.Certificate) (*Principal, error)
+}
+
+// Authenticate implements Authenticator.
+func (a ClientCertAuth) Authenticate(r Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) < 1 {
+		return nil, ErrNoCredentials
+	}
+	if len(r.TLS.VerifiedChains) < 1 {
+		return nil, fmt.Errorf(
+			"%w: client certificate wasn't verified",
+			ErrInvalidCredentials,
+		)
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if a.Verify == nil {
+		return &Principal{Subject: cert.Subject.CommonName, Scheme: "mtls"}, nil
+	}
+	principal, err := a.Verify(cert)
+	return verified(principal, err, "mtls")
+}
+
+// Challenge implements Authenticator. Client certificates are requested
+// during the TLS handshake, so there's no HTTP challenge.
+func (a ClientCertAuth) Challenge(error) string { return "" }
@@ -0,0 +1,219 @@
+package httpeasy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Policy is an authorization rule for requests, typically inspecting the
+// request's principal (see `Authenticate`) and path variables. Set a route's
+// `Requires` to enforce a policy, or use the `Authorize` middleware.
+type Policy struct {
+	// Name identifies the policy in logs and route listings, e.g.,
+	// `anyOf(admin, billing:write)`.
+	Name string
+
+	// Allow reports whether the request is authorized.
+	Allow func(Request) bool
+}
+
+// AnyOf returns a policy which requires the principal to have at least one
+// of the roles.
+func AnyOf(roles ...string) Policy {
+	return anyPolicy("anyOf", roles, (*Principal).hasRole)
+}
+
+// AllOf returns a policy which requires the principal to have all of the
+// roles.
+func AllOf(roles ...string) Policy {
+	return allPolicy("allOf", roles, (*Principal).hasRole)
+}
+
+// AnyScope returns a policy which requires the principal to have been
+// granted at least one of the OAuth scopes (see `Principal.Scopes`).
+func AnyScope(scopes ...string) Policy {
+	return anyPolicy("anyScope", scopes, (*Principal).hasScope)
+}
+
+// AllScopes returns a policy which requires the principal to have been
+// granted all of the OAuth scopes (see `Principal.Scopes`).
+func AllScopes(scopes ...string) Policy {
+	return allPolicy("allScopes", scopes, (*Principal).hasScope)
+}
+
+// anyPolicy returns a policy which requires the principal to have at least
+// one of the values per `has`.
+func anyPolicy(
+	name string,
+	values []string,
+	has func(*Principal, string) bool,
+) Policy {
+	return Policy{
+		Name: name + "(" + strings.Join(values, ", ") + ")",
+		Allow: func(r Request) bool {
+			for _, value := range values {
+				if has(r.principal, value) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// allPolicy returns a policy which requires the principal to have all of the
+// values per `has`.
+func allPolicy(
+	name string,
+	values []string,
+	has func(*Principal, string) bool,
+) Policy {
+	return Policy{
+		Name: name + "(" + strings.Join(values, ", ") + ")",
+		Allow: func(r Request) bool {
+			for _, value := range values {
+				if !has(r.principal, value) {
+					return false
+				}
+			}
+			return r.principal != nil
+		},
+	}
+}
+
+// hasRole reports whether the principal, which may be nil, has `role`.
+func (p *Principal) hasRole(role string) bool {
+	return p != nil && containsString(p.Roles, role)
+}
+
+// hasScope reports whether the principal, which may be nil, has been granted
+// `scope`.
+func (p *Principal) hasScope(scope string) bool {
+	return p != nil && containsString(p.Scopes, scope)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticated is a policy which allows any authenticated principal.
+var Authenticated = Policy{
+	Name:  "authenticated",
+	Allow: func(r Request) bool { return r.principal != nil },
+}
+
+// Self returns a policy for resource ownership which requires the path
+// variable `name` to be the principal's Subject, e.g., so a user can only
+// read `/users/{id}` where `id` is their own.
+func Self(name string) Policy {
+	return Policy{
+		Name: "self(" + name + ")",
+		Allow: func(r Request) bool {
+			return r.principal != nil && r.Vars[name] == r.principal.Subject
+		},
+	}
+}
+
+// Or returns a policy which allows requests allowed by any of the policies,
+// e.g., `Or(Self("id"), AnyOf("admin"))`.
+func Or(policies ...Policy) Policy {
+	return Policy{
+		Name: "or(" + policyNames(policies) + ")",
+		Allow: func(r Request) bool {
+			for _, policy := range policies {
+				if policy.Allow(r) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// And returns a policy which allows requests allowed by all of the policies.
+func And(policies ...Policy) Policy {
+	return Policy{
+		Name: "and(" + policyNames(policies) + ")",
+		Allow: func(r Request) bool {
+			for _, policy := range policies {
+				if !policy.Allow(r) {
+					return false
+				}
+			}
+			return true
+		},
+	}
+}
+
+func policyNames(policies []Policy) string {
+	names := make([]string, len(policies))
+	for i, policy := range policies {
+		names[i] = policy.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// authzLog records an authorization denial in the request log.
+type authzLog struct {
+	Context string `json:"context"`
+	Policy  string `json:"policy"`
+	Subject string `json:"subject,omitempty"`
+}
+
+// Authorize returns middleware which enforces `policy`. It must run after
+// `Authenticate`. Denied requests get 403 Forbidden, or 401 Unauthorized if
+// they aren't authenticated, and are logged with the policy's name.
+func Authorize(policy Policy) Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) Response {
+			if policy.Allow(r) {
+				return next(r)
+			}
+			if r.principal == nil {
+				return HandleError(
+					"Rejected unauthenticated request",
+					&HTTPError{
+						Status: http.StatusUnauthorized,
+						Detail: "Authentication is required.",
+					},
+					authzLog{
+						Context: "Authorization denied",
+						Policy:  policy.Name,
+					},
+				)
+			}
+			return HandleError(
+				"Rejected unauthorized request",
+				&HTTPError{
+					Status: http.StatusForbidden,
+					Detail: "You don't have permission for this resource.",
+				},
+				authzLog{
+					Context: "Authorization denied",
+					Policy:  policy.Name,
+					Subject: r.principal.Subject,
+				},
+			)
+		}
+	}
+}
+
+// RouteInfo describes a registered route. See `Router.Routes`.
+type RouteInfo struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+
+	// Requires is the name of the route's policy, if any.
+	Requires string `json:"requires,omitempty"`
+}
+
+// Routes lists the router's routes in registration order, e.g., for
+// documentation or for auditing which routes are protected.
+func (r *Router) Routes() []RouteInfo {
+	return append([]RouteInfo(nil), r.routes...)
+}
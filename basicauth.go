@@ -0,0 +1,154 @@
+package httpeasy
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicAuth authenticates requests with HTTP Basic credentials (RFC 7617).
+// Basic credentials are sent in the clear, so only use it over TLS.
+type BasicAuth struct {
+	// Realm is the protection space reported in challenges. Defaults to
+	// `restricted`.
+	Realm string
+
+	// Verify reports whether the username and password are valid. See
+	// `BasicCredentials` and `Htpasswd`.
+	Verify func(username, password string) bool
+}
+
+// Authenticate implements Authenticator.
+func (a BasicAuth) Authenticate(r Request) (*Principal, error) {
+	encoded, ok := authorization(r, "Basic")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%w: malformed basic credentials",
+			ErrInvalidCredentials,
+		)
+	}
+	username, password, ok := strings.Cut(string(data), ":")
+	if !ok {
+		return nil, fmt.Errorf(
+			"%w: malformed basic credentials",
+			ErrInvalidCredentials,
+		)
+	}
+	if !a.Verify(username, password) {
+		return nil, fmt.Errorf(
+			"%w: wrong username or password",
+			ErrInvalidCredentials,
+		)
+	}
+	return &Principal{Subject: username, Scheme: "basic"}, nil
+}
+
+// Challenge implements Authenticator.
+func (a BasicAuth) Challenge(error) string {
+	realm := a.Realm
+	if realm == "" {
+		realm = "restricted"
+	}
+	return challenge("Basic", "realm", realm, "charset", "UTF-8")
+}
+
+// BasicCredentials returns a Verify function for `BasicAuth` which accepts a
+// fixed set of usernames and passwords. Credentials are compared in constant
+// time.
+func BasicCredentials(
+	credentials map[string]string,
+) func(username, password string) bool {
+	digests := make(map[string][sha256.Size]byte, len(credentials))
+	for username, password := range credentials {
+		digests[username] = sha256.Sum256([]byte(password))
+	}
+	return func(username, password string) bool {
+		// Compare against a digest even for unknown users so the timing
+		// doesn't reveal which usernames exist
+		wanted, ok := digests[username]
+		found := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(wanted[:], found[:]) == 1 && ok
+	}
+}
+
+// Htpasswd holds bcrypt password hashes by username, as in files created by
+// Apache's `htpasswd -B`. Its Verify method can be used with `BasicAuth`.
+type Htpasswd map[string][]byte
+
+// LoadHtpasswd reads an htpasswd file. See `ReadHtpasswd`.
+func LoadHtpasswd(path string) (Htpasswd, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading htpasswd file: %w", err)
+	}
+	defer file.Close()
+	return ReadHtpasswd(file)
+}
+
+// ReadHtpasswd parses htpasswd data: `username:hash` lines, ignoring blank
+// lines and `#` comments. Only bcrypt hashes (`$2a$`, `$2b$` or `$2y$`) are
+// supported, since the other htpasswd formats are too weak to rely on.
+func ReadHtpasswd(r io.Reader) (Htpasswd, error) {
+	htpasswd := Htpasswd{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(text, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf(
+				"parsing htpasswd line %d: missing `username:hash`",
+				line,
+			)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf(
+				"parsing htpasswd line %d: user `%s`: unsupported hash: %w",
+				line,
+				username,
+				err,
+			)
+		}
+		htpasswd[username] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading htpasswd: %w", err)
+	}
+	return htpasswd, nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// Verify reports whether the password matches the user's hash.
+func (h Htpasswd) Verify(username, password string) bool {
+	hash, ok := h[username]
+	if !ok {
+		// Hash anyway so the timing doesn't reveal which usernames exist
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword(
+				[]byte("httpeasy"),
+				bcrypt.DefaultCost,
+			)
+		})
+		hash = dummyHash
+	}
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	return err == nil && ok
+}
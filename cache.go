@@ -0,0 +1,448 @@
+package httpeasy
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheOptions configures the `Cache` middleware. The zero value is ready to
+// use.
+type CacheOptions struct {
+	// MaxEntries is the maximum number of responses to keep. Defaults to
+	// 1000.
+	MaxEntries int
+
+	// MaxBytes is the maximum total size of the cached response bodies.
+	// Responses larger than MaxBytes are never cached. Defaults to 64 MiB.
+	MaxBytes int64
+
+	// Now is the clock by which cached responses age, i.e., when they go
+	// stale and when they're evicted. Defaults to `time.Now`.
+	Now func() time.Time
+}
+
+// cacheableStatuses are the statuses which may be cached given explicit
+// freshness information (RFC 9110 section 15.1).
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+	http.StatusPermanentRedirect:    true,
+}
+
+// cacheLog is attached to the response logging by `Cache`.
+type cacheLog struct {
+	Context string `json:"context"`
+
+	// Status is one of `hit`, `stale` (served stale while revalidating),
+	// `miss`, `coalesced` (shared the response of a concurrent miss), or
+	// `bypass` (the request or response isn't cacheable).
+	Status string `json:"status"`
+
+	// Age is the age of the cached response in seconds.
+	Age int64 `json:"age,omitempty"`
+}
+
+// Cache returns middleware which caches responses in memory, for read-heavy
+// GET routes. It behaves like a shared HTTP cache:
+//
+// * Responses are only stored if their `Cache-Control` header (see
+//   `Response.WithCache`) gives them a `max-age` or `s-maxage`, and doesn't
+//   contain `private`, `no-store` or `no-cache`. Responses which set cookies,
+//   or which answer requests with an `Authorization` header (unless marked
+//   `public`), aren't stored.
+// * Responses are stored separately for each combination of the request
+//   headers named by their `Vary` header. `Vary: *` isn't stored.
+// * Stale responses are served for up to `stale-while-revalidate` while a
+//   single background request refreshes them.
+// * Concurrent misses for the same URL are coalesced into a single call to
+//   the handler.
+// * Requests with `Cache-Control: no-cache` or `no-store` bypass the cache.
+//
+// Cached bodies are buffered in memory, bounded by `CacheOptions` with
+// least-recently-used eviction. Whether each response was a hit or miss is
+// recorded in the request log.
+func Cache(options CacheOptions) Middleware {
+	if options.MaxEntries == 0 {
+		options.MaxEntries = 1000
+	}
+	if options.MaxBytes == 0 {
+		options.MaxBytes = 64 << 20
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+
+	return func(next Handler) Handler {
+		c := &responseCache{
+			options: options,
+			next:    next,
+			lru:     list.New(),
+			entries: map[string][]*list.Element{},
+			calls:   map[string]*cacheCall{},
+		}
+		return c.handle
+	}
+}
+
+// responseCache is the state of a `Cache` middleware instance.
+type responseCache struct {
+	options CacheOptions
+	next    Handler
+
+	lock    sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string][]*list.Element
+	size    int64
+	calls   map[string]*cacheCall
+}
+
+// cacheEntry is a cached response, stored under its request's method, host
+// and URL plus the values of the request headers named by its `Vary` header.
+type cacheEntry struct {
+	key          string
+	status       int
+	headers      http.Header
+	body         []byte
+	contentType  string
+	etag         string
+	lastModified time.Time
+	vary         []string
+	varyValues   []string
+	stored       time.Time
+	ttl          time.Duration
+	swr          time.Duration
+	refreshing   bool
+}
+
+// cacheCall is an in-flight call to the handler which concurrent misses wait
+// on. `entry` is nil if the response wasn't cacheable.
+type cacheCall struct {
+	done  chan struct{}
+	entry *cacheEntry
+}
+
+func (c *responseCache) handle(r Request) Response {
+	if r.Method != http.MethodGet || r.URL == nil {
+		return c.next(r)
+	}
+	directives := parseCacheControl(r.Headers["Cache-Control"])
+	if _, ok := directives["no-store"]; ok {
+		return c.bypass(r)
+	}
+	_, noCache := directives["no-cache"]
+	key := r.Method + " " + r.Host + " " + r.URL.String()
+
+	c.lock.Lock()
+	if !noCache {
+		if entry := c.lookup(key, r); entry != nil {
+			age := c.options.Now().Sub(entry.stored)
+			status := "hit"
+			if age > entry.ttl {
+				status = "stale"
+				c.refresh(entry, r)
+			}
+			c.lock.Unlock()
+			return entry.response(age, status)
+		}
+	}
+
+	if call, ok := c.calls[key]; ok {
+		c.lock.Unlock()
+		<-call.done
+		if call.entry != nil && call.entry.matches(r) {
+			return call.entry.response(0, "coalesced")
+		}
+		// The response can't be shared with this request
+		return c.bypass(r)
+	}
+
+	call := &cacheCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.lock.Unlock()
+	return c.miss(key, r, call)
+}
+
+// bypass calls the handler without consulting or updating the cache.
+func (c *responseCache) bypass(r Request) Response {
+	return c.next(r).WithLogging(cacheLog{
+		Context: "Response cache",
+		Status:  "bypass",
+	})
+}
+
+// miss calls the handler on behalf of `call` and stores the response if it's
+// cacheable.
+func (c *responseCache) miss(key string, r Request, call *cacheCall) Response {
+	defer func() {
+		c.lock.Lock()
+		delete(c.calls, key)
+		c.lock.Unlock()
+		close(call.done)
+	}()
+
+	entry, rsp := c.store(key, r, c.next(r))
+	if entry == nil {
+		return rsp.WithLogging(cacheLog{
+			Context: "Response cache",
+			Status:  "bypass",
+		})
+	}
+	call.entry = entry
+	return rsp
+}
+
+// store buffers and stores `rsp` if it's cacheable, returning the new entry
+// (or nil) and the response to send in place of `rsp`.
+func (c *responseCache) store(key string, r Request, rsp Response) (
+	*cacheEntry,
+	Response,
+) {
+	ttl, swr, ok := cacheLifetime(r, rsp)
+	if !ok {
+		return nil, rsp
+	}
+
+	writerTo, err := rsp.Data()
+	if err != nil {
+		rsp.Data = func() (io.WriterTo, error) { return nil, err }
+		return nil, rsp
+	}
+	var buf bytes.Buffer
+	if _, err := writerTo.WriteTo(&buf); err != nil {
+		rsp.Data = func() (io.WriterTo, error) { return nil, err }
+		return nil, rsp
+	}
+
+	entry := &cacheEntry{
+		key:          key,
+		status:       rsp.Status,
+		headers:      rsp.Headers.Clone(),
+		body:         buf.Bytes(),
+		contentType:  contentType(writerTo),
+		etag:         rsp.ETag,
+		lastModified: rsp.LastModified,
+		vary:         varyHeaders(rsp.Headers),
+		stored:       c.options.Now(),
+		ttl:          ttl,
+		swr:          swr,
+	}
+	for _, name := range entry.vary {
+		entry.varyValues = append(
+			entry.varyValues,
+			strings.Join(r.Headers.Values(name), ", "),
+		)
+	}
+
+	if int64(len(entry.body)) <= c.options.MaxBytes {
+		c.lock.Lock()
+		c.insert(entry)
+		c.lock.Unlock()
+	}
+
+	// The handler's logging is kept for the response which populated the
+	// cache.
+	served := entry.response(0, "miss")
+	served.Logging = append(rsp.Logging, served.Logging...)
+	return entry, served
+}
+
+// refresh fetches a fresh copy of a stale entry in the background. It must be
+// called with the lock held.
+func (c *responseCache) refresh(entry *cacheEntry, r Request) {
+	if entry.refreshing {
+		return
+	}
+	entry.refreshing = true
+	r = r.detach()
+	go func() {
+		defer func() {
+			// A panicking handler mustn't crash the program. Whether or not
+			// the entry was replaced, a later request may try again.
+			recover()
+			r.cleanup.run()
+			c.lock.Lock()
+			entry.refreshing = false
+			c.lock.Unlock()
+		}()
+		c.store(entry.key, r, c.next(r))
+	}()
+}
+
+// lookup returns the usable entry which matches `r`, evicting any which have
+// expired. It must be called with the lock held.
+func (c *responseCache) lookup(key string, r Request) *cacheEntry {
+	now := c.options.Now()
+	for _, element := range c.entries[key] {
+		entry := element.Value.(*cacheEntry)
+		if now.Sub(entry.stored) > entry.ttl+entry.swr {
+			c.remove(element)
+			continue
+		}
+		if entry.matches(r) {
+			c.lru.MoveToFront(element)
+			return entry
+		}
+	}
+	return nil
+}
+
+// insert adds an entry, replacing any entry for the same variant and evicting
+// the least recently used entries to stay within bounds. It must be called
+// with the lock held.
+func (c *responseCache) insert(entry *cacheEntry) {
+	for _, element := range c.entries[entry.key] {
+		if element.Value.(*cacheEntry).sameVariant(entry) {
+			c.remove(element)
+			break
+		}
+	}
+
+	element := c.lru.PushFront(entry)
+	c.entries[entry.key] = append(c.entries[entry.key], element)
+	c.size += int64(len(entry.body))
+
+	for c.lru.Len() > c.options.MaxEntries || c.size > c.options.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes an entry. It must be called with the lock held.
+func (c *responseCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*cacheEntry)
+	c.size -= int64(len(entry.body))
+
+	elements := c.entries[entry.key]
+	for i := range elements {
+		if elements[i] == element {
+			elements = append(elements[:i], elements[i+1:]...)
+			break
+		}
+	}
+	if len(elements) < 1 {
+		delete(c.entries, entry.key)
+		return
+	}
+	c.entries[entry.key] = elements
+}
+
+// matches reports whether the entry can be served for `r` per its `Vary`
+// header.
+func (entry *cacheEntry) matches(r Request) bool {
+	for i, name := range entry.vary {
+		if strings.Join(r.Headers.Values(name), ", ") != entry.varyValues[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (entry *cacheEntry) sameVariant(other *cacheEntry) bool {
+	if len(entry.vary) != len(other.vary) {
+		return false
+	}
+	for i := range entry.vary {
+		if entry.vary[i] != other.vary[i] ||
+			entry.varyValues[i] != other.varyValues[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// response builds a response from the entry.
+func (entry *cacheEntry) response(age time.Duration, status string) Response {
+	headers := entry.headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	if age > 0 {
+		headers.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	}
+	return Response{
+		Status: entry.status,
+		Data: func() (io.WriterTo, error) {
+			return bufferedWriterTo{
+				bytes.NewBuffer(entry.body),
+				entry.contentType,
+			}, nil
+		},
+		Headers:      headers,
+		ETag:         entry.etag,
+		LastModified: entry.lastModified,
+		Logging: []interface{}{cacheLog{
+			Context: "Response cache",
+			Status:  status,
+			Age:     int64(age / time.Second),
+		}},
+	}
+}
+
+// cacheLifetime returns how long `rsp` stays fresh and how long it may be
+// served stale while revalidating, or false if it mustn't be cached.
+func cacheLifetime(r Request, rsp Response) (
+	time.Duration,
+	time.Duration,
+	bool,
+) {
+	if !cacheableStatuses[rsp.Status] || len(rsp.Cookies) > 0 ||
+		len(rsp.secureCookies) > 0 || len(rsp.Headers["Set-Cookie"]) > 0 ||
+		rsp.Trailers != nil {
+		return 0, 0, false
+	}
+
+	directives := parseCacheControl(rsp.Headers["Cache-Control"])
+	for _, name := range []string{"private", "no-store", "no-cache"} {
+		if _, ok := directives[name]; ok {
+			return 0, 0, false
+		}
+	}
+	if _, public := directives["public"]; !public &&
+		r.Headers.Get("Authorization") != "" {
+		return 0, 0, false
+	}
+	for _, name := range varyHeaders(rsp.Headers) {
+		if name == "*" {
+			return 0, 0, false
+		}
+	}
+
+	ttl, ok := directiveSeconds(directives, "s-maxage")
+	if !ok {
+		if ttl, ok = directiveSeconds(directives, "max-age"); !ok {
+			return 0, 0, false
+		}
+	}
+	swr, _ := directiveSeconds(directives, "stale-while-revalidate")
+	if _, ok := directives["must-revalidate"]; ok {
+		swr = 0
+	}
+	return ttl, swr, true
+}
+
+// varyHeaders returns the canonical names of the request headers listed in
+// the response's `Vary` header.
+func varyHeaders(headers http.Header) []string {
+	var names []string
+	for _, value := range headers["Vary"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
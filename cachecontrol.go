@@ -0,0 +1,133 @@
+package httpeasy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheControl describes a `Cache-Control` response header. Zero values are
+// omitted, so only the directives which are set are rendered:
+//
+//     rsp := Ok(JSON(catalog)).WithCache(CacheControl{
+//         Public:               true,
+//         MaxAge:               time.Minute,
+//         StaleWhileRevalidate: 10 * time.Minute,
+//     })
+//
+// Durations are rendered in whole seconds. To require revalidation on every
+// use (i.e., `max-age=0`), set NoCache.
+type CacheControl struct {
+	// MaxAge is how long the response stays fresh (`max-age`).
+	MaxAge time.Duration
+
+	// SMaxAge overrides MaxAge for shared caches such as CDNs and `Cache`
+	// (`s-maxage`).
+	SMaxAge time.Duration
+
+	// StaleWhileRevalidate is how long after the response goes stale that a
+	// cache may keep serving it while it fetches a fresh one in the
+	// background (`stale-while-revalidate`).
+	StaleWhileRevalidate time.Duration
+
+	// Public allows shared caches to store the response even if they
+	// otherwise wouldn't (e.g., because the request had an `Authorization`
+	// header).
+	Public bool
+
+	// Private forbids shared caches from storing the response. Browsers may
+	// still store it.
+	Private bool
+
+	// NoCache requires caches to revalidate the response before every use.
+	NoCache bool
+
+	// NoStore forbids all caches from storing the response.
+	NoStore bool
+
+	// MustRevalidate forbids caches from serving the response once it's
+	// stale.
+	MustRevalidate bool
+
+	// Immutable indicates the response won't change while it's fresh, so
+	// clients needn't revalidate it (e.g., when the user reloads the page).
+	Immutable bool
+}
+
+// String renders the `Cache-Control` header value.
+func (cc CacheControl) String() string {
+	var directives []string
+	flag := func(set bool, directive string) {
+		if set {
+			directives = append(directives, directive)
+		}
+	}
+	seconds := func(d time.Duration, directive string) {
+		if d > 0 {
+			directives = append(
+				directives,
+				directive+"="+strconv.FormatInt(int64(d/time.Second), 10),
+			)
+		}
+	}
+
+	flag(cc.Public, "public")
+	flag(cc.Private, "private")
+	flag(cc.NoCache, "no-cache")
+	flag(cc.NoStore, "no-store")
+	seconds(cc.MaxAge, "max-age")
+	seconds(cc.SMaxAge, "s-maxage")
+	seconds(cc.StaleWhileRevalidate, "stale-while-revalidate")
+	flag(cc.MustRevalidate, "must-revalidate")
+	flag(cc.Immutable, "immutable")
+	return strings.Join(directives, ", ")
+}
+
+// WithCache returns a copy of the response with its `Cache-Control` header
+// set to `cc`, replacing any existing value.
+func (r Response) WithCache(cc CacheControl) Response {
+	if r.Headers == nil {
+		r.Headers = http.Header{}
+	}
+	r.Headers.Set("Cache-Control", cc.String())
+	return r
+}
+
+// parseCacheControl parses `Cache-Control` header values into a map from
+// lowercased directive names to their (unquoted) arguments.
+func parseCacheControl(values []string) map[string]string {
+	directives := map[string]string{}
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name = strings.TrimSpace(directive[:i])
+				arg = strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			directives[strings.ToLower(name)] = arg
+		}
+	}
+	return directives
+}
+
+// directiveSeconds returns the duration argument of a directive such as
+// `max-age`.
+func directiveSeconds(directives map[string]string, name string) (
+	time.Duration,
+	bool,
+) {
+	arg, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
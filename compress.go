@@ -0,0 +1,311 @@
+package httpeasy
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Encoder is an HTTP content-coding which can be used to compress response
+// bodies. Gzip and Deflate are provided out of the box; other codings (e.g.,
+// brotli or zstd) can be plugged in by wrapping a third party implementation:
+//
+//     brotliEncoder := Encoder{
+//         Name: "br",
+//         New: func(w io.Writer) (io.WriteCloser, error) {
+//             return brotli.NewWriter(w), nil
+//         },
+//     }
+//
+type Encoder struct {
+	// Name is the content-coding token as it appears in the
+	// `Accept-Encoding` and `Content-Encoding` headers, e.g., `gzip`.
+	Name string
+
+	// New wraps `w` in a compressing writer. Closing the returned writer must
+	// flush any buffered data to `w` but must not close `w`.
+	New func(w io.Writer) (io.WriteCloser, error)
+}
+
+// Gzip is the `gzip` content-coding.
+var Gzip = Encoder{
+	Name: "gzip",
+	New: func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	},
+}
+
+// Deflate is the `deflate` content-coding. Note that HTTP's `deflate` is
+// the zlib format (RFC 1950), not a raw deflate stream.
+var Deflate = Encoder{
+	Name: "deflate",
+	New: func(w io.Writer) (io.WriteCloser, error) {
+		return zlib.NewWriter(w), nil
+	},
+}
+
+// DefaultIncompressibleTypes are the media types which `Compress` skips by
+// default because they are already compressed. Entries ending in a `/` match
+// every subtype.
+var DefaultIncompressibleTypes = []string{
+	"image/gif",
+	"image/jpeg",
+	"image/png",
+	"image/webp",
+	"audio/",
+	"video/",
+	"font/woff",
+	"font/woff2",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+}
+
+// CompressOptions configures the `Compress` middleware. The zero value is
+// ready to use.
+type CompressOptions struct {
+	// Encoders are the content-codings offered by the server in order of
+	// preference. The server's preference is only used to break ties between
+	// codings which the client weighs equally. Defaults to Gzip and Deflate.
+	Encoders []Encoder
+
+	// MinSize is the size in bytes below which responses are sent
+	// uncompressed. It only applies when the size of the serialized body is
+	// known up front (e.g., `String()`, `Bytes()`, `JSON()` and the template
+	// serializers); bodies of unknown size are always compressed. Defaults to
+	// 1024. Use a negative value to compress everything.
+	MinSize int
+
+	// IncompressibleTypes are the media types which will not be compressed
+	// (see `DefaultIncompressibleTypes` for the format). The response's
+	// `Content-Type` header is only consulted if the handler set it. Defaults
+	// to `DefaultIncompressibleTypes`.
+	IncompressibleTypes []string
+}
+
+// compressionLog is attached to the response logging by `Compress`. The sizes
+// are filled in as the body is written, which happens before the request log
+// is written.
+type compressionLog struct {
+	Context           string `json:"context"`
+	Encoding          string `json:"encoding"`
+	Skipped           string `json:"skipped,omitempty"`
+	UncompressedBytes int64  `json:"uncompressedBytes"`
+	CompressedBytes   int64  `json:"compressedBytes"`
+}
+
+// Compress returns middleware which compresses response bodies using the
+// content-coding that best satisfies the request's `Accept-Encoding` header.
+// Responses always get a `Vary: Accept-Encoding` header so caches don't serve
+// a compressed body to a client which didn't ask for one. The chosen coding
+// and the compressed and uncompressed sizes are recorded in the request log.
+func Compress(options CompressOptions) Middleware {
+	if options.Encoders == nil {
+		options.Encoders = []Encoder{Gzip, Deflate}
+	}
+	if options.MinSize == 0 {
+		options.MinSize = 1024
+	}
+	if options.IncompressibleTypes == nil {
+		options.IncompressibleTypes = DefaultIncompressibleTypes
+	}
+
+	return func(next Handler) Handler {
+		return func(r Request) Response {
+			rsp := next(r).WithHeaders(http.Header{
+				"Vary": []string{"Accept-Encoding"},
+			})
+
+			if rsp.Status < 200 ||
+				rsp.Status == http.StatusNoContent ||
+				rsp.Status == http.StatusPartialContent ||
+				rsp.Status == http.StatusNotModified ||
+				rsp.Headers.Get("Content-Encoding") != "" ||
+				incompressible(
+					rsp.Headers.Get("Content-Type"),
+					options.IncompressibleTypes,
+				) {
+				return rsp
+			}
+
+			encoder, ok := negotiateEncoding(
+				r.Headers["Accept-Encoding"],
+				options.Encoders,
+			)
+			if !ok {
+				return rsp
+			}
+
+			log := &compressionLog{
+				Context:  "Compressing response body",
+				Encoding: encoder.Name,
+			}
+			headers, data := rsp.Headers, rsp.Data
+			rsp.Data = func() (io.WriterTo, error) {
+				writerTo, err := data()
+				if err != nil {
+					return nil, err
+				}
+				if headers.Get("Content-Type") == "" && incompressible(
+					contentType(writerTo),
+					options.IncompressibleTypes,
+				) {
+					log.Skipped = "incompressible content type"
+					return writerTo, nil
+				}
+				if sized, ok := writerTo.(interface{ Len() int }); ok {
+					if sized.Len() < options.MinSize {
+						log.Skipped = "body smaller than minimum size"
+						log.UncompressedBytes = int64(sized.Len())
+						log.CompressedBytes = log.UncompressedBytes
+						return writerTo, nil
+					}
+				}
+
+				// `Handler.HTTP` copies the response headers after invoking
+				// the serializer, so these take effect.
+				headers.Set("Content-Encoding", encoder.Name)
+				headers.Del("Content-Length")
+				return compressedWriterTo{writerTo, encoder, log}, nil
+			}
+			return rsp.WithLogging(log)
+		}
+	}
+}
+
+type compressedWriterTo struct {
+	inner   io.WriterTo
+	encoder Encoder
+	log     *compressionLog
+}
+
+func (cwt compressedWriterTo) ContentType() string {
+	return contentType(cwt.inner)
+}
+
+func (cwt compressedWriterTo) WriteTo(w io.Writer) (int64, error) {
+	compressed := &countingWriter{w: w}
+	zw, err := cwt.encoder.New(compressed)
+	if err != nil {
+		return 0, err
+	}
+
+	uncompressed := &countingWriter{w: flushWriter{zw, compressed}}
+	_, err = cwt.inner.WriteTo(uncompressed)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+
+	cwt.log.UncompressedBytes = uncompressed.n
+	cwt.log.CompressedBytes = compressed.n
+	return compressed.n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Flush flushes the underlying writer, if it can be flushed.
+func (cw *countingWriter) Flush() error { return flush(cw.w) }
+
+// flushWriter writes to a buffering writer (e.g., a compressor) and flushes it
+// through to the writer beneath it.
+type flushWriter struct {
+	io.Writer
+	next io.Writer
+}
+
+func (fw flushWriter) Flush() error {
+	if err := flush(fw.Writer); err != nil {
+		return err
+	}
+	return flush(fw.next)
+}
+
+// flush flushes `w` if it supports flushing (e.g., an `http.ResponseWriter`
+// or a `*gzip.Writer`) so buffered output goes out to the client.
+func flush(w io.Writer) error {
+	switch f := w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case http.Flusher:
+		f.Flush()
+	}
+	return nil
+}
+
+// negotiateEncoding picks the encoder with the highest weight in the
+// `Accept-Encoding` header, using the order of `encoders` to break ties. It
+// returns false if the client didn't send the header, if no encoder is
+// acceptable, or if the client prefers the identity coding.
+func negotiateEncoding(
+	acceptEncoding []string,
+	encoders []Encoder,
+) (Encoder, bool) {
+	items := parseAccept(acceptEncoding)
+	weight := func(coding string) (float64, bool) {
+		wildcard, wildcardFound := 0.0, false
+		for _, item := range items {
+			if item.value == coding {
+				return item.q, true
+			}
+			if item.value == "*" {
+				wildcard, wildcardFound = item.q, true
+			}
+		}
+		return wildcard, wildcardFound
+	}
+
+	var best Encoder
+	bestQ := 0.0
+	for _, encoder := range encoders {
+		if q, ok := weight(strings.ToLower(encoder.Name)); ok && q > bestQ {
+			best, bestQ = encoder, q
+		}
+	}
+	if bestQ == 0 {
+		return Encoder{}, false
+	}
+
+	// Only an explicitly-weighted identity coding can beat a compressed one.
+	for _, item := range items {
+		if item.value == "identity" && item.q > bestQ {
+			return Encoder{}, false
+		}
+	}
+	return best, true
+}
+
+// incompressible reports whether the media type in `contentType` matches any
+// of `types`.
+func incompressible(contentType string, types []string) bool {
+	if contentType == "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if mediaType == t ||
+			strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package httpeasy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CheckPreconditions evaluates the request's conditional headers
+// (`If-Match`, `If-Unmodified-Since`, `If-None-Match` and
+// `If-Modified-Since`) against the current state of the target resource per
+// RFC 9110 section 13.2.2. `etag` is the resource's current entity-tag (see
+// `Response.ETag` for the format) and `lastModified` is its modification time;
+// either may be left empty if it isn't known. An empty `etag` also means the
+// resource doesn't exist yet, so `If-None-Match: *` lets a PUT create it and
+// `If-Match: *` doesn't.
+//
+// If the request should proceed, the returned bool is true. Otherwise the
+// returned response is a 304 Not Modified (for GET and HEAD requests) or a 412
+// Precondition Failed which should be returned as-is. Handlers for unsafe
+// methods should call this before making any changes, which gives clients
+// optimistic concurrency control via `If-Match`:
+//
+//     func updateWidget(r Request) Response {
+//         widget, err := store.Get(r.Vars["id"])
+//         if err != nil {
+//             return HandleError("fetching widget", err)
+//         }
+//         rsp, ok := r.CheckPreconditions(widget.Version, time.Time{})
+//         if !ok {
+//             return rsp
+//         }
+//         ...
+//     }
+//
+func (r Request) CheckPreconditions(
+	etag string,
+	lastModified time.Time,
+) (Response, bool) {
+	etag = quoteETag(etag)
+	lastModified = lastModified.Truncate(time.Second)
+	safe := r.Method == "GET" || r.Method == "HEAD"
+	notModified := func() (Response, bool) {
+		rsp := NotModified()
+		rsp.ETag, rsp.LastModified = etag, lastModified
+		return rsp, false
+	}
+
+	if ifMatch := r.Headers.Get("If-Match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, false) {
+			return PreconditionFailed(nil), false
+		}
+	} else if since, ok := headerTime(r.Headers, "If-Unmodified-Since"); ok {
+		if !lastModified.IsZero() && lastModified.After(since) {
+			return PreconditionFailed(nil), false
+		}
+	}
+
+	if ifNoneMatch := r.Headers.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, etag, true) {
+			if safe {
+				return notModified()
+			}
+			return PreconditionFailed(nil), false
+		}
+	} else if since, ok := headerTime(r.Headers, "If-Modified-Since"); ok {
+		if safe && !lastModified.IsZero() && !lastModified.After(since) {
+			return notModified()
+		}
+	}
+
+	return Response{}, true
+}
+
+// ConditionalOptions configures the `Conditional` middleware.
+type ConditionalOptions struct {
+	// AutoETag causes responses without an `ETag` to be buffered and hashed
+	// to produce a weak entity-tag. It's weak because it's computed before
+	// any content-coding is applied (e.g., by `Compress`), so each coding of
+	// the body shares it. This costs a full buffer of the body, so it's best
+	// suited to small or medium-sized representations.
+	AutoETag bool
+}
+
+// Conditional returns middleware which answers conditional GET and HEAD
+// requests. If the handler's 200 OK response satisfies the request's
+// `If-None-Match` or `If-Modified-Since` header, it is replaced with a 304 Not
+// Modified; if it fails `If-Match` or `If-Unmodified-Since`, it's replaced with
+// a 412 Precondition Failed. The response's validators come from its `ETag`
+// and `LastModified` fields (or from hashing the body, see
+// `ConditionalOptions.AutoETag`). Cookies set by the handler or inner
+// middleware (e.g., a renewed session) are kept on the replacement.
+//
+// Requests with other methods are passed through untouched; those handlers
+// should call `Request.CheckPreconditions` before making any changes.
+func Conditional(options ConditionalOptions) Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) Response {
+			rsp := next(r)
+			if r.Method != "GET" && r.Method != "HEAD" ||
+				rsp.Status != http.StatusOK {
+				return rsp
+			}
+
+			if rsp.ETag == "" && options.AutoETag {
+				writerTo, err := rsp.Data()
+				if err != nil {
+					rsp.Data = func() (io.WriterTo, error) { return nil, err }
+					return rsp
+				}
+				var buf bytes.Buffer
+				if _, err := writerTo.WriteTo(&buf); err != nil {
+					rsp.Data = func() (io.WriterTo, error) { return nil, err }
+					return rsp
+				}
+				sum := sha256.Sum256(buf.Bytes())
+				rsp.ETag = `W/"` +
+					base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+				rsp.Data = Bytes(buf.Bytes())
+			}
+
+			pre, ok := r.CheckPreconditions(rsp.ETag, rsp.LastModified)
+			if ok {
+				return rsp
+			}
+
+			// A 304 carries the headers that would have been sent with the
+			// 200 so caches can update their stored response.
+			if pre.Status == http.StatusNotModified {
+				for _, key := range []string{
+					"Cache-Control",
+					"Content-Location",
+					"Expires",
+					"Vary",
+				} {
+					if values, found := rsp.Headers[key]; found {
+						pre = pre.WithHeaders(http.Header{key: values})
+					}
+				}
+			}
+
+			// The cookies would be lost with the body, e.g., a session whose
+			// ID was just renewed.
+			pre.Cookies = rsp.Cookies
+			pre.secureCookies = rsp.secureCookies
+			if values, found := rsp.Headers["Set-Cookie"]; found {
+				pre = pre.WithHeaders(http.Header{"Set-Cookie": values})
+			}
+			return pre.WithLogging(rsp.Logging...)
+		}
+	}
+}
+
+// quoteETag quotes a bare entity-tag as a strong validator. Entity-tags which
+// are already quoted (or weak) are returned as-is.
+func quoteETag(etag string) string {
+	if etag == "" ||
+		strings.HasPrefix(etag, `"`) ||
+		strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// etagListMatches reports whether `etag` matches any entity-tag in `list` (the
+// value of an `If-Match` or `If-None-Match` header). Weak comparison is used
+// for `If-None-Match` and strong comparison for `If-Match`. A `*` matches any
+// current representation, which we assume exists if the caller knows its
+// entity-tag.
+func etagListMatches(list, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range splitETags(list) {
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") ==
+				strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+		if candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitETags splits a comma-separated list of entity-tags. Commas are legal
+// within an entity-tag, so it can't just split on commas.
+func splitETags(list string) []string {
+	var etags []string
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return etags
+		}
+		start := 0
+		if strings.HasPrefix(list, "W/") {
+			start = 2
+		}
+		if len(list) <= start || list[start] != '"' {
+			// Malformed; skip to the next element.
+			if i := strings.IndexByte(list, ','); i >= 0 {
+				list = list[i:]
+				continue
+			}
+			return etags
+		}
+		end := strings.IndexByte(list[start+1:], '"')
+		if end < 0 {
+			return etags
+		}
+		end += start + 2
+		etags = append(etags, list[:end])
+		list = list[end:]
+	}
+}
+
+// headerTime parses an HTTP-date header, reporting false if it's missing or
+// invalid (invalid dates must be ignored per RFC 9110).
+func headerTime(headers http.Header, key string) (time.Time, bool) {
+	value := headers.Get(key)
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
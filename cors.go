@@ -0,0 +1,255 @@
+package httpeasy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures cross-origin resource sharing, either for every
+// route via `Router.CORS` or for individual handlers via the `CORS`
+// middleware.
+type CORSOptions struct {
+	// AllowedOrigins are the origins which may make cross-origin requests.
+	// Entries are exact origins (`https://app.example.com`), wildcard
+	// subdomains (`https://*.example.com`, which doesn't match
+	// `https://example.com` itself), or `*` for any origin.
+	AllowedOrigins []string
+
+	// AllowOrigin, if set, is consulted for origins which don't match
+	// AllowedOrigins.
+	AllowOrigin func(origin string) bool
+
+	// AllowedMethods are the methods cross-origin requests may use. For
+	// preflights answered by a Router, it defaults to the methods the router
+	// has routes for on the requested path (and, if set, is intersected with
+	// them); otherwise it defaults to GET, HEAD and POST.
+	AllowedMethods []string
+
+	// AllowedHeaders are the request headers cross-origin requests may send
+	// beyond the CORS-safelisted ones. `*` allows any header.
+	AllowedHeaders []string
+
+	// ExposedHeaders are the response headers scripts may read beyond the
+	// CORS-safelisted ones.
+	ExposedHeaders []string
+
+	// AllowCredentials allows cross-origin requests to include cookies and
+	// HTTP authentication. The allowed origin is always echoed rather than
+	// `*` in this case, since browsers reject credentialed responses which
+	// allow any origin.
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache preflight responses. Zero leaves
+	// it up to the browser (typically 5 seconds).
+	MaxAge time.Duration
+}
+
+// CORS returns middleware which adds CORS headers to the handler's responses
+// for allowed origins. It also answers preflight requests which reach the
+// handler (e.g., if it's registered for OPTIONS), but since routes match a
+// single method, preflights are usually answered by the router; see
+// `Router.CORS`.
+func CORS(options CORSOptions) Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) Response {
+			if isPreflight(r) {
+				return options.preflight(r, nil)
+			}
+			return options.apply(r, next(r))
+		}
+	}
+}
+
+// isPreflight reports whether the request is a CORS preflight request.
+func isPreflight(r Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Headers.Get("Origin") != "" &&
+		r.Headers.Get("Access-Control-Request-Method") != ""
+}
+
+// allowsAnyOrigin reports whether AllowedOrigins contains `*`.
+func (options *CORSOptions) allowsAnyOrigin() bool {
+	for _, allowed := range options.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedOrigin returns the value of the `Access-Control-Allow-Origin` header
+// for `origin`, or "" if it isn't allowed.
+func (options *CORSOptions) allowedOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	if options.allowsAnyOrigin() {
+		if options.AllowCredentials {
+			return origin
+		}
+		return "*"
+	}
+	for _, allowed := range options.AllowedOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+		if i := strings.Index(allowed, "://*."); i >= 0 {
+			scheme, suffix := allowed[:i+3], allowed[i+4:]
+			if len(origin) > len(scheme)+len(suffix) &&
+				strings.EqualFold(origin[:len(scheme)], scheme) &&
+				strings.HasSuffix(
+					strings.ToLower(origin),
+					strings.ToLower(suffix),
+				) {
+				return origin
+			}
+		}
+	}
+	if options.AllowOrigin != nil && options.AllowOrigin(origin) {
+		return origin
+	}
+	return ""
+}
+
+// apply adds the CORS headers for an actual (i.e., non-preflight) request to
+// the response. Responses which already have an `Access-Control-Allow-Origin`
+// header are left alone, so handler-level configuration takes precedence.
+func (options *CORSOptions) apply(r Request, rsp Response) Response {
+	if rsp.Headers.Get("Access-Control-Allow-Origin") != "" {
+		return rsp
+	}
+	origin := r.Headers.Get("Origin")
+	allowed := options.allowedOrigin(origin)
+
+	// The response depends on the Origin unless every origin gets the same
+	// answer.
+	headers := rsp.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	if allowed != "*" {
+		headers.Add("Vary", "Origin")
+	}
+	if allowed != "" {
+		headers.Set("Access-Control-Allow-Origin", allowed)
+		if options.AllowCredentials {
+			headers.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if len(options.ExposedHeaders) > 0 {
+			headers.Set(
+				"Access-Control-Expose-Headers",
+				strings.Join(options.ExposedHeaders, ", "),
+			)
+		}
+	}
+	rsp.Headers = headers
+	return rsp
+}
+
+// corsLog is attached to preflight responses which deny the request.
+type corsLog struct {
+	Context string `json:"context"`
+	Origin  string `json:"origin"`
+	Method  string `json:"method"`
+	Reason  string `json:"reason"`
+}
+
+// preflight answers a preflight request. `routed` are the methods the router
+// has routes for on the request's path, or nil if the answer doesn't come
+// from a router. Denied preflights get a response without CORS headers, which
+// the browser treats as a failure.
+func (options *CORSOptions) preflight(r Request, routed []string) Response {
+	origin := r.Headers.Get("Origin")
+	method := r.Headers.Get("Access-Control-Request-Method")
+	headers := http.Header{
+		"Vary": []string{
+			"Origin, Access-Control-Request-Method, " +
+				"Access-Control-Request-Headers",
+		},
+	}
+	deny := func(reason string) Response {
+		return NoContent(corsLog{
+			Context: "Denied CORS preflight",
+			Origin:  origin,
+			Method:  method,
+			Reason:  reason,
+		}).WithHeaders(headers)
+	}
+
+	allowed := options.allowedOrigin(origin)
+	if allowed == "" {
+		return deny("origin not allowed")
+	}
+
+	methods := options.AllowedMethods
+	switch {
+	case routed != nil && methods == nil:
+		methods = routed
+	case routed != nil:
+		methods = intersectMethods(methods, routed)
+	case methods == nil:
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	if !containsFold(methods, method) {
+		return deny("method not allowed")
+	}
+
+	var requested []string
+	for _, name := range strings.Split(
+		r.Headers.Get("Access-Control-Request-Headers"),
+		",",
+	) {
+		if name = strings.TrimSpace(name); name != "" {
+			requested = append(requested, name)
+		}
+	}
+	if !containsFold(options.AllowedHeaders, "*") {
+		for _, name := range requested {
+			if !containsFold(options.AllowedHeaders, name) {
+				return deny("header `" + name + "` not allowed")
+			}
+		}
+	}
+
+	headers.Set("Access-Control-Allow-Origin", allowed)
+	headers.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(requested) > 0 {
+		headers.Set(
+			"Access-Control-Allow-Headers",
+			strings.Join(requested, ", "),
+		)
+	}
+	if options.AllowCredentials {
+		headers.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if options.MaxAge > 0 {
+		headers.Set(
+			"Access-Control-Max-Age",
+			strconv.Itoa(int(options.MaxAge/time.Second)),
+		)
+	}
+	return NoContent().WithHeaders(headers)
+}
+
+// intersectMethods returns the methods in `methods` which are also in
+// `routed`.
+func intersectMethods(methods, routed []string) []string {
+	intersection := []string{}
+	for _, method := range methods {
+		if containsFold(routed, method) {
+			intersection = append(intersection, method)
+		}
+	}
+	return intersection
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
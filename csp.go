@@ -0,0 +1,271 @@
+package httpeasy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Source expressions for CSP directives. CSPNonce is replaced with the
+// request's nonce (see `Request.CSPNonce`) when the policy is written.
+const (
+	CSPSelf           = "'self'"
+	CSPNone           = "'none'"
+	CSPUnsafeInline   = "'unsafe-inline'"
+	CSPUnsafeEval     = "'unsafe-eval'"
+	CSPStrictDynamic  = "'strict-dynamic'"
+	CSPReportSample   = "'report-sample'"
+	CSPNonce          = "'nonce'"
+	CSPUnsafeHashes   = "'unsafe-hashes'"
+	CSPWasmUnsafeEval = "'wasm-unsafe-eval'"
+)
+
+// CSP is a Content-Security-Policy. Each field is a directive whose sources
+// are written in order; empty directives are omitted. For example, a strict
+// nonce-based policy:
+//
+//     CSP{
+//         DefaultSrc: []string{CSPSelf},
+//         ScriptSrc:  []string{CSPNonce, CSPStrictDynamic},
+//         ObjectSrc:  []string{CSPNone},
+//         BaseURI:    []string{CSPNone},
+//         ReportURI:  "/csp-reports",
+//     }
+//
+type CSP struct {
+	DefaultSrc     []string
+	ScriptSrc      []string
+	StyleSrc       []string
+	ImgSrc         []string
+	ConnectSrc     []string
+	FontSrc        []string
+	ObjectSrc      []string
+	MediaSrc       []string
+	FrameSrc       []string
+	WorkerSrc      []string
+	ManifestSrc    []string
+	FrameAncestors []string
+	FormAction     []string
+	BaseURI        []string
+
+	// UpgradeInsecureRequests instructs browsers to fetch HTTP resources
+	// over HTTPS.
+	UpgradeInsecureRequests bool
+
+	// ReportURI is where browsers send violation reports (see
+	// `CSPReportHandler`), via the `report-uri` directive.
+	ReportURI string
+
+	// ReportTo is the name of a Reporting API endpoint group (declared in a
+	// `Reporting-Endpoints` header) to send violation reports to, via the
+	// `report-to` directive.
+	ReportTo string
+}
+
+// String renders the policy with CSPNonce sources replaced by `nonce`'s
+// source expression. If `nonce` is empty, CSPNonce sources are dropped.
+func (csp CSP) String(nonce string) string {
+	var directives []string
+	add := func(name string, sources []string) {
+		if len(sources) < 1 {
+			return
+		}
+		values := make([]string, 0, len(sources))
+		for _, source := range sources {
+			if source == CSPNonce {
+				if nonce == "" {
+					continue
+				}
+				source = "'nonce-" + nonce + "'"
+			}
+			values = append(values, source)
+		}
+		directives = append(
+			directives,
+			strings.Join(append([]string{name}, values...), " "),
+		)
+	}
+	add("default-src", csp.DefaultSrc)
+	add("script-src", csp.ScriptSrc)
+	add("style-src", csp.StyleSrc)
+	add("img-src", csp.ImgSrc)
+	add("connect-src", csp.ConnectSrc)
+	add("font-src", csp.FontSrc)
+	add("object-src", csp.ObjectSrc)
+	add("media-src", csp.MediaSrc)
+	add("frame-src", csp.FrameSrc)
+	add("worker-src", csp.WorkerSrc)
+	add("manifest-src", csp.ManifestSrc)
+	add("frame-ancestors", csp.FrameAncestors)
+	add("form-action", csp.FormAction)
+	add("base-uri", csp.BaseURI)
+	if csp.UpgradeInsecureRequests {
+		directives = append(directives, "upgrade-insecure-requests")
+	}
+	if csp.ReportURI != "" {
+		directives = append(directives, "report-uri "+csp.ReportURI)
+	}
+	if csp.ReportTo != "" {
+		directives = append(directives, "report-to "+csp.ReportTo)
+	}
+	return strings.Join(directives, "; ")
+}
+
+// CSPViolation is a Content-Security-Policy violation report, as sent by
+// browsers to a policy's `report-uri` (`application/csp-report`) or
+// `report-to` endpoint (`application/reports+json`).
+type CSPViolation struct {
+	DocumentURI        string `json:"documentURI"`
+	Referrer           string `json:"referrer,omitempty"`
+	BlockedURI         string `json:"blockedURI"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	OriginalPolicy     string `json:"originalPolicy"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"sourceFile,omitempty"`
+	LineNumber         int    `json:"lineNumber,omitempty"`
+	ColumnNumber       int    `json:"columnNumber,omitempty"`
+	StatusCode         int    `json:"statusCode,omitempty"`
+	Sample             string `json:"sample,omitempty"`
+}
+
+// legacyCSPReport is the body of an `application/csp-report` report.
+type legacyCSPReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		OriginalPolicy     string `json:"original-policy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		StatusCode         int    `json:"status-code"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is an entry in an `application/reports+json` body.
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		Referrer           string `json:"referrer"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		OriginalPolicy     string `json:"originalPolicy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+		StatusCode         int    `json:"statusCode"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
+
+// parseCSPReports parses either report format.
+func parseCSPReports(data []byte) ([]CSPViolation, error) {
+	var reports []reportingAPIReport
+	if err := json.Unmarshal(data, &reports); err == nil {
+		var violations []CSPViolation
+		for _, report := range reports {
+			if report.Type != "csp-violation" {
+				continue
+			}
+			b := report.Body
+			violations = append(violations, CSPViolation{
+				DocumentURI:        b.DocumentURL,
+				Referrer:           b.Referrer,
+				BlockedURI:         b.BlockedURL,
+				EffectiveDirective: b.EffectiveDirective,
+				OriginalPolicy:     b.OriginalPolicy,
+				Disposition:        b.Disposition,
+				SourceFile:         b.SourceFile,
+				LineNumber:         b.LineNumber,
+				ColumnNumber:       b.ColumnNumber,
+				StatusCode:         b.StatusCode,
+				Sample:             b.Sample,
+			})
+		}
+		return violations, nil
+	}
+
+	var legacy legacyCSPReport
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil, err
+	}
+	r := legacy.Report
+	if r.DocumentURI == "" {
+		return nil, fmt.Errorf("missing `csp-report` object")
+	}
+	directive := r.EffectiveDirective
+	if directive == "" {
+		directive = r.ViolatedDirective
+	}
+	return []CSPViolation{{
+		DocumentURI:        r.DocumentURI,
+		Referrer:           r.Referrer,
+		BlockedURI:         r.BlockedURI,
+		EffectiveDirective: directive,
+		OriginalPolicy:     r.OriginalPolicy,
+		Disposition:        r.Disposition,
+		SourceFile:         r.SourceFile,
+		LineNumber:         r.LineNumber,
+		ColumnNumber:       r.ColumnNumber,
+		StatusCode:         r.StatusCode,
+		Sample:             r.ScriptSample,
+	}}, nil
+}
+
+// cspReportLog records a violation report in the request log.
+type cspReportLog struct {
+	Context   string       `json:"context"`
+	Violation CSPViolation `json:"violation"`
+}
+
+// maxCSPReportBytes bounds the size of violation reports, which come from
+// untrusted clients.
+const maxCSPReportBytes = 64 << 10
+
+// CSPReportHandler returns a handler for a route which collects CSP violation
+// reports, e.g.:
+//
+//     Route{
+//         Path:    "/csp-reports",
+//         Method:  "POST",
+//         Handler: CSPReportHandler(nil),
+//     }
+//
+// Each violation is recorded in the request log and, if `report` is non-nil,
+// passed to it (e.g., to forward it to an error tracker). Malformed reports
+// get 400 Bad Request; everything else gets 204 No Content.
+func CSPReportHandler(report func(Request, CSPViolation)) Handler {
+	return func(r Request) Response {
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxCSPReportBytes))
+		if err != nil {
+			return HandleError("Error reading CSP report", err)
+		}
+		violations, err := parseCSPReports(data)
+		if err != nil {
+			return HandleError(
+				"Error parsing CSP report",
+				&HTTPError{Status: 400, Detail: "Malformed CSP report."},
+				struct {
+					Context string `json:"context"`
+					Error   string `json:"error"`
+				}{"Malformed CSP report", err.Error()},
+			)
+		}
+		logging := make([]interface{}, len(violations))
+		for i, violation := range violations {
+			logging[i] = cspReportLog{"CSP violation", violation}
+			if report != nil {
+				report(r, violation)
+			}
+		}
+		return NoContent(logging...)
+	}
+}
@@ -0,0 +1,340 @@
+package httpeasy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrNoCSRF is returned by `Request.CSRFToken` and the CSRF template functions
+// when the `CSRF` middleware hasn't been applied.
+var ErrNoCSRF = errors.New("httpeasy: CSRF tokens require the CSRF middleware")
+
+const csrfTokenLength = 32
+
+// csrfState holds the request's CSRF token.
+type csrfState struct {
+	token []byte
+	field string
+}
+
+// masked returns the token XORed with a random one-time pad, prefixed by the
+// pad. Every rendering of the token is different, so it can't be recovered
+// from compressed responses by the BREACH attack.
+func (s *csrfState) masked() string {
+	masked := make([]byte, 2*csrfTokenLength)
+	pad := masked[:csrfTokenLength]
+	if _, err := io.ReadFull(rand.Reader, pad); err != nil {
+		panic(fmt.Sprintf("httpeasy: generating CSRF pad: %v", err))
+	}
+	for i, b := range s.token {
+		masked[csrfTokenLength+i] = masked[i] ^ b
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+// unmaskCSRFToken reverses `csrfState.masked`, returning nil if `masked` isn't
+// a masked token.
+func unmaskCSRFToken(masked string) []byte {
+	data, err := base64.RawURLEncoding.DecodeString(masked)
+	if err != nil || len(data) != 2*csrfTokenLength {
+		return nil
+	}
+	token := make([]byte, csrfTokenLength)
+	for i := range token {
+		token[i] = data[i] ^ data[csrfTokenLength+i]
+	}
+	return token
+}
+
+// CSRFToken returns the request's CSRF token for clients to send back in the
+// CSRF header, e.g., from a `<meta>` tag read by scripts. Each call returns a
+// differently masked token. It returns ErrNoCSRF if the `CSRF` middleware
+// hasn't been applied.
+func (r Request) CSRFToken() (string, error) {
+	if r.csrf == nil {
+		return "", ErrNoCSRF
+	}
+	return r.csrf.masked(), nil
+}
+
+// CSRFOptions configures the `CSRF` middleware. The zero value is ready to
+// use.
+type CSRFOptions struct {
+	// FieldName is the name of the form field holding the token. Defaults to
+	// `csrf_token`.
+	FieldName string
+
+	// HeaderName is the name of the header holding the token, for requests
+	// which aren't form submissions. Defaults to `X-CSRF-Token`.
+	HeaderName string
+
+	// CookieName is the name of the cookie holding the token when there's no
+	// session. Defaults to `csrf`.
+	CookieName string
+
+	// Insecure omits the token cookie's `Secure` attribute, e.g., for local
+	// development over plain HTTP.
+	Insecure bool
+
+	// TrustedOrigins are origins (e.g., `https://admin.example.com`) which
+	// may submit cross-origin requests.
+	TrustedOrigins []string
+
+	// Exempt, if set, exempts the requests for which it returns true from
+	// validation (e.g., webhook routes authenticated by other means). Exempt
+	// requests are still issued a token.
+	Exempt func(Request) bool
+}
+
+// csrfSessionKey is the session key holding the CSRF token.
+const csrfSessionKey = "httpeasy.csrf"
+
+// csrfMaxFormBytes bounds how much of a form body is read to find the token,
+// like `net/http.Request.ParseForm`.
+const csrfMaxFormBytes = 10 << 20
+
+// CSRF returns middleware which protects handlers against cross-site request
+// forgery. Each client is issued a random token, which is stored in its
+// session if the `Sessions` middleware is applied (outside of CSRF) or in a
+// cookie otherwise (the double-submit pattern). Render the token into forms
+// with the `csrfField` template function (see `Request.HTMLTemplate`) or
+// send it in the `X-CSRF-Token` header (see `Request.CSRFToken`).
+//
+// Requests with unsafe methods (i.e., other than GET, HEAD, OPTIONS and
+// TRACE) are rejected with 403 Forbidden unless
+//
+// * their `Sec-Fetch-Site` or `Origin` header shows they're same-origin or
+//   from one of the TrustedOrigins (requests from browsers which send
+//   neither are allowed through to the token check), and
+// * they carry the token in the header or, for urlencoded and multipart
+//   forms, the form field. Multipart forms whose token field doesn't appear
+//   in the first 10 MiB are rejected with 413 Request Entity Too Large, so
+//   clients uploading large files should use the header.
+//
+// The form body is buffered as needed and restored for the handler.
+func CSRF(options CSRFOptions) Middleware {
+	if options.FieldName == "" {
+		options.FieldName = "csrf_token"
+	}
+	if options.HeaderName == "" {
+		options.HeaderName = "X-CSRF-Token"
+	}
+	if options.CookieName == "" {
+		options.CookieName = "csrf"
+	}
+	trusted := map[string]bool{}
+	for _, origin := range options.TrustedOrigins {
+		trusted[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
+	return func(next Handler) Handler {
+		return func(r Request) Response {
+			token, issued, err := csrfToken(r, options)
+			if err != nil {
+				return HandleError("Error issuing CSRF token", err)
+			}
+
+			if !isSafeMethod(r.Method) &&
+				(options.Exempt == nil || !options.Exempt(r)) {
+				var reason string
+				reason, r.Body, err = checkCSRF(r, options, trusted, token)
+				if err != nil {
+					return HandleError("Error reading CSRF token", err)
+				}
+				if reason == "" && issued {
+					reason = "No CSRF token has been issued to the client."
+				}
+				if reason != "" {
+					return HandleError(
+						"Rejected request by CSRF protection",
+						&HTTPError{
+							Status: http.StatusForbidden,
+							Detail: reason,
+						},
+					)
+				}
+			}
+
+			r.csrf = &csrfState{token: token, field: options.FieldName}
+			rsp := next(r)
+			if issued && r.session == nil {
+				rsp = rsp.WithCookies(&http.Cookie{
+					Name:     options.CookieName,
+					Value:    base64.RawURLEncoding.EncodeToString(token),
+					Path:     "/",
+					Secure:   !options.Insecure,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+			return rsp
+		}
+	}
+}
+
+// csrfToken returns the client's token from its session or cookie, issuing a
+// new one (and reporting that it did) if there isn't one.
+func csrfToken(r Request, options CSRFOptions) ([]byte, bool, error) {
+	var encoded string
+	if r.session != nil {
+		r.session.Get(csrfSessionKey, &encoded)
+	} else if cookie, err := r.Cookie(options.CookieName); err == nil {
+		encoded = cookie.Value
+	}
+	if token, err := base64.RawURLEncoding.DecodeString(encoded); err == nil &&
+		len(token) == csrfTokenLength {
+		return token, false, nil
+	}
+
+	token := make([]byte, csrfTokenLength)
+	if _, err := io.ReadFull(rand.Reader, token); err != nil {
+		return nil, false, fmt.Errorf("generating CSRF token: %w", err)
+	}
+	if r.session != nil {
+		err := r.session.Set(
+			csrfSessionKey,
+			base64.RawURLEncoding.EncodeToString(token),
+		)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return token, true, nil
+}
+
+// checkCSRF returns why the request fails the origin or token check, or "" if
+// it passes. It also returns the request body to use in place of `r.Body`,
+// since reading the form consumes it.
+func checkCSRF(
+	r Request,
+	options CSRFOptions,
+	trusted map[string]bool,
+	token []byte,
+) (string, io.Reader, error) {
+	if reason := checkOrigin(r, trusted); reason != "" {
+		return reason, r.Body, nil
+	}
+	submitted, body, err := submittedCSRFToken(r, options)
+	if err != nil {
+		return "", body, err
+	}
+	if submitted == nil {
+		return "CSRF token is missing from the request.", body, nil
+	}
+	if subtle.ConstantTimeCompare(submitted, token) != 1 {
+		return "CSRF token is invalid.", body, nil
+	}
+	return "", body, nil
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// checkOrigin returns why the request isn't same-origin or trusted, or "" if
+// it is (or the client didn't say).
+func checkOrigin(r Request, trusted map[string]bool) string {
+	origin := strings.ToLower(r.Headers.Get("Origin"))
+	if trusted[origin] {
+		return ""
+	}
+	switch site := r.Headers.Get("Sec-Fetch-Site"); site {
+	case "same-origin", "none":
+		return ""
+	case "":
+	default:
+		return fmt.Sprintf("Cross-origin request (Sec-Fetch-Site: %s).", site)
+	}
+	if origin == "" {
+		return ""
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host != "" &&
+		strings.EqualFold(u.Host, r.Host) {
+		return ""
+	}
+	return fmt.Sprintf("Cross-origin request (Origin: %s).", origin)
+}
+
+// submittedCSRFToken returns the unmasked token from the request's header or
+// form field, or nil if there isn't one. It also returns the request body to
+// use in place of `r.Body`, since reading the form consumes it.
+func submittedCSRFToken(r Request, options CSRFOptions) (
+	[]byte,
+	io.Reader,
+	error,
+) {
+	if value := r.Headers.Get(options.HeaderName); value != "" {
+		return unmaskCSRFToken(value), r.Body, nil
+	}
+	if r.Body == nil {
+		return nil, r.Body, nil
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, csrfMaxFormBytes))
+		body := io.MultiReader(bytes.NewReader(data), r.Body)
+		if err != nil {
+			return nil, body, err
+		}
+		form, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, body, nil
+		}
+		return unmaskCSRFToken(form.Get(options.FieldName)), body, nil
+	case "multipart/form-data":
+		// Read parts until the token is found, keeping what was read so the
+		// handler sees the whole body. Since the token may come after a large
+		// file or not at all, only so much is kept.
+		var buf bytes.Buffer
+		body := func() io.Reader {
+			return io.MultiReader(bytes.NewReader(buf.Bytes()), r.Body)
+		}
+		reader := multipart.NewReader(
+			io.LimitReader(io.TeeReader(r.Body, &buf), csrfMaxFormBytes),
+			params["boundary"],
+		)
+		for {
+			part, err := reader.NextPart()
+			if err != nil && buf.Len() >= csrfMaxFormBytes {
+				return nil, body(), &HTTPError{
+					Status: http.StatusRequestEntityTooLarge,
+					Detail: fmt.Sprintf(
+						"The CSRF token wasn't found in the first %d bytes "+
+							"of the form; send it in the %s header instead.",
+						csrfMaxFormBytes,
+						options.HeaderName,
+					),
+				}
+			}
+			if err != nil {
+				return nil, body(), nil
+			}
+			if part.FormName() == options.FieldName && part.FileName() == "" {
+				value, err := ioutil.ReadAll(io.LimitReader(part, 1024))
+				if err != nil {
+					return nil, body(), nil
+				}
+				return unmaskCSRFToken(string(value)), body(), nil
+			}
+		}
+	default:
+		return nil, r.Body, nil
+	}
+}
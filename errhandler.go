@@ -0,0 +1,163 @@
+package httpeasy
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// ErrHandler is like Handler, except it can return an error instead of
+// building an error response itself:
+//
+//     func getWidget(r Request) (Response, error) {
+//         widget, err := store.Get(r.Vars["id"])
+//         if err != nil {
+//             return Response{}, fmt.Errorf("fetching widget: %w", err)
+//         }
+//         return Ok(JSON(widget)), nil
+//     }
+//
+// Returned errors are rendered by the router's `ErrorRenderer` (which
+// defaults to `DefaultErrorRenderer`), and the error's full chain is attached
+// to the request log. Register an ErrHandler via `Route.ErrHandler`.
+type ErrHandler func(r Request) (Response, error)
+
+// ErrorRenderer renders an error as a response.
+type ErrorRenderer func(r Request, err error) Response
+
+// DefaultErrorRenderer renders errors via `HandleError`, so errors which are
+// (or wrap) an `Error` get their own status and anything else becomes a 500.
+func DefaultErrorRenderer(r Request, err error) Response {
+	return HandleError("Error handling request", err)
+}
+
+// Handler converts the ErrHandler into a Handler which renders errors with
+// `render` (or `DefaultErrorRenderer` if `render` is nil).
+func (h ErrHandler) Handler(render ErrorRenderer) Handler {
+	if render == nil {
+		render = DefaultErrorRenderer
+	}
+	return func(r Request) Response {
+		rsp, err := h(r)
+		if err == nil {
+			return rsp
+		}
+		return render(r, err).WithLogging(rsp.Logging...).WithLogging(
+			newErrorLog("Handler returned an error", err),
+		)
+	}
+}
+
+// errorLog describes an error in the request log, including each error in its
+// chain and a stack trace if one of them carries one.
+type errorLog struct {
+	Context string      `json:"context"`
+	Error   string      `json:"error"`
+	Chain   []errorLink `json:"chain"`
+	Stack   string      `json:"stack,omitempty"`
+}
+
+type errorLink struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+func newErrorLog(context string, err error) errorLog {
+	log := errorLog{Context: context, Error: err.Error()}
+	walkErrors(err, func(err error) {
+		log.Chain = append(log.Chain, errorLink{
+			Type:    fmt.Sprintf("%T", err),
+			Message: err.Error(),
+		})
+
+		// Errors which carry a stack trace (e.g., from `WithStack` or
+		// github.com/pkg/errors) print it for the `%+v` verb.
+		if _, ok := err.(fmt.Formatter); ok && log.Stack == "" {
+			if detailed := fmt.Sprintf("%+v", err); detailed != err.Error() {
+				log.Stack = detailed
+			}
+		}
+	})
+	return log
+}
+
+// walkErrors calls `f` for `err` and every error in its chain, depth first,
+// including the branches of `errors.Join` trees.
+func walkErrors(err error, f func(error)) {
+	if err == nil {
+		return
+	}
+	f(err)
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		walkErrors(e.Unwrap(), f)
+	case interface{ Unwrap() []error }:
+		for _, inner := range e.Unwrap() {
+			walkErrors(inner, f)
+		}
+	}
+}
+
+// WithStack annotates `err` with the stack of the caller so it shows up in the
+// request log when the error is returned from an ErrHandler. The returned
+// error wraps `err`, so `errors.Is` and `errors.As` see through it. If `err`
+// is nil, WithStack returns nil.
+func WithStack(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &stackError{err: err, stack: callers(3)}
+}
+
+type stackError struct {
+	err   error
+	stack []uintptr
+}
+
+func (err *stackError) Error() string { return err.err.Error() }
+
+func (err *stackError) Unwrap() error { return err.err }
+
+// Format implements fmt.Formatter. The `%+v` verb prints the error followed by
+// its stack trace.
+func (err *stackError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		fmt.Fprintf(s, "%s\n%s", err.err, formatStack(err.stack))
+		return
+	}
+	io.WriteString(s, err.err.Error())
+}
+
+// callers returns the program counters of the calling goroutine's stack,
+// skipping `skip` frames (see `runtime.Callers`).
+func callers(skip int) []uintptr {
+	pcs := make([]uintptr, 32)
+	return pcs[:runtime.Callers(skip, pcs)]
+}
+
+// formatStack renders a stack trace with one `function\n\tfile:line` entry per
+// frame, omitting frames from the Go runtime and the net/http server frames
+// at the bottom of the stack.
+func formatStack(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "net/http.") {
+			return b.String()
+		}
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			fmt.Fprintf(
+				&b,
+				"%s\n\t%s:%d\n",
+				frame.Function,
+				frame.File,
+				frame.Line,
+			)
+		}
+		if !more {
+			return b.String()
+		}
+	}
+}
@@ -1,30 +1,190 @@
 package httpeasy
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"sort"
 )
 
 type Error interface {
 	HTTPError() *HTTPError
 }
 
+// HTTPError is an error with an HTTP status. It serializes as an RFC 9457
+// problem details object (see `MarshalJSON`), so API consumers get a
+// standard, machine-readable error format.
 type HTTPError struct {
-	Status  int    `json:"status"`
-	Message string `json:"message"`
-	Cause_  error  `json:"-"`
+	// Status is the HTTP status code.
+	Status int `json:"status"`
+
+	// Message is a human-readable description of the error. For backwards
+	// compatibility it's serialized as the `message` extension member.
+	Message string `json:"message,omitempty"`
+
+	// Type is a URI reference which identifies the problem type. Defaults to
+	// `about:blank`, which means the problem has no semantics beyond those of
+	// the status code.
+	Type string `json:"type,omitempty"`
+
+	// Title is a short, human-readable summary of the problem type. If Type
+	// is `about:blank`, it defaults to the status code's reason phrase.
+	Title string `json:"title,omitempty"`
+
+	// Detail is a human-readable explanation specific to this occurrence of
+	// the problem.
+	Detail string `json:"detail,omitempty"`
+
+	// Instance is a URI reference which identifies this occurrence of the
+	// problem.
+	Instance string `json:"instance,omitempty"`
+
+	// Extensions holds additional members of the problem details object.
+	// Members which collide with the fields above are ignored.
+	Extensions map[string]interface{} `json:"-"`
+
+	Cause_ error `json:"-"`
 }
 
 func (err *HTTPError) Cause() error { return err.Cause_ }
 
+// Unwrap returns the error's cause so `errors.Is` and `errors.As` can see
+// through the HTTPError.
+func (err *HTTPError) Unwrap() error { return err.Cause_ }
+
 func (err *HTTPError) HTTPError() *HTTPError { return err }
 
 func (err *HTTPError) Error() string {
+	message := err.Message
+	if message == "" {
+		message = err.Detail
+	}
+	if message == "" {
+		message = err.title()
+	}
 	if err.Cause_ == nil {
-		return err.Message
+		return message
+	}
+	return fmt.Sprintf("%s: %s", message, err.Cause_)
+}
+
+// problemType returns the problem type URI, applying the default.
+func (err *HTTPError) problemType() string {
+	if err.Type == "" {
+		return "about:blank"
+	}
+	return err.Type
+}
+
+// title returns the problem title, applying the default.
+func (err *HTTPError) title() string {
+	if err.Title == "" && err.problemType() == "about:blank" {
+		return http.StatusText(err.Status)
+	}
+	return err.Title
+}
+
+// problemMembers are the members of a problem details object which map to
+// fields on HTTPError rather than to its Extensions.
+var problemMembers = map[string]bool{
+	"type":     true,
+	"title":    true,
+	"status":   true,
+	"detail":   true,
+	"instance": true,
+	"message":  true,
+}
+
+// MarshalJSON implements json.Marshaler. The error is rendered as an RFC 9457
+// problem details object, with defaults applied for `type` and `title` and any
+// extension members appended in sorted order.
+func (err *HTTPError) MarshalJSON() ([]byte, error) {
+	data, e := json.Marshal(struct {
+		Type     string `json:"type"`
+		Title    string `json:"title,omitempty"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail,omitempty"`
+		Instance string `json:"instance,omitempty"`
+		Message  string `json:"message,omitempty"`
+	}{
+		Type:     err.problemType(),
+		Title:    err.title(),
+		Status:   err.Status,
+		Detail:   err.Detail,
+		Instance: err.Instance,
+		Message:  err.Message,
+	})
+	if e != nil {
+		return nil, e
+	}
+
+	keys := make([]string, 0, len(err.Extensions))
+	for key := range err.Extensions {
+		if !problemMembers[key] {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) < 1 {
+		return data, nil
+	}
+	sort.Strings(keys)
+
+	buf := bytes.NewBuffer(data[:len(data)-1]) // drop the closing brace
+	for _, key := range keys {
+		name, e := json.Marshal(key)
+		if e != nil {
+			return nil, e
+		}
+		value, e := json.Marshal(err.Extensions[key])
+		if e != nil {
+			return nil, fmt.Errorf("marshaling extension `%s`: %w", key, e)
+		}
+		buf.WriteByte(',')
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Members other than the standard
+// problem details members (and `message`) are collected into Extensions.
+func (err *HTTPError) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if e := json.Unmarshal(data, &members); e != nil {
+		return e
+	}
+
+	type plain HTTPError // avoid recursing into this method
+	var p plain
+	if e := json.Unmarshal(data, &p); e != nil {
+		return e
+	}
+	*err = HTTPError(p)
+
+	for key, raw := range members {
+		if problemMembers[key] {
+			continue
+		}
+		var v interface{}
+		if e := json.Unmarshal(raw, &v); e != nil {
+			return e
+		}
+		if err.Extensions == nil {
+			err.Extensions = map[string]interface{}{}
+		}
+		err.Extensions[key] = v
 	}
-	return fmt.Sprintf("%s: %s", err.Message, err.Cause_)
+	return nil
+}
+
+// Problem wraps an HTTPError in a serializer with the RFC 9457
+// `application/problem+json` media type.
+func Problem(err *HTTPError) Serializer {
+	return withContentType(JSON(err), problemContentType)
 }
 
 func (err *HTTPError) Compare(other *HTTPError) error {
@@ -56,6 +216,29 @@ func (err *HTTPError) Compare(other *HTTPError) error {
 		)
 	}
 
+	for _, field := range []struct {
+		name          string
+		wanted, found string
+	}{
+		{"Type", err.problemType(), other.problemType()},
+		{"Title", err.title(), other.title()},
+		{"Detail", err.Detail, other.Detail},
+		{"Instance", err.Instance, other.Instance},
+	} {
+		if field.wanted != field.found {
+			return fmt.Errorf(
+				"HTTPError.%s: wanted `%s`; found `%s`",
+				field.name,
+				field.wanted,
+				field.found,
+			)
+		}
+	}
+
+	if e := compareExtensions(err.Extensions, other.Extensions); e != nil {
+		return e
+	}
+
 	if err.Cause_ != nil && other.Cause_ != nil {
 		wanted, found := err.Cause_.Error(), other.Cause_.Error()
 		if wanted != found {
@@ -79,6 +262,41 @@ func (err *HTTPError) Compare(other *HTTPError) error {
 	)
 }
 
+// compareExtensions compares extension members by their JSON encoding, since
+// values which round-trip through JSON change type (e.g., `int` becomes
+// `float64`).
+func compareExtensions(wanted, found map[string]interface{}) error {
+	keys := map[string]struct{}{}
+	for key := range wanted {
+		keys[key] = struct{}{}
+	}
+	for key := range found {
+		keys[key] = struct{}{}
+	}
+	for key := range keys {
+		if problemMembers[key] {
+			continue
+		}
+		wantedData, err := json.Marshal(wanted[key])
+		if err != nil {
+			return fmt.Errorf("marshaling wanted extension `%s`: %w", key, err)
+		}
+		foundData, err := json.Marshal(found[key])
+		if err != nil {
+			return fmt.Errorf("marshaling found extension `%s`: %w", key, err)
+		}
+		if !bytes.Equal(wantedData, foundData) {
+			return fmt.Errorf(
+				"HTTPError.Extensions[%q]: wanted `%s`; found `%s`",
+				key,
+				wantedData,
+				foundData,
+			)
+		}
+	}
+	return nil
+}
+
 func (err *HTTPError) CompareErr(other error) error {
 	var e *HTTPError
 	if !errors.As(other, &e) {
@@ -95,32 +313,8 @@ func (wanted *HTTPError) CompareData(data []byte) error {
 	return wanted.Compare(&other)
 }
 
+// HandleError builds a response for `err` using `DefaultErrorMapper`. See
+// `ErrorMapper.HandleError` for details.
 func HandleError(message string, err error, logging ...interface{}) Response {
-	logging = append(logging, struct {
-		Message string `json:"message"`
-		Error   string `json:"error"`
-	}{
-		Message: message,
-		Error:   err.Error(),
-	})
-
-	cause := err
-	for {
-		if unwrapped := errors.Unwrap(cause); unwrapped != nil {
-			cause = unwrapped
-			continue
-		}
-		break
-	}
-
-	if e, ok := cause.(Error); ok {
-		httpErr := e.HTTPError()
-		return Response{
-			Status:  httpErr.Status,
-			Data:    JSON(httpErr),
-			Logging: logging,
-		}
-	}
-
-	return InternalServerError(logging)
+	return DefaultErrorMapper.HandleError(message, err, logging...)
 }
@@ -0,0 +1,120 @@
+package httpeasy
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrorMapping maps an application error to an HTTPError, returning nil if the
+// mapping doesn't apply to the error. Mappings receive the whole error, so
+// they should use `errors.Is` or `errors.As` to inspect its chain:
+//
+//     func mapValidationErrors(err error) *HTTPError {
+//         var e *ValidationError
+//         if errors.As(err, &e) {
+//             return &HTTPError{Status: 422, Detail: e.Error()}
+//         }
+//         return nil
+//     }
+//
+type ErrorMapping func(err error) *HTTPError
+
+// ErrorMapper converts errors into responses. Errors which implement `Error`
+// (e.g., `*HTTPError`) map to their own status; other errors are mapped via
+// the registered ErrorMappings. The zero value is ready to use and is safe for
+// concurrent use.
+type ErrorMapper struct {
+	lock     sync.RWMutex
+	mappings []ErrorMapping
+}
+
+// DefaultErrorMapper is the ErrorMapper used by `HandleError`, `MapError` and
+// `MapSentinel`.
+var DefaultErrorMapper = &ErrorMapper{}
+
+// Map registers mappings with the mapper. Mappings are tried in the order they
+// were registered.
+func (m *ErrorMapper) Map(mappings ...ErrorMapping) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.mappings = append(m.mappings, mappings...)
+}
+
+// MapSentinel registers a mapping from any error which `errors.Is` the target
+// error to an HTTPError with the provided status and message.
+func (m *ErrorMapper) MapSentinel(target error, status int, message string) {
+	m.Map(func(err error) *HTTPError {
+		if errors.Is(err, target) {
+			return &HTTPError{Status: status, Message: message}
+		}
+		return nil
+	})
+}
+
+// HTTPError finds the HTTPError for `err`. The outermost error in the chain
+// (including `errors.Join` trees) which implements `Error` wins, per
+// `errors.As`. Failing that, the registered mappings are tried in order. It
+// returns false if nothing matched.
+func (m *ErrorMapper) HTTPError(err error) (*HTTPError, bool) {
+	var e Error
+	if errors.As(err, &e) {
+		if httpErr := e.HTTPError(); httpErr != nil {
+			return httpErr, true
+		}
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, mapping := range m.mappings {
+		if httpErr := mapping(err); httpErr != nil {
+			if httpErr.Cause_ == nil {
+				// Mappings may return a shared value, so set the cause on a
+				// copy.
+				e := *httpErr
+				e.Cause_ = err
+				return &e, true
+			}
+			return httpErr, true
+		}
+	}
+	return nil, false
+}
+
+// HandleError builds a response for `err`. If the mapper finds an HTTPError
+// for `err` (see `ErrorMapper.HTTPError`), the response has its status and an
+// `application/problem+json` body; otherwise it's a generic 500 Internal
+// Server Error so internal details don't leak to the client. Either way,
+// `message` and the error are attached to the logging.
+func (m *ErrorMapper) HandleError(
+	message string,
+	err error,
+	logging ...interface{},
+) Response {
+	logging = append(logging, struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}{
+		Message: message,
+		Error:   err.Error(),
+	})
+
+	if httpErr, ok := m.HTTPError(err); ok {
+		return Response{
+			Status:  httpErr.Status,
+			Data:    Problem(httpErr),
+			Logging: logging,
+		}
+	}
+
+	return InternalServerError(logging...)
+}
+
+// MapError registers mappings with `DefaultErrorMapper`. It's typically called
+// at startup.
+func MapError(mappings ...ErrorMapping) { DefaultErrorMapper.Map(mappings...) }
+
+// MapSentinel registers a sentinel error mapping with `DefaultErrorMapper`.
+// See `ErrorMapper.MapSentinel`.
+func MapSentinel(target error, status int, message string) {
+	DefaultErrorMapper.MapSentinel(target, status, message)
+}
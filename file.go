@@ -0,0 +1,352 @@
+package httpeasy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Content builds a response which serves `content` with the same semantics as
+// `net/http.ServeContent`: byte range requests (including multipart ranges)
+// are honored, `Content-Type` is taken from the extension of `name` or sniffed
+// from the first 512 bytes, and `Last-Modified` is set from `modtime` (if it
+// isn't zero) and used to answer conditional requests.
+//
+// `content` is not read until the response body is written. If it is also an
+// `io.Closer`, it is closed once the body has been written (or immediately if
+// the response has no body). If the body is never written, e.g., because
+// middleware replaced the response with a 304 Not Modified, it's closed once
+// the request has been served.
+func Content(
+	r Request,
+	name string,
+	modtime time.Time,
+	content io.ReadSeeker,
+) Response {
+	var once sync.Once
+	done := func() {
+		once.Do(func() {
+			if closer, ok := content.(io.Closer); ok {
+				closer.Close()
+			}
+		})
+	}
+	r.onFinish(done)
+
+	if rsp, ok := r.CheckPreconditions("", modtime); !ok {
+		done()
+		return rsp
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		var buf [512]byte
+		n, _ := io.ReadFull(content, buf[:])
+		contentType = http.DetectContentType(buf[:n])
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			done()
+			return HandleError("seeking to start of content", err)
+		}
+	}
+
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		done()
+		return HandleError("finding content size", err)
+	}
+
+	headers := http.Header{"Accept-Ranges": []string{"bytes"}}
+	status := http.StatusOK
+	ranges := []byteRange{{start: 0, length: size}}
+
+	if rangeHeader := r.Headers.Get("Range"); rangeHeader != "" &&
+		ifRangeSatisfied(r.Headers.Get("If-Range"), modtime) {
+		parsed, err := parseRange(rangeHeader, size)
+		if err != nil {
+			done()
+			return RequestedRangeNotSatisfiable(size, nil, struct {
+				Context string `json:"context"`
+				Range   string `json:"range"`
+				Error   string `json:"error"`
+			}{
+				Context: "Invalid `Range` header",
+				Range:   rangeHeader,
+				Error:   err.Error(),
+			})
+		}
+
+		// Like net/http, ignore range requests which ask for more than the
+		// whole content; it's cheaper to just send the whole thing.
+		if sumRangesSize(parsed) <= size && len(parsed) > 0 {
+			status = http.StatusPartialContent
+			ranges = parsed
+		}
+	}
+
+	var writerTo io.WriterTo
+	switch {
+	case status == http.StatusOK || len(ranges) == 1:
+		if status == http.StatusPartialContent {
+			headers.Set("Content-Range", ranges[0].contentRange(size))
+		}
+		headers.Set("Content-Type", contentType)
+		headers.Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+		writerTo = rangeWriterTo{content, ranges[0]}
+	default:
+		boundary := multipart.NewWriter(ioutil.Discard).Boundary()
+		headers.Set(
+			"Content-Type",
+			"multipart/byteranges; boundary="+boundary,
+		)
+		writerTo = multipartWriterTo{
+			content:     content,
+			ranges:      ranges,
+			size:        size,
+			contentType: contentType,
+			boundary:    boundary,
+		}
+	}
+
+	if r.Method == "HEAD" {
+		done()
+		writerTo = bytes.NewReader(nil)
+	}
+
+	return Response{
+		Status:       status,
+		Headers:      headers,
+		LastModified: modtime,
+		Data: func() (io.WriterTo, error) {
+			return closingWriterTo{writerTo, done}, nil
+		},
+	}
+}
+
+// File builds a response which serves the named file from `fsys` via
+// `Content`. If the file doesn't exist, a 404 Not Found is returned. Files
+// which don't implement `io.Seeker` are read into memory.
+func File(r Request, fsys fs.FS, name string) Response {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return fileError(name, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fileError(name, err)
+	}
+	if info.IsDir() {
+		f.Close()
+		return NotFound(nil, struct {
+			Context string `json:"context"`
+			Name    string `json:"name"`
+		}{Context: "File is a directory", Name: name})
+	}
+
+	if seeker, ok := f.(io.ReadSeeker); ok {
+		return Content(r, info.Name(), info.ModTime(), readSeekCloser{
+			ReadSeeker: seeker,
+			Closer:     f,
+		})
+	}
+
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return fileError(name, err)
+	}
+	return Content(r, info.Name(), info.ModTime(), bytes.NewReader(data))
+}
+
+func fileError(name string, err error) Response {
+	if errors.Is(err, fs.ErrNotExist) {
+		return NotFound(nil, struct {
+			Context string `json:"context"`
+			Name    string `json:"name"`
+			Error   string `json:"error"`
+		}{Context: "File not found", Name: name, Error: err.Error()})
+	}
+	return HandleError("opening file `"+name+"`", err)
+}
+
+type readSeekCloser struct {
+	io.ReadSeeker
+	io.Closer
+}
+
+// closingWriterTo invokes `done` once the body has been written.
+type closingWriterTo struct {
+	inner io.WriterTo
+	done  func()
+}
+
+func (cwt closingWriterTo) WriteTo(w io.Writer) (int64, error) {
+	defer cwt.done()
+	return cwt.inner.WriteTo(w)
+}
+
+// byteRange is a single range from a `Range` header, resolved against the
+// size of the content.
+type byteRange struct {
+	start, length int64
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+func sumRangesSize(ranges []byteRange) int64 {
+	var size int64
+	for _, br := range ranges {
+		size += br.length
+	}
+	return size
+}
+
+// rangeWriterTo writes a single range of the content. It uses `io.CopyN` so
+// `net/http` can use `sendfile` when the content is an `*os.File`.
+type rangeWriterTo struct {
+	content io.ReadSeeker
+	br      byteRange
+}
+
+func (rwt rangeWriterTo) WriteTo(w io.Writer) (int64, error) {
+	if _, err := rwt.content.Seek(rwt.br.start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.CopyN(w, rwt.content, rwt.br.length)
+}
+
+// multipartWriterTo writes several ranges of the content as a
+// `multipart/byteranges` body.
+type multipartWriterTo struct {
+	content     io.ReadSeeker
+	ranges      []byteRange
+	size        int64
+	contentType string
+	boundary    string
+}
+
+func (mwt multipartWriterTo) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	mw := multipart.NewWriter(cw)
+	if err := mw.SetBoundary(mwt.boundary); err != nil {
+		return cw.n, err
+	}
+	for _, br := range mwt.ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Range": []string{br.contentRange(mwt.size)},
+			"Content-Type":  []string{mwt.contentType},
+		})
+		if err != nil {
+			return cw.n, err
+		}
+		writerTo := rangeWriterTo{mwt.content, br}
+		if _, err := writerTo.WriteTo(part); err != nil {
+			return cw.n, err
+		}
+	}
+	err := mw.Close()
+	return cw.n, err
+}
+
+// ifRangeSatisfied reports whether a `Range` header should be honored given
+// the request's `If-Range` header. We don't know the content's entity-tag, so
+// only the date form can be satisfied.
+func ifRangeSatisfied(ifRange string, modtime time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, `W/`) {
+		return false
+	}
+	t, err := http.ParseTime(ifRange)
+	if err != nil || modtime.IsZero() {
+		return false
+	}
+	return modtime.Truncate(time.Second).Equal(t)
+}
+
+var errInvalidRange = errors.New("invalid range")
+var errNoOverlap = errors.New("range does not overlap content")
+
+// parseRange parses a `Range` header value (e.g., `bytes=0-99,200-`) against
+// content of the given size.
+func parseRange(s string, size int64) ([]byteRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	noOverlap := false
+	for _, spec := range strings.Split(s[len(prefix):], ",") {
+		spec = textproto.TrimString(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.Index(spec, "-")
+		if i < 0 {
+			return nil, errInvalidRange
+		}
+		first := textproto.TrimString(spec[:i])
+		last := textproto.TrimString(spec[i+1:])
+
+		var br byteRange
+		if first == "" {
+			// A suffix range, e.g., `-500` for the last 500 bytes.
+			if last == "" || last[0] == '-' {
+				return nil, errInvalidRange
+			}
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil {
+				return nil, errInvalidRange
+			}
+			if n > size {
+				n = size
+			}
+			br = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			if start >= size {
+				noOverlap = true
+				continue
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || start > end {
+					return nil, errInvalidRange
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			br = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, br)
+	}
+
+	if noOverlap && len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+	return ranges, nil
+}
@@ -1,9 +1,15 @@
 module github.com/weberc2/httpeasy
 
-go 1.14
+go 1.20
 
 require (
 	github.com/davecgh/go-spew v1.1.0
 	github.com/gorilla/mux v1.6.2
-	golang.org/x/net v0.0.0-20211118161319-6a13c67c3ce4 // indirect
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
+	golang.org/x/net v0.0.0-20211118161319-6a13c67c3ce4
+)
+
+require (
+	github.com/gorilla/context v1.1.2 // indirect
+	golang.org/x/text v0.3.6 // indirect
 )
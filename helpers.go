@@ -1,6 +1,53 @@
 package httpeasy
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+)
+
+// PlainTextErrorBody renders the status code and reason phrase as plain text,
+// e.g., `404 Not Found`. It's the default error body; see
+// `Router.ErrorBody`.
+func PlainTextErrorBody(status int) Serializer {
+	return String(fmt.Sprintf("%d %s", status, http.StatusText(status)))
+}
+
+// ProblemErrorBody renders an RFC 9457 problem details object with the
+// `application/problem+json` media type, e.g.,
+// `{"type":"about:blank","title":"Not Found","status":404}`.
+func ProblemErrorBody(status int) Serializer {
+	return Problem(&HTTPError{Status: status})
+}
+
+const problemContentType = "application/problem+json"
+
+// errorResponse builds a 4xx or 5xx response. If data is nil, the default
+// error body is used, which routers may replace (see `Router.ErrorBody`).
+func errorResponse(
+	status int,
+	data Serializer,
+	logging []interface{},
+) Response {
+	if data == nil {
+		return Response{
+			Status:      status,
+			Data:        PlainTextErrorBody(status),
+			Logging:     logging,
+			defaultBody: true,
+		}
+	}
+	return Response{Status: status, Data: data, Logging: logging}
+}
+
+// redirect builds a 3xx response which redirects to `location`.
+func redirect(status int, location string, logging []interface{}) Response {
+	return Response{
+		Status:  status,
+		Data:    Stringf("%d %s", status, http.StatusText(status)),
+		Logging: logging,
+		Headers: http.Header{"Location": []string{location}},
+	}
+}
 
 // Ok is a convenience function for building HTTP 200 OK responses.
 func Ok(data Serializer, logging ...interface{}) Response {
@@ -37,18 +84,33 @@ func NoContent(logging ...interface{}) Response {
 	}
 }
 
-// Conflict is a convenience function for building HTTP 209 Conflict responses.
-func Conflict(data Serializer, logging ...interface{}) Response {
-	if data == nil {
-		data = String("409 Conflict")
-	}
+// ResetContent is a convenience function for building HTTP 205 Reset Content
+// responses. A 205 never has a body.
+func ResetContent(logging ...interface{}) Response {
 	return Response{
-		Status:  http.StatusConflict,
-		Data:    data,
+		Status:  http.StatusResetContent,
+		Data:    Bytes(nil),
 		Logging: logging,
 	}
 }
 
+// MovedPermanently is a convenience function for building HTTP 301 Moved
+// Permanently responses. Like the other redirect helpers, it takes the
+// redirect location instead of data. Clients may change the request method to
+// GET when following a 301; use PermanentRedirect if the method must be
+// preserved.
+func MovedPermanently(location string, logging ...interface{}) Response {
+	return redirect(http.StatusMovedPermanently, location, logging)
+}
+
+// Found is a convenience function for building HTTP 302 Found responses. Like
+// the other redirect helpers, it takes the redirect location instead of data.
+// When deciding between HTTP 302, 303, and 307, consult
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Redirections#temporary_redirections.
+func Found(location string, logging ...interface{}) Response {
+	return redirect(http.StatusFound, location, logging)
+}
+
 // SeeOther is a convenience function for building HTTP 303 Temporary
 // Redirect responses. It takes no data argument because there isn't much point
 // in custom status text for a redirect response. Instead, it takes a URL that
@@ -56,11 +118,16 @@ func Conflict(data Serializer, logging ...interface{}) Response {
 // redirect location. When deciding between HTTP 302, 303, and 307, consult
 // https://developer.mozilla.org/en-US/docs/Web/HTTP/Redirections#temporary_redirections.
 func SeeOther(location string, logging ...interface{}) Response {
+	return redirect(http.StatusSeeOther, location, logging)
+}
+
+// NotModified is a convenience function for building HTTP 304 Not Modified
+// responses. A 304 never has a body. See also `Request.CheckPreconditions`.
+func NotModified(logging ...interface{}) Response {
 	return Response{
-		Status:  http.StatusSeeOther,
-		Data:    String("303 See Other"),
+		Status:  http.StatusNotModified,
+		Data:    Bytes(nil),
 		Logging: logging,
-		Headers: http.Header{"Location": []string{location}},
 	}
 }
 
@@ -71,55 +138,180 @@ func SeeOther(location string, logging ...interface{}) Response {
 // redirect location. When deciding between HTTP 302, 303, and 307, consult
 // https://developer.mozilla.org/en-US/docs/Web/HTTP/Redirections#temporary_redirections.
 func TemporaryRedirect(location string, logging ...interface{}) Response {
-	return Response{
-		Status:  http.StatusTemporaryRedirect,
-		Data:    String("307 Temporary Redirect"),
-		Logging: logging,
-		Headers: http.Header{"Location": []string{location}},
-	}
+	return redirect(http.StatusTemporaryRedirect, location, logging)
+}
+
+// PermanentRedirect is a convenience function for building HTTP 308 Permanent
+// Redirect responses. Like the other redirect helpers, it takes the redirect
+// location instead of data. Unlike a 301, clients must preserve the request
+// method when following a 308.
+func PermanentRedirect(location string, logging ...interface{}) Response {
+	return redirect(http.StatusPermanentRedirect, location, logging)
 }
 
 // BadRequest is a convenience function for building HTTP 400 Bad Request
 // responses. If data is nil, a default serializer will be used.
 func BadRequest(data Serializer, logging ...interface{}) Response {
-	if data == nil {
-		data = String("400 Bad Request")
-	}
-	return Response{
-		Status:  http.StatusBadRequest,
-		Data:    data,
-		Logging: logging,
-	}
+	return errorResponse(http.StatusBadRequest, data, logging)
 }
 
 // Unauthorized is a convenience function for building HTTP 401 Unauthorized
 // responses. If data is nil, a default serializer will be used.
 func Unauthorized(data Serializer, logging ...interface{}) Response {
-	if data == nil {
-		data = String("401 Unauthorized")
-	}
-	return Response{
-		Status:  http.StatusUnauthorized,
-		Data:    data,
-		Logging: logging,
-	}
+	return errorResponse(http.StatusUnauthorized, data, logging)
+}
+
+// Forbidden is a convenience function for building HTTP 403 Forbidden
+// responses. If data is nil, a default serializer will be used.
+func Forbidden(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusForbidden, data, logging)
 }
 
 // NotFound is a convenience function for building HTTP 404 Not Found
 // responses. If data is nil, a default serializer will be used.
 func NotFound(data Serializer, logging ...interface{}) Response {
-	if data == nil {
-		data = String("404 Not Found")
-	}
-	return Response{Status: http.StatusNotFound, Data: data, Logging: logging}
+	return errorResponse(http.StatusNotFound, data, logging)
+}
+
+// MethodNotAllowed is a convenience function for building HTTP 405 Method Not
+// Allowed responses. If data is nil, a default serializer will be used. The
+// methods which the target resource does support are sent as the `Allow`
+// header.
+func MethodNotAllowed(
+	allowed []string,
+	data Serializer,
+	logging ...interface{},
+) Response {
+	return errorResponse(http.StatusMethodNotAllowed, data, logging).
+		WithHeaders(http.Header{"Allow": allowed})
+}
+
+// NotAcceptable is a convenience function for building HTTP 406 Not
+// Acceptable responses. If data is nil, a default serializer will be used.
+// See also `Negotiate`.
+func NotAcceptable(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusNotAcceptable, data, logging)
+}
+
+// RequestTimeout is a convenience function for building HTTP 408 Request
+// Timeout responses. If data is nil, a default serializer will be used.
+func RequestTimeout(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusRequestTimeout, data, logging)
+}
+
+// Conflict is a convenience function for building HTTP 409 Conflict responses.
+// If data is nil, a default serializer will be used.
+func Conflict(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusConflict, data, logging)
+}
+
+// Gone is a convenience function for building HTTP 410 Gone responses. If data
+// is nil, a default serializer will be used.
+func Gone(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusGone, data, logging)
+}
+
+// LengthRequired is a convenience function for building HTTP 411 Length
+// Required responses. If data is nil, a default serializer will be used.
+func LengthRequired(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusLengthRequired, data, logging)
+}
+
+// PreconditionFailed is a convenience function for building HTTP 412
+// Precondition Failed responses. If data is nil, a default serializer will be
+// used. See also `Request.CheckPreconditions`.
+func PreconditionFailed(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusPreconditionFailed, data, logging)
+}
+
+// RequestEntityTooLarge is a convenience function for building HTTP 413
+// Request Entity Too Large responses. If data is nil, a default serializer
+// will be used.
+func RequestEntityTooLarge(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusRequestEntityTooLarge, data, logging)
+}
+
+// UnsupportedMediaType is a convenience function for building HTTP 415
+// Unsupported Media Type responses. If data is nil, a default serializer will
+// be used.
+func UnsupportedMediaType(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusUnsupportedMediaType, data, logging)
+}
+
+// RequestedRangeNotSatisfiable is a convenience function for building HTTP
+// 416 Requested Range Not Satisfiable responses. If data is nil, a default
+// serializer will be used. The size of the content is sent as the
+// `Content-Range` header.
+func RequestedRangeNotSatisfiable(
+	size int64,
+	data Serializer,
+	logging ...interface{},
+) Response {
+	return errorResponse(
+		http.StatusRequestedRangeNotSatisfiable,
+		data,
+		logging,
+	).WithHeaders(http.Header{
+		"Content-Range": []string{fmt.Sprintf("bytes */%d", size)},
+	})
+}
+
+// UnprocessableEntity is a convenience function for building HTTP 422
+// Unprocessable Entity responses. If data is nil, a default serializer will be
+// used.
+func UnprocessableEntity(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusUnprocessableEntity, data, logging)
+}
+
+// PreconditionRequired is a convenience function for building HTTP 428
+// Precondition Required responses. If data is nil, a default serializer will
+// be used.
+func PreconditionRequired(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusPreconditionRequired, data, logging)
+}
+
+// TooManyRequests is a convenience function for building HTTP 429 Too Many
+// Requests responses. If data is nil, a default serializer will be used.
+func TooManyRequests(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusTooManyRequests, data, logging)
+}
+
+// UnavailableForLegalReasons is a convenience function for building HTTP 451
+// Unavailable For Legal Reasons responses. If data is nil, a default
+// serializer will be used.
+func UnavailableForLegalReasons(
+	data Serializer,
+	logging ...interface{},
+) Response {
+	return errorResponse(http.StatusUnavailableForLegalReasons, data, logging)
 }
 
 // InternalServerError is a convenience function for building HTTP 500 Internal
 // Server Error responses.
 func InternalServerError(logging ...interface{}) Response {
-	return Response{
-		Status:  http.StatusInternalServerError,
-		Data:    String("500 Internal Server Error"),
-		Logging: logging,
-	}
+	return errorResponse(http.StatusInternalServerError, nil, logging)
+}
+
+// NotImplemented is a convenience function for building HTTP 501 Not
+// Implemented responses. If data is nil, a default serializer will be used.
+func NotImplemented(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusNotImplemented, data, logging)
+}
+
+// BadGateway is a convenience function for building HTTP 502 Bad Gateway
+// responses. If data is nil, a default serializer will be used.
+func BadGateway(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusBadGateway, data, logging)
+}
+
+// ServiceUnavailable is a convenience function for building HTTP 503 Service
+// Unavailable responses. If data is nil, a default serializer will be used.
+func ServiceUnavailable(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusServiceUnavailable, data, logging)
+}
+
+// GatewayTimeout is a convenience function for building HTTP 504 Gateway
+// Timeout responses. If data is nil, a default serializer will be used.
+func GatewayTimeout(data Serializer, logging ...interface{}) Response {
+	return errorResponse(http.StatusGatewayTimeout, data, logging)
 }
@@ -0,0 +1,93 @@
+package httpeasy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// ErrEarlyHintsUnavailable is returned by `Request.EarlyHints` when the
+// request wasn't received via `Handler.HTTP` (e.g., in unit tests) or the
+// handler has already returned.
+var ErrEarlyHintsUnavailable = errors.New(
+	"httpeasy: early hints can only be sent while the handler is running",
+)
+
+// earlyHinter sends 103 Early Hints responses on behalf of a Request.
+type earlyHinter struct {
+	lock sync.Mutex
+	w    http.ResponseWriter
+	r    *http.Request
+	sent []http.Header
+	done bool
+}
+
+// EarlyHints sends a `103 Early Hints` informational response with the
+// provided `Link` header values, so the client can start fetching
+// subresources while a slow handler is still working:
+//
+//     func page(r Request) Response {
+//         r.EarlyHints(
+//             Preload("/app.css", "style"),
+//             Preload("/app.js", "script"),
+//         )
+//         data := slowQuery()
+//         return Ok(HTMLTemplate(tmpl, data))
+//     }
+//
+// It may be called several times, but only before the handler returns. The
+// hints don't carry over to the final response; add the `Link` headers to the
+// Response as well if the client should see them there. HTTP/1.0 clients
+// don't support informational responses, so for them EarlyHints does nothing.
+func (r Request) EarlyHints(links ...string) error {
+	if r.hints == nil {
+		return ErrEarlyHintsUnavailable
+	}
+	return r.hints.send(http.Header{"Link": links})
+}
+
+func (h *earlyHinter) send(hints http.Header) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.done {
+		return ErrEarlyHintsUnavailable
+	}
+	if !h.r.ProtoAtLeast(1, 1) {
+		return nil
+	}
+
+	header := h.w.Header()
+	previous := header.Clone()
+	for key, values := range hints {
+		header[key] = append(header[key], values...)
+	}
+	h.w.WriteHeader(http.StatusEarlyHints)
+
+	// Restore the headers so the hints don't leak into the final response
+	for key := range header {
+		delete(header, key)
+	}
+	for key, values := range previous {
+		header[key] = values
+	}
+
+	h.sent = append(h.sent, hints)
+	return nil
+}
+
+// finish prevents further hints from being sent and returns the hints which
+// were sent, for the request log.
+func (h *earlyHinter) finish() []http.Header {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.done = true
+	return h.sent
+}
+
+// Preload builds a `Link` header value which asks the client to preload the
+// resource at `url` as the provided destination (e.g., `style`, `script`,
+// `font` or `image`). See `Request.EarlyHints`.
+func Preload(url, as string) string {
+	return fmt.Sprintf("<%s>; rel=preload; as=%s", url, as)
+}
@@ -1,6 +1,7 @@
 package httpeasy
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -18,6 +20,9 @@ import (
 
 // Request represents a simplified HTTP request
 type Request struct {
+	// Method is the HTTP method (GET, POST, etc).
+	Method string
+
 	// Vars are the variables parsed out of the URL path.
 	Vars map[string]string
 
@@ -30,6 +35,129 @@ type Request struct {
 	// URL contains the parsed URL information. See net/http.Request.URL for
 	// more information.
 	URL *url.URL
+
+	// TLS holds information about the TLS connection the request was
+	// received on, or nil for plain HTTP. See net/http.Request.TLS.
+	TLS *tls.ConnectionState
+
+	// Host is the host (and port, if any) the request was sent to, from the
+	// `Host` header or the request line.
+	Host string
+
+	// RemoteAddr is the network address of the client (or the last proxy),
+	// usually `IP:port`. See `Request.ClientIP`.
+	RemoteAddr string
+
+	// route is the path template of the route which matched the request, or
+	// "" if it wasn't routed by a Router.
+	route string
+
+	// hints sends 103 Early Hints responses. See `Request.EarlyHints`.
+	hints *earlyHinter
+
+	// logging collects log entries from the request's methods, which are
+	// added to the request log.
+	logging *requestLogging
+
+	// cleanup holds functions to run once the request has been served. See
+	// `Request.onFinish`.
+	cleanup *requestCleanup
+
+	// codec encodes secure cookies. See `SecureCookies`.
+	codec *CookieCodec
+
+	// session is the request's session. See `Sessions`.
+	session *Session
+
+	// csrf holds the request's CSRF token. See `CSRF`.
+	csrf *csrfState
+
+	// cspNonce is the request's CSP nonce. See `SecurityHeaders`.
+	cspNonce string
+
+	// principal is the authenticated principal. See `Authenticate`.
+	principal *Principal
+}
+
+// requestLogging collects log entries from a request's methods. It's shared
+// by every copy of the Request, and may be used concurrently.
+type requestLogging struct {
+	lock    sync.Mutex
+	entries []interface{}
+}
+
+// log adds an entry to the request log. It does nothing if the request wasn't
+// received via `Handler.HTTP`.
+func (r Request) log(entry interface{}) {
+	if r.logging == nil {
+		return
+	}
+	r.logging.lock.Lock()
+	defer r.logging.lock.Unlock()
+	r.logging.entries = append(r.logging.entries, entry)
+}
+
+func (logging *requestLogging) drain() []interface{} {
+	logging.lock.Lock()
+	defer logging.lock.Unlock()
+	entries := logging.entries
+	logging.entries = nil
+	return entries
+}
+
+// requestCleanup holds functions to run once a request has been served. It's
+// shared by every copy of the Request, and may be used concurrently.
+type requestCleanup struct {
+	lock  sync.Mutex
+	funcs []func()
+}
+
+// onFinish registers `f` to run once the request has been served, whether or
+// not the response which needed it was written (e.g., middleware may replace
+// it). It does nothing if the request wasn't received via `Handler.HTTP`.
+func (r Request) onFinish(f func()) {
+	if r.cleanup == nil {
+		return
+	}
+	r.cleanup.lock.Lock()
+	defer r.cleanup.lock.Unlock()
+	r.cleanup.funcs = append(r.cleanup.funcs, f)
+}
+
+func (cleanup *requestCleanup) run() {
+	cleanup.lock.Lock()
+	funcs := cleanup.funcs
+	cleanup.funcs = nil
+	cleanup.lock.Unlock()
+	for _, f := range funcs {
+		f()
+	}
+}
+
+// detach copies the request for handling after it has been served, e.g., in
+// the background. The copy has its own headers, URL and variables, an empty
+// body, and its own logging and cleanup (which the caller must run); it
+// can't send early hints.
+func (r Request) detach() Request {
+	vars := make(map[string]string, len(r.Vars))
+	for key, value := range r.Vars {
+		vars[key] = value
+	}
+	r.Vars = vars
+	r.Body = http.NoBody
+	r.Headers = r.Headers.Clone()
+	if r.URL != nil {
+		u := *r.URL
+		if u.User != nil {
+			user := *u.User
+			u.User = &user
+		}
+		r.URL = &u
+	}
+	r.hints = nil
+	r.logging = &requestLogging{}
+	r.cleanup = &requestCleanup{}
+	return r
 }
 
 // Text consumes the request body and returns it as a string.
@@ -109,6 +237,36 @@ type Response struct {
 
 	// Cookies are the list of cookies to be set on the response
 	Cookies []*http.Cookie
+
+	// ETag is the entity-tag for the response, written as the `ETag` header.
+	// It may be quoted (`"v1"`) or weak (`W/"v1"`); bare values are quoted as
+	// strong entity-tags. See also `Conditional` and
+	// `Request.CheckPreconditions`.
+	ETag string
+
+	// LastModified is the modification time of the response's
+	// representation, written as the `Last-Modified` header if it isn't zero.
+	LastModified time.Time
+
+	// WriteMode determines whether the body is buffered or streamed, which
+	// in turn determines how serializer failures are handled. It overrides
+	// the route's WriteMode. See `WriteMode`.
+	WriteMode WriteMode
+
+	// Trailers, if set, is called after the body has been written and its
+	// result is sent as HTTP trailers (e.g., a checksum of the body or the
+	// final status of a stream). Trailers are only delivered to HTTP/1.1
+	// clients using chunked encoding and to HTTP/2 clients, so clients
+	// shouldn't depend on them for correctness.
+	Trailers func() http.Header
+
+	// secureCookies are encoded into Cookies by the `SecureCookies`
+	// middleware. See `Response.WithSecureCookie`.
+	secureCookies []secureCookie
+
+	// defaultBody is whether Data is the default error body, which the
+	// router replaces with its own. See `Router.ErrorBody`.
+	defaultBody bool
 }
 
 // WithHeaders returns a copy of the response with the specified headers
@@ -166,9 +324,16 @@ type requestLog struct {
 	// Message holds the logging message returned by the request handler
 	Message []interface{} `json:"message"`
 
-	// WriteError holds any errors that were encountered during writing the
-	// response to the output socket.
-	WriteError interface{} `json:"writeError"`
+	// WriteError describes any error that was encountered during writing the
+	// response to the output socket after the status was sent.
+	WriteError *writeErrorLog `json:"writeError"`
+
+	// EarlyHints holds the headers of any 103 Early Hints responses sent
+	// before the final response.
+	EarlyHints []http.Header `json:"earlyHints,omitempty"`
+
+	// Trailers holds the HTTP trailers sent after the response body.
+	Trailers http.Header `json:"trailers,omitempty"`
 }
 
 // JSONLog returns a `LogFunc` which logs JSON to `w`.
@@ -205,11 +370,20 @@ type LogFunc func(v interface{})
 
 // HTTP converts an httpeasy.Handler into an http.HandlerFunc. The returned
 // function will collect a bunch of standard HTTP information and pass it to
-// the provided log function.
-func (h Handler) HTTP(log LogFunc) http.HandlerFunc {
+// the provided log function. If the handler or its serializer panics, the
+// panic is recovered and rendered by `DefaultErrorRenderer` (see
+// `Router.ErrorRenderer` and `Router.Repanic` for routed handlers).
+func (h Handler) HTTP(log LogFunc) http.HandlerFunc { return h.serve(log, nil) }
+
+// serve implements `Handler.HTTP`, taking its error handling configuration
+// from `router` (which may be nil).
+func (h Handler) serve(log LogFunc, router *Router) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		defer r.Body.Close()
+		if router != nil && router.MaxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, router.MaxBodyBytes)
+		}
 
 		var rsp Response
 		contentLength := r.Header.Get("Content-Length")
@@ -228,58 +402,182 @@ func (h Handler) HTTP(log LogFunc) http.HandlerFunc {
 				},
 			)
 		}
-		rsp = h(Request{
-			Vars:    mux.Vars(r),
-			Body:    io.LimitReader(r.Body, i),
-			Headers: r.Header,
-			URL:     r.URL,
-		})
+		req := Request{
+			Method:     r.Method,
+			Vars:       mux.Vars(r),
+			Body:       io.LimitReader(r.Body, i),
+			Headers:    r.Header,
+			URL:        r.URL,
+			Host:       r.Host,
+			RemoteAddr: r.RemoteAddr,
+			TLS:        r.TLS,
+			route:      routeTemplate(r),
+			hints:      &earlyHinter{w: w, r: r},
+			logging:    &requestLogging{},
+			cleanup:    &requestCleanup{},
+		}
+		defer req.cleanup.run()
+
+		var earlyHints []http.Header
+		var trailers http.Header
+		logRequest := func(writeErr *writeErrorLog) {
+			log(requestLog{
+				Started:         start,
+				Duration:        time.Since(start),
+				Method:          r.Method,
+				URL:             *r.URL,
+				RequestHeaders:  r.Header,
+				ResponseHeaders: w.Header(),
+				Status:          rsp.Status,
+				Message:         append(rsp.Logging, req.logging.drain()...),
+				WriteError:      writeErr,
+				EarlyHints:      earlyHints,
+				Trailers:        trailers,
+			})
+		}
 
-		writerTo, err := rsp.Data()
+		var writerTo io.WriterTo
+		panicErr := catch(func() {
+			if router.bodyTooLarge(r.ContentLength) {
+				rsp = router.routerError(
+					req,
+					http.StatusRequestEntityTooLarge,
+					fmt.Sprintf(
+						"The request body exceeds %d bytes.",
+						router.MaxBodyBytes,
+					),
+				)
+			} else {
+				rsp = h(req)
+			}
+			if len(rsp.secureCookies) > 0 {
+				rsp = router.renderError(req, ErrNoCookieCodec).
+					WithLogging(rsp.Logging...)
+			}
+			writerTo, err = rsp.Data()
+			if err == nil && rsp.WriteMode == Buffered {
+				writerTo, err = buffer(writerTo)
+			}
+		})
+		earlyHints = req.hints.finish()
+		if panicErr != nil {
+			rsp = router.renderError(req, panicErr).WithLogging(
+				newPanicLog("Recovered from panic in handler", r, panicErr),
+			)
+			if router.repanic() {
+				logRequest(nil)
+				panic(panicErr.Value)
+			}
+			writerTo, err = rsp.Data()
+		}
 		if err != nil {
-			rsp.Status = http.StatusInternalServerError
-			writerTo = strings.NewReader("500 Internal Server Error")
-			rsp.Logging = []interface{}{
-				struct {
-					Context         string        `json:"context"`
-					Error           string        `json:"error"`
-					OriginalLogging []interface{} `json:"originalLogging"`
-				}{
-					Context:         "Error serializing response data",
-					Error:           err.Error(),
-					OriginalLogging: rsp.Logging,
-				},
+			// Render a fresh response rather than reusing `rsp`, so headers
+			// and cookies meant for the successful response don't leak into
+			// the error response.
+			logging := append(
+				rsp.Logging[:len(rsp.Logging):len(rsp.Logging)],
+				newErrorLog("Error serializing response data", err),
+			)
+			rsp = router.renderError(req, &SerializerError{Err: err})
+			rsp.Logging = append(logging, rsp.Logging...)
+			if writerTo, err = rsp.Data(); err != nil {
+				rsp = InternalServerError(append(
+					rsp.Logging,
+					newErrorLog("Error serializing error response", err),
+				)...)
+				writerTo = strings.NewReader("500 Internal Server Error")
 			}
 		}
 
+		rsp = router.applyCORS(req, rsp)
+
 		// Copy HTTP headers from the response object to the response writer.
 		// This has to go before the WriteHeader invocation or it won't take
-		// effect (quirk of net/http.ResponseWriter).
+		// effect (quirk of net/http.ResponseWriter). It also has to go after
+		// the serializer is invoked, because serializers may set headers
+		// (e.g., `Compress` sets `Content-Encoding`).
 		header := w.Header()
 		for key, values := range rsp.Headers {
 			for _, value := range values {
 				header.Add(key, value)
 			}
 		}
+		if contentType := contentType(writerTo); contentType != "" &&
+			header.Get("Content-Type") == "" {
+			header.Set("Content-Type", contentType)
+		}
+		if rsp.Trailers == nil {
+			setContentLength(header, writerTo)
+		} else {
+			// HTTP/1.1 trailers require chunked encoding, which net/http only
+			// guarantees if a trailer is declared before the status is sent.
+			// The trailers' names aren't known yet, but `Stream-Error` is
+			// always a candidate.
+			header.Add("Trailer", streamErrorTrailer)
+		}
+
+		if rsp.ETag != "" {
+			header.Set("ETag", quoteETag(rsp.ETag))
+		}
+		if !rsp.LastModified.IsZero() {
+			header.Set(
+				"Last-Modified",
+				rsp.LastModified.UTC().Format(http.TimeFormat),
+			)
+		}
 
 		for _, cookie := range rsp.Cookies {
 			http.SetCookie(w, cookie)
 		}
 
 		w.WriteHeader(rsp.Status)
-		_, err = writerTo.WriteTo(w)
-
-		log(requestLog{
-			Started:         start,
-			Duration:        time.Since(start),
-			Method:          r.Method,
-			URL:             *r.URL,
-			RequestHeaders:  r.Header,
-			ResponseHeaders: w.Header(),
-			Status:          rsp.Status,
-			Message:         rsp.Logging,
-			WriteError:      err,
-		})
+		var n int64
+
+		// The status has already been sent, so all we can do about a panic is
+		// log it and abort the response so the client doesn't mistake the
+		// truncated response for a complete one.
+		abort := func(context string, panicErr *PanicError) {
+			rsp.Logging = append(rsp.Logging, newPanicLog(context, r, panicErr))
+			logRequest(newWriteErrorLog(panicErr, n, "aborted"))
+			if router.repanic() {
+				panic(panicErr.Value)
+			}
+			panic(http.ErrAbortHandler)
+		}
+		if panicErr := catch(func() {
+			n, err = writerTo.WriteTo(w)
+		}); panicErr != nil {
+			abort("Recovered from panic writing response", panicErr)
+		}
+		if err != nil && r.ProtoMajor < 2 {
+			if rsp.WriteMode == StreamingAbort {
+				logRequest(newWriteErrorLog(err, n, "aborted"))
+				panic(http.ErrAbortHandler)
+			}
+			logRequest(newWriteErrorLog(err, n, "truncated"))
+			return
+		}
+
+		if rsp.Trailers != nil {
+			if panicErr := catch(func() {
+				trailers = rsp.Trailers()
+			}); panicErr != nil {
+				abort("Recovered from panic computing trailers", panicErr)
+			}
+			for key, values := range trailers {
+				header[http.TrailerPrefix+key] = values
+			}
+		}
+		if err != nil {
+			header.Set(
+				http.TrailerPrefix+streamErrorTrailer,
+				http.StatusText(http.StatusInternalServerError),
+			)
+			logRequest(newWriteErrorLog(err, n, "trailer"))
+			return
+		}
+
+		logRequest(nil)
 	}
 }
 
@@ -294,6 +592,23 @@ type Route struct {
 
 	// Handler is the function which handles the request
 	Handler Handler
+
+	// ErrHandler is an alternative to Handler for handlers which return
+	// errors. Its errors are rendered by the router's `ErrorRenderer`. If both
+	// are set, Handler takes precedence.
+	ErrHandler ErrHandler
+
+	// WriteMode is the default WriteMode for the route's responses.
+	WriteMode WriteMode
+
+	// Middleware wraps the route's handler (Handler or ErrHandler), the
+	// first middleware being the outermost. See also `Group`.
+	Middleware []Middleware
+
+	// Requires is the route's authorization policy, e.g.,
+	// `AnyOf("admin", "billing:write")`, enforced inside the route's
+	// Middleware (which should authenticate the request). See `Authorize`.
+	Requires Policy
 }
 
 // StdlibRoute holds the complete routing information. It is the same as a
@@ -313,11 +628,191 @@ type StdlibRoute struct {
 
 // Router is an HTTP mux for httpeasy.
 type Router struct {
+	// ErrorRenderer renders the errors returned by the router's `ErrHandler`
+	// routes, as well as the errors the router encounters itself: panics
+	// (`*PanicError`), serializer failures (`*SerializerError`), and requests
+	// which don't match a route or exceed MaxBodyBytes (`*HTTPError`). If nil,
+	// `DefaultErrorRenderer` is used, except that requests the router rejects
+	// itself get the default error body (see ErrorBody). It's consulted on
+	// each request, so it may be set before or after routes are registered.
+	ErrorRenderer ErrorRenderer
+
+	// Repanic causes panics from handlers and serializers to be re-panicked
+	// after they're logged instead of being rendered by the ErrorRenderer.
+	// This is useful in development, where a crash is easier to notice than a
+	// 500.
+	Repanic bool
+
+	// MaxBodyBytes is the largest request body the router's handlers accept.
+	// Requests whose `Content-Length` header exceeds it are rejected with a
+	// 413 Request Entity Too Large response (rendered by the ErrorRenderer)
+	// without invoking the handler, and reading more than MaxBodyBytes from
+	// any request body fails with an `*http.MaxBytesError`. Zero means no
+	// limit.
+	MaxBodyBytes int64
+
+	// CORS, if set, enables cross-origin resource sharing for every route:
+	// CORS headers are added to responses for allowed origins, and preflight
+	// requests are answered using the methods the router has routes for on
+	// the requested path, so OPTIONS routes needn't be registered. It's
+	// consulted on each request. See also the `CORS` middleware.
+	CORS *CORSOptions
+
+	// ErrorBody, if set, renders the body of 4xx and 5xx responses which
+	// were built by this package's helpers (e.g., `NotFound(nil)`) without
+	// any data, in place of `PlainTextErrorBody`. Set it to
+	// `ProblemErrorBody` to render RFC 9457 `application/problem+json`
+	// bodies. It's applied to the responses of the route handlers and each
+	// of their middleware, before any outer middleware sees them, as well as
+	// to the errors the router renders itself (see ErrorRenderer). It's
+	// consulted on each request.
+	ErrorBody func(status int) Serializer
+
+	// Log logs the responses the router generates itself, i.e., 404s, 405s
+	// and CORS preflight responses for unregistered OPTIONS routes. If nil,
+	// they aren't logged. It's consulted on each request.
+	Log LogFunc
+
 	inner *mux.Router
+
+	// routes lists the registered routes. See `Router.Routes`.
+	routes []RouteInfo
+}
+
+// NewRouter constructs a new router. Requests which don't match any route
+// get 404 Not Found or 405 Method Not Allowed responses, rendered by the
+// router's ErrorRenderer.
+func NewRouter() *Router {
+	r := &Router{inner: mux.NewRouter()}
+	r.inner.NotFoundHandler = r.errorHandler(http.StatusNotFound)
+	r.inner.MethodNotAllowedHandler = r.errorHandler(
+		http.StatusMethodNotAllowed,
+	)
+	return r
+}
+
+// errorHandler returns an http.Handler which responds with the router's
+// rendering of an HTTPError with the provided status.
+func (r *Router) errorHandler(status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		log := r.Log
+		if log == nil {
+			log = func(interface{}) {}
+		}
+		Handler(func(request Request) Response {
+			if status == http.StatusMethodNotAllowed && r.CORS != nil &&
+				isPreflight(request) {
+				return r.CORS.preflight(request, r.allowedMethods(req))
+			}
+			rsp := r.routerError(request, status, "")
+			if status == http.StatusMethodNotAllowed {
+				rsp = rsp.WithHeaders(http.Header{
+					"Allow": r.allowedMethods(req),
+				})
+			}
+			return rsp
+		}).serve(log, r)(w, req)
+	})
+}
+
+// candidateMethods are the methods considered by `allowedMethods`.
+var candidateMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// allowedMethods returns the methods for which the router has a route
+// matching `req`'s URL.
+func (r *Router) allowedMethods(req *http.Request) []string {
+	var allowed []string
+	for _, method := range candidateMethods {
+		var match mux.RouteMatch
+		clone := req.Clone(req.Context())
+		clone.Method = method
+		if r.inner.Match(clone, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// bodyTooLarge reports whether a request body of `size` bytes exceeds the
+// router's MaxBodyBytes. The router may be nil.
+func (r *Router) bodyTooLarge(size int64) bool {
+	return r != nil && r.MaxBodyBytes > 0 && size > r.MaxBodyBytes
+}
+
+// renderError renders `err` with the router's ErrorRenderer, applying the
+// router's ErrorBody to the result. The router may be nil, in which case
+// `DefaultErrorRenderer` is used.
+func (r *Router) renderError(req Request, err error) Response {
+	if r == nil || r.ErrorRenderer == nil {
+		return r.applyErrorBody(DefaultErrorRenderer(req, err))
+	}
+	return r.applyErrorBody(r.ErrorRenderer(req, err))
+}
+
+// routerError renders an error the router generates itself (404, 405 or
+// 413). A custom ErrorRenderer is passed an `*HTTPError`; otherwise the
+// response gets the default error body, like those built by the helpers, and
+// `detail` is logged.
+func (r *Router) routerError(req Request, status int, detail string) Response {
+	if r.ErrorRenderer != nil {
+		return r.renderError(req, &HTTPError{Status: status, Detail: detail})
+	}
+	var logging []interface{}
+	if detail != "" {
+		logging = append(logging, struct {
+			Context string `json:"context"`
+			Detail  string `json:"detail"`
+		}{Context: "Request rejected by router", Detail: detail})
+	}
+	return r.applyErrorBody(errorResponse(status, nil, logging))
+}
+
+// applyErrorBody replaces a default error body with the router's ErrorBody,
+// if it has one. The router may be nil.
+func (r *Router) applyErrorBody(rsp Response) Response {
+	if rsp.defaultBody && r != nil && r.ErrorBody != nil {
+		rsp.Data = r.ErrorBody(rsp.Status)
+		rsp.defaultBody = false
+	}
+	return rsp
 }
 
-// NewRouter constructs a new router.
-func NewRouter() *Router { return &Router{mux.NewRouter()} }
+// applyCORS adds the router's CORS headers, if any, to the response. The
+// router may be nil.
+func (r *Router) applyCORS(req Request, rsp Response) Response {
+	if r == nil || r.CORS == nil || isPreflight(req) {
+		return rsp
+	}
+	return r.CORS.apply(req, rsp)
+}
+
+// errorBodies returns `middleware` interleaved with middleware which applies
+// the router's ErrorBody, so default error bodies are replaced as soon as any
+// layer returns them.
+func (r *Router) errorBodies(middleware []Middleware) []Middleware {
+	apply := func(next Handler) Handler {
+		return func(req Request) Response {
+			return r.applyErrorBody(next(req))
+		}
+	}
+	wrapped := make([]Middleware, 0, 2*len(middleware)+1)
+	wrapped = append(wrapped, apply)
+	for _, mw := range middleware {
+		wrapped = append(wrapped, mw, apply)
+	}
+	return wrapped
+}
+
+// repanic reports whether the router re-panics. The router may be nil.
+func (r *Router) repanic() bool { return r != nil && r.Repanic }
 
 // ServeHTTP implements the http.Handler interface for Router.
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -328,9 +823,27 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 // the same modified Router.
 func (r *Router) Register(log LogFunc, routes ...Route) *Router {
 	for _, route := range routes {
+		handler := route.Handler
+		if handler == nil && route.ErrHandler != nil {
+			handler = route.ErrHandler.Handler(r.renderError)
+		}
+		middleware := route.Middleware
+		if route.Requires.Allow != nil {
+			middleware = append(
+				middleware[:len(middleware):len(middleware)],
+				Authorize(route.Requires),
+			)
+		}
+		handler = handler.With(r.errorBodies(middleware)...).
+			writeMode(route.WriteMode)
+		r.routes = append(r.routes, RouteInfo{
+			Method:   route.Method,
+			Path:     route.Path,
+			Requires: route.Requires.Name,
+		})
 		r.inner.Path(route.Path).
 			Methods(route.Method).
-			HandlerFunc(route.Handler.HTTP(log))
+			HandlerFunc(handler.serve(log, r))
 	}
 	return r
 }
@@ -339,6 +852,10 @@ func (r *Router) Register(log LogFunc, routes ...Route) *Router {
 // the same modified Router.
 func (r *Router) RegisterStdlib(routes ...StdlibRoute) *Router {
 	for _, route := range routes {
+		r.routes = append(r.routes, RouteInfo{
+			Method: route.Method,
+			Path:   route.Path,
+		})
 		r.inner.Path(route.Path).
 			Methods(route.Method).
 			HandlerFunc(route.Handler)
@@ -350,7 +867,10 @@ func (r *Router) RegisterStdlib(routes ...StdlibRoute) *Router {
 // routes before returning it. It's purely a convenience wrapper around
 //
 //     r := NewRouter()
+//     r.Log = log
 //     r.Register(log, routes...)
 func Register(log LogFunc, routes ...Route) *Router {
-	return NewRouter().Register(log, routes...)
+	r := NewRouter()
+	r.Log = log
+	return r.Register(log, routes...)
 }
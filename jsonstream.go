@@ -0,0 +1,274 @@
+package httpeasy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// streamFlushItems is the number of items after which streaming
+	// serializers flush the response.
+	streamFlushItems = 100
+
+	// streamFlushInterval is the longest streaming serializers will hold
+	// written items before flushing the response.
+	streamFlushInterval = time.Second
+)
+
+// StreamError is returned by streaming serializers when an item can't be
+// produced or encoded partway through the stream. By that point the response
+// status and some of the body have already been sent, so the error shows up in
+// the request log's `writeError`, including how many items made it out.
+type StreamError struct {
+	// Index is the index of the item which failed.
+	Index int
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements the error interface for StreamError.
+func (err *StreamError) Error() string {
+	return fmt.Sprintf("streaming item %d: %v", err.Index, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *StreamError) Unwrap() error { return err.Err }
+
+// MarshalJSON implements json.Marshaler so the error is legible when it's
+// logged.
+func (err *StreamError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Context string `json:"context"`
+		Index   int    `json:"index"`
+		Error   string `json:"error"`
+	}{
+		Context: "Error partway through streamed response",
+		Index:   err.Index,
+		Error:   err.Err.Error(),
+	})
+}
+
+// Rows is the cursor interface implemented by `*database/sql.Rows`.
+type Rows interface {
+	Next() bool
+	Err() error
+	Close() error
+}
+
+// JSONArray streams the values returned by `next` as a JSON array. `next` is
+// called until it returns `io.EOF`, and each value is written as soon as it's
+// returned, so memory use doesn't grow with the size of the array and the
+// first bytes go out right away. The response is flushed every so often so
+// clients see progress. Any other error from `next` aborts the response with a
+// `*StreamError`. Since the values are consumed, the returned serializer can
+// only be written once.
+func JSONArray(next func() (interface{}, error)) Serializer {
+	return stream("application/json", func(w io.Writer) error {
+		return writeJSONArray(w, next)
+	})
+}
+
+// JSONChannel is like JSONArray, except the values are received from `ch`
+// until it's closed. If the response can't be written, or is never written
+// (e.g., because middleware replaced it or the handler panicked), the rest of
+// the channel is drained in the background once `r` has been served so the
+// sender isn't blocked forever.
+func JSONChannel(r Request, ch <-chan interface{}) Serializer {
+	var once sync.Once
+	drain := func() {
+		once.Do(func() {
+			go func() {
+				for range ch {
+				}
+			}()
+		})
+	}
+	r.onFinish(drain)
+	return JSONArray(func() (interface{}, error) {
+		v, ok := <-ch
+		if !ok {
+			return nil, io.EOF
+		}
+		return v, nil
+	}).onError(drain)
+}
+
+// JSONRows is like JSONArray, except the values come from a database cursor.
+// `scan` is called once per row to produce the value for the current row
+// (typically by calling `rows.Scan()`). The rows are closed once they're
+// exhausted or an error occurs, or once `r` has been served if the response
+// is never written (e.g., because middleware replaced it).
+//
+//     rows, err := db.Query("SELECT name, age FROM people")
+//     if err != nil {
+//         return HandleError("querying people", err)
+//     }
+//     return Ok(JSONRows(r, rows, func() (interface{}, error) {
+//         var p Person
+//         err := rows.Scan(&p.Name, &p.Age)
+//         return p, err
+//     }))
+//
+func JSONRows(
+	r Request,
+	rows Rows,
+	scan func() (interface{}, error),
+) Serializer {
+	var once sync.Once
+	done := func() { once.Do(func() { rows.Close() }) }
+	r.onFinish(done)
+	return JSONArray(func() (interface{}, error) {
+		if !rows.Next() {
+			if err := rows.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		return scan()
+	}).onDone(done)
+}
+
+// JSONObjectStream streams a JSON object whose members are `fields` plus one
+// more member, `arrayField`, whose value is a JSON array streamed from `next`
+// like JSONArray. This is handy for envelopes like
+// `{"count": 2, "items": [...]}`. The members from `fields` are written first,
+// in sorted order.
+func JSONObjectStream(
+	fields map[string]interface{},
+	arrayField string,
+	next func() (interface{}, error),
+) Serializer {
+	return stream("application/json", func(w io.Writer) error {
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if _, err := io.WriteString(w, "{"); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			data, err := json.Marshal(fields[key])
+			if err != nil {
+				return err
+			}
+			if err := writeJSONMember(w, key, data); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if err := writeJSONMember(w, arrayField, nil); err != nil {
+			return err
+		}
+		if err := writeJSONArray(w, next); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "}")
+		return err
+	})
+}
+
+func writeJSONMember(w io.Writer, key string, value []byte) error {
+	name, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(name, ':')); err != nil {
+		return err
+	}
+	_, err = w.Write(value)
+	return err
+}
+
+func writeJSONArray(w io.Writer, next func() (interface{}, error)) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	lastFlush, unflushed := time.Now(), 0
+	for i := 0; ; i++ {
+		v, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			flush(w)
+			return &StreamError{Index: i, Err: err}
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			flush(w)
+			return &StreamError{Index: i, Err: err}
+		}
+		if i > 0 {
+			data = append([]byte{','}, data...)
+		}
+		if _, err := w.Write(data); err != nil {
+			return &StreamError{Index: i, Err: err}
+		}
+
+		unflushed++
+		if unflushed >= streamFlushItems ||
+			time.Since(lastFlush) >= streamFlushInterval {
+			if err := flush(w); err != nil {
+				return &StreamError{Index: i, Err: err}
+			}
+			lastFlush, unflushed = time.Now(), 0
+		}
+	}
+
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// onError returns a serializer which calls `f` if writing its output fails.
+func (s Serializer) onError(f func()) Serializer {
+	return func() (io.WriterTo, error) {
+		writerTo, err := s()
+		if err != nil {
+			f()
+			return nil, err
+		}
+		return hookWriterTo{writerTo, func(err error) {
+			if err != nil {
+				f()
+			}
+		}}, nil
+	}
+}
+
+// onDone returns a serializer which calls `f` once its output is written,
+// whether or not writing succeeds.
+func (s Serializer) onDone(f func()) Serializer {
+	return func() (io.WriterTo, error) {
+		writerTo, err := s()
+		if err != nil {
+			f()
+			return nil, err
+		}
+		return hookWriterTo{writerTo, func(error) { f() }}, nil
+	}
+}
+
+// hookWriterTo calls `done` with the result of writing the inner
+// `io.WriterTo`.
+type hookWriterTo struct {
+	inner io.WriterTo
+	done  func(error)
+}
+
+func (hwt hookWriterTo) ContentType() string { return contentType(hwt.inner) }
+
+func (hwt hookWriterTo) WriteTo(w io.Writer) (int64, error) {
+	n, err := hwt.inner.WriteTo(w)
+	hwt.done(err)
+	return n, err
+}
@@ -0,0 +1,293 @@
+package httpeasy
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// jwk is a JSON Web Key (RFC 7517).
+type jwk struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	K         string `json:"k,omitempty"`
+}
+
+// jwks is a JSON Web Key Set.
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// ParseJWKS parses a JSON Web Key Set. Keys which aren't for signatures or
+// whose type isn't supported are skipped, since key sets commonly hold keys
+// for other purposes. Symmetric (`oct`) keys are accepted for HS256, so key
+// sets which hold secrets must be kept private.
+func ParseJWKS(data []byte) (StaticJWTKeys, error) {
+	return parseJWKS(data, true)
+}
+
+// parseJWKS parses a JSON Web Key Set. Unless `secrets` is set, symmetric
+// (`oct`) keys are rejected: they belong in a local file, never in a
+// published key set.
+func parseJWKS(data []byte, secrets bool) (StaticJWTKeys, error) {
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS: %w", err)
+	}
+	var keys StaticJWTKeys
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if k.KeyType == "oct" && !secrets {
+			return nil, fmt.Errorf(
+				"parsing JWKS: key %d: symmetric keys can't be published",
+				i,
+			)
+		}
+		key, err := k.key()
+		if err != nil {
+			return nil, fmt.Errorf("parsing JWKS: key %d: %w", i, err)
+		}
+		if key == nil {
+			continue
+		}
+		keys = append(keys, JWTKey{k.ID, k.Algorithm, key})
+	}
+	return keys, nil
+}
+
+// key decodes the JWK's key, returning nil for unsupported key types.
+func (k jwk) key() (interface{}, error) {
+	var err error
+	decode := func(field, value string) []byte {
+		data, decodeErr := base64.RawURLEncoding.DecodeString(value)
+		if err == nil && (decodeErr != nil || len(data) < 1) {
+			err = fmt.Errorf("invalid `%s` parameter", field)
+		}
+		return data
+	}
+
+	switch {
+	case k.KeyType == "oct":
+		secret := decode("k", k.K)
+		return secret, err
+	case k.KeyType == "RSA":
+		n, e := decode("n", k.N), decode("e", k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid `e` parameter")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(exponent.Int64()),
+		}, nil
+	case k.KeyType == "EC" && k.Curve == "P-256":
+		x, y := decode("x", k.X), decode("y", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("invalid P-256 coordinates")
+		}
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("invalid P-256 point: %w", err)
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case k.KeyType == "OKP" && k.Curve == "Ed25519":
+		x := decode("x", k.X)
+		if err == nil && len(x) != ed25519.PublicKeySize {
+			err = fmt.Errorf("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), err
+	}
+	return nil, nil
+}
+
+// LoadJWKSFile reads a JSON Web Key Set from a file. See `ParseJWKS`.
+func LoadJWKSFile(path string) (StaticJWTKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading JWKS file: %w", err)
+	}
+	return ParseJWKS(data)
+}
+
+// MarshalJWKS returns the JSON Web Key Set publishing the public halves of
+// `keys`, e.g., for an issuer's `jwks_uri` endpoint. HS256 secrets can't be
+// published, so they're rejected.
+func MarshalJWKS(keys ...JWTKey) ([]byte, error) {
+	set := jwks{Keys: make([]jwk, 0, len(keys))}
+	for _, key := range keys {
+		alg, err := key.algorithm()
+		if err != nil {
+			return nil, fmt.Errorf("marshaling JWKS: %w", err)
+		}
+		k := jwk{ID: key.ID, Algorithm: alg, Use: "sig"}
+		switch public := key.public().(type) {
+		case *rsa.PublicKey:
+			k.KeyType = "RSA"
+			k.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			k.E = base64.RawURLEncoding.EncodeToString(
+				big.NewInt(int64(public.E)).Bytes(),
+			)
+		case *ecdsa.PublicKey:
+			var x, y [32]byte
+			public.X.FillBytes(x[:])
+			public.Y.FillBytes(y[:])
+			k.KeyType, k.Curve = "EC", "P-256"
+			k.X = base64.RawURLEncoding.EncodeToString(x[:])
+			k.Y = base64.RawURLEncoding.EncodeToString(y[:])
+		case ed25519.PublicKey:
+			k.KeyType, k.Curve = "OKP", "Ed25519"
+			k.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			return nil, fmt.Errorf(
+				"marshaling JWKS: key `%s`: secret keys can't be published",
+				key.ID,
+			)
+		}
+		set.Keys = append(set.Keys, k)
+	}
+	return json.Marshal(set)
+}
+
+// defaultFetchClient fetches documents from identity providers (key sets and
+// discovery documents) when no client is configured. Unlike
+// `http.DefaultClient`, it gives up on providers which hang.
+var defaultFetchClient = &http.Client{Timeout: 10 * time.Second}
+
+// RemoteJWKS is a JWTKeySource which fetches a JSON Web Key Set from a URL,
+// e.g., an identity provider's `jwks_uri`. The key set is cached, and
+// refetched early when a token names an unknown key so key rotations are
+// picked up promptly. Symmetric (`oct`) keys in the fetched set are an error,
+// since a published HS256 secret lets anyone forge tokens. The zero value of
+// the unexported fields is ready to use; a RemoteJWKS must not be copied after
+// first use.
+type RemoteJWKS struct {
+	// URL is the location of the key set.
+	URL string
+
+	// Client fetches the key set. Defaults to a client with a 10 second
+	// timeout.
+	Client *http.Client
+
+	// MaxAge is how long the key set is cached. Defaults to one hour.
+	MaxAge time.Duration
+
+	// MinRefreshInterval limits how often unknown key IDs can trigger a
+	// refetch, and how often a failed fetch is retried. Defaults to one
+	// minute.
+	MinRefreshInterval time.Duration
+
+	lock     sync.Mutex
+	keys     StaticJWTKeys
+	fetched  time.Time
+	failed   time.Time
+	err      error
+	fetching chan struct{}
+}
+
+// JWTKeys implements JWTKeySource. If refetching fails, the cached keys are
+// used until they're too stale.
+func (s *RemoteJWKS) JWTKeys(kid string) ([]JWTKey, error) {
+	maxAge, minRefresh := s.MaxAge, s.MinRefreshInterval
+	if maxAge == 0 {
+		maxAge = time.Hour
+	}
+	if minRefresh == 0 {
+		minRefresh = time.Minute
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for {
+		now := time.Now()
+		age := now.Sub(s.fetched)
+		if !s.fetched.IsZero() && age < maxAge {
+			keys, _ := s.keys.JWTKeys(kid)
+			if len(keys) > 0 || age < minRefresh {
+				return keys, nil
+			}
+		}
+
+		// Concurrent requests wait for a single fetch rather than each
+		// fetching the key set.
+		if done := s.fetching; done != nil {
+			s.lock.Unlock()
+			<-done
+			s.lock.Lock()
+			continue
+		}
+
+		// Don't hammer a provider which is down.
+		if now.Sub(s.failed) >= minRefresh {
+			done := make(chan struct{})
+			s.fetching = done
+			s.lock.Unlock()
+			keys, err := s.fetch()
+			s.lock.Lock()
+			s.fetching = nil
+			close(done)
+			if err == nil {
+				s.keys, s.fetched, s.failed = keys, time.Now(), time.Time{}
+				return s.keys.JWTKeys(kid)
+			}
+			s.failed, s.err = time.Now(), err
+		}
+		if !s.fetched.IsZero() && age < 2*maxAge {
+			return s.keys.JWTKeys(kid)
+		}
+		return nil, s.err
+	}
+}
+
+func (s *RemoteJWKS) fetch() (StaticJWTKeys, error) {
+	client := s.Client
+	if client == nil {
+		client = defaultFetchClient
+	}
+	rsp, err := client.Get(s.URL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"fetching JWKS: `%s` returned status `%d`",
+			s.URL,
+			rsp.StatusCode,
+		)
+	}
+	data, err := io.ReadAll(io.LimitReader(rsp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	return parseJWKS(data, false)
+}
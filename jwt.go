@@ -0,0 +1,443 @@
+package httpeasy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// JWT signature algorithms.
+const (
+	JWTHS256 = "HS256"
+	JWTRS256 = "RS256"
+	JWTES256 = "ES256"
+	JWTEdDSA = "EdDSA"
+)
+
+// ErrUnsupportedJWTKey is returned for keys which don't match a supported JWT
+// algorithm.
+var ErrUnsupportedJWTKey = errors.New("httpeasy: unsupported JWT key")
+
+// JWTKey is a key for signing or verifying JWTs. Key holds a `[]byte` secret
+// for HS256, an RSA key for RS256, a P-256 ECDSA key for ES256 or an Ed25519
+// key for EdDSA. Private keys can sign and verify; public keys can only
+// verify.
+type JWTKey struct {
+	// ID is the key's `kid`. Tokens signed with the key carry it in their
+	// header so verifiers can pick the key out of a key set.
+	ID string
+
+	// Algorithm is the key's algorithm. Defaults to the algorithm implied by
+	// the key's type.
+	Algorithm string
+
+	// Key is the secret, private or public key.
+	Key interface{}
+}
+
+// algorithm returns the key's algorithm, checking it against the key's type.
+func (k JWTKey) algorithm() (string, error) {
+	var implied string
+	switch key := k.Key.(type) {
+	case []byte:
+		implied = JWTHS256
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		implied = JWTRS256
+	case *ecdsa.PrivateKey:
+		if key.Curve == elliptic.P256() {
+			implied = JWTES256
+		}
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P256() {
+			implied = JWTES256
+		}
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		implied = JWTEdDSA
+	}
+	if implied == "" || k.Algorithm != "" && k.Algorithm != implied {
+		return "", fmt.Errorf(
+			"%w: key `%s` of type `%T` for algorithm `%s`",
+			ErrUnsupportedJWTKey,
+			k.ID,
+			k.Key,
+			k.Algorithm,
+		)
+	}
+	return implied, nil
+}
+
+// public returns the key's public half (or the secret, for HS256).
+func (k JWTKey) public() interface{} {
+	switch key := k.Key.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey
+	case *ecdsa.PrivateKey:
+		return &key.PublicKey
+	case ed25519.PrivateKey:
+		return key.Public()
+	}
+	return k.Key
+}
+
+// sign signs `input` with the key.
+func (k JWTKey) sign(input []byte) ([]byte, error) {
+	digest := sha256.Sum256(input)
+	switch key := k.Key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature, nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, input), nil
+	}
+	return nil, fmt.Errorf(
+		"%w: key `%s` of type `%T` can't sign",
+		ErrUnsupportedJWTKey,
+		k.ID,
+		k.Key,
+	)
+}
+
+// verify reports whether `signature` is the key's signature of `input`.
+func (k JWTKey) verify(input, signature []byte) bool {
+	digest := sha256.Sum256(input)
+	switch key := k.public().(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(input)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(
+			key,
+			crypto.SHA256,
+			digest[:],
+			signature,
+		) == nil
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, input, signature)
+	}
+	return false
+}
+
+// JWTKeySource provides keys for verifying JWTs. See `StaticJWTKeys` and
+// `RemoteJWKS`.
+type JWTKeySource interface {
+	// JWTKeys returns the candidate keys for a token with the key ID `kid`,
+	// which is "" if the token doesn't name its key.
+	JWTKeys(kid string) ([]JWTKey, error)
+}
+
+// StaticJWTKeys is a fixed JWTKeySource.
+type StaticJWTKeys []JWTKey
+
+// JWTKeys implements JWTKeySource.
+func (keys StaticJWTKeys) JWTKeys(kid string) ([]JWTKey, error) {
+	if kid == "" {
+		return keys, nil
+	}
+	var found []JWTKey
+	for _, key := range keys {
+		if key.ID == kid {
+			found = append(found, key)
+		}
+	}
+	return found, nil
+}
+
+// JWTVerifier verifies JWTs (RFC 7519) with compact JWS signatures. Its
+// Verify method can be used with `BearerAuth`:
+//
+//     BearerAuth{Realm: "api", Verify: verifier.Verify}
+//
+// The token's claims become the principal's Claims (see `Request.Claims`),
+// its `sub` claim the principal's Subject, its `roles` claim the principal's
+// Roles and its `scope` claim the principal's Scopes.
+type JWTVerifier struct {
+	// Keys provides the verification keys. A token's `alg` header must match
+	// its key's algorithm.
+	Keys JWTKeySource
+
+	// Issuer, if set, must equal the token's `iss` claim.
+	Issuer string
+
+	// Audience, if set, must be among the token's `aud` claim.
+	Audience string
+
+	// ClockSkew is the leeway for the `exp`, `nbf` and `iat` claims.
+	// Defaults to one minute.
+	ClockSkew time.Duration
+
+	// Now is the clock which the `exp`, `nbf` and `iat` claims are checked
+	// against. Defaults to `time.Now`.
+	Now func() time.Time
+}
+
+// jwtHeader is the JOSE header of a JWT.
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// jwtClaims holds the registered claims which are validated, and the claims
+// which become the principal's roles.
+type jwtClaims struct {
+	Issuer    string     `json:"iss"`
+	Subject   string     `json:"sub"`
+	Audience  jwtStrings `json:"aud"`
+	Expires   *jwtTime   `json:"exp"`
+	NotBefore *jwtTime   `json:"nbf"`
+	IssuedAt  *jwtTime   `json:"iat"`
+	Roles     jwtStrings `json:"roles"`
+	Scope     string     `json:"scope"`
+}
+
+// jwtStrings is a claim which may be a string or an array of strings, like
+// `aud`.
+type jwtStrings []string
+
+func (strs *jwtStrings) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*strs = jwtStrings{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(strs))
+}
+
+// jwtTime is a NumericDate claim: seconds since the epoch, possibly
+// fractional.
+type jwtTime float64
+
+func (t jwtTime) time() time.Time {
+	return time.Unix(0, int64(float64(t)*float64(time.Second)))
+}
+
+// jwtError wraps ErrInvalidCredentials with the reason a token was rejected.
+func jwtError(format string, v ...interface{}) error {
+	return fmt.Errorf(
+		"%w: invalid JWT: %s",
+		ErrInvalidCredentials,
+		fmt.Sprintf(format, v...),
+	)
+}
+
+// Verify verifies a token's signature and claims and returns its principal.
+// Invalid tokens produce errors wrapping ErrInvalidCredentials; errors from
+// the key source are returned as-is.
+func (v *JWTVerifier) Verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, jwtError("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, jwtError("decoding header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, jwtError("decoding signature: %v", err)
+	}
+
+	keys, err := v.Keys.JWTKeys(header.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWT keys: %w", err)
+	}
+	input := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		// Only keys for the token's algorithm are tried, so a token can't
+		// trick the verifier into, e.g., using an RSA public key as an HMAC
+		// secret
+		if alg, err := key.algorithm(); err != nil || alg != header.Algorithm {
+			continue
+		}
+		if key.verify(input, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, jwtError(
+			"no key `%s` for `%s` matches the signature",
+			header.KeyID,
+			header.Algorithm,
+		)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, jwtError("decoding claims: %v", err)
+	}
+	if err := v.validate(&claims); err != nil {
+		return nil, err
+	}
+	var all map[string]interface{}
+	if err := decodeJWTPart(parts[1], &all); err != nil {
+		return nil, jwtError("decoding claims: %v", err)
+	}
+	return &Principal{
+		Subject: claims.Subject,
+		Scheme:  "jwt",
+		Roles:   append([]string(nil), claims.Roles...),
+		Scopes:  strings.Fields(claims.Scope),
+		Claims:  all,
+	}, nil
+}
+
+// validate checks the registered claims.
+func (v *JWTVerifier) validate(claims *jwtClaims) error {
+	now, skew := time.Now(), v.ClockSkew
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if skew == 0 {
+		skew = time.Minute
+	}
+
+	if exp := claims.Expires; exp != nil && !now.Before(exp.time().Add(skew)) {
+		return jwtError("expired at %s", exp.time().UTC())
+	}
+	if nbf := claims.NotBefore; nbf != nil && now.Add(skew).Before(nbf.time()) {
+		return jwtError("not valid before %s", nbf.time().UTC())
+	}
+	if iat := claims.IssuedAt; iat != nil && now.Add(skew).Before(iat.time()) {
+		return jwtError("issued in the future")
+	}
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return jwtError("wrong issuer `%s`", claims.Issuer)
+	}
+	if v.Audience != "" {
+		for _, aud := range claims.Audience {
+			if aud == v.Audience {
+				return nil
+			}
+		}
+		return jwtError(
+			"audience `%v` is missing `%s`",
+			claims.Audience,
+			v.Audience,
+		)
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Claims decodes the principal's claims (e.g., a JWT's claims) into `v`,
+// typically a struct with `json` tags. It returns ErrNoCredentials if the
+// request isn't authenticated.
+func (r Request) Claims(v interface{}) error {
+	if r.principal == nil {
+		return ErrNoCredentials
+	}
+	data, err := json.Marshal(r.principal.Claims)
+	if err != nil {
+		return fmt.Errorf("decoding claims: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decoding claims: %w", err)
+	}
+	return nil
+}
+
+// JWTSigner issues JWTs, e.g., for tests and internal tools.
+type JWTSigner struct {
+	// Key signs the tokens; its ID becomes their `kid` header.
+	Key JWTKey
+
+	// Issuer, if set, is the tokens' default `iss` claim.
+	Issuer string
+
+	// TTL, if set, determines the tokens' default `exp` claim.
+	TTL time.Duration
+
+	// Now returns the current time. Defaults to `time.Now`.
+	Now func() time.Time
+}
+
+// Sign issues a token with `claims`, which must marshal to a JSON object.
+// The `iat` claim and the signer's `iss` and `exp` claims are added unless
+// `claims` sets them.
+func (s JWTSigner) Sign(claims interface{}) (string, error) {
+	alg, err := s.Key.algorithm()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("signing JWT: marshaling claims: %w", err)
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return "", fmt.Errorf("signing JWT: claims aren't an object: %w", err)
+	}
+
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	setDefault := func(claim string, value interface{}) {
+		if _, ok := all[claim]; !ok {
+			all[claim] = value
+		}
+	}
+	setDefault("iat", now.Unix())
+	if s.Issuer != "" {
+		setDefault("iss", s.Issuer)
+	}
+	if s.TTL > 0 {
+		setDefault("exp", now.Add(s.TTL).Unix())
+	}
+
+	header, err := json.Marshal(jwtHeader{alg, s.Key.ID, "JWT"})
+	if err != nil {
+		return "", fmt.Errorf("signing JWT: marshaling header: %w", err)
+	}
+	if data, err = json.Marshal(all); err != nil {
+		return "", fmt.Errorf("signing JWT: marshaling claims: %w", err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(data)
+	signature, err := s.Key.sign([]byte(input))
+	if err != nil {
+		return "", fmt.Errorf("signing JWT: %w", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
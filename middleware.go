@@ -0,0 +1,47 @@
+package httpeasy
+
+// Middleware wraps a Handler in another Handler. Middleware can inspect or
+// modify the request before it reaches the wrapped handler as well as the
+// response on its way back out. For example:
+//
+//     Route{
+//         Path:    "/users",
+//         Method:  "GET",
+//         Handler: listUsers.With(Compress(CompressOptions{})),
+//     }
+//
+type Middleware func(Handler) Handler
+
+// With returns the handler wrapped in the provided middleware. The first
+// middleware is the outermost, so it sees the request first and the response
+// last.
+func (h Handler) With(middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Group returns copies of the routes with `middleware` applied outside of
+// each route's own Middleware, so a set of routes can share, e.g.,
+// authentication:
+//
+//     router.Register(log, Group(
+//         []Route{
+//             {Path: "/admin/users", Method: "GET", Handler: listUsers},
+//             {Path: "/admin/users", Method: "POST", Handler: createUser},
+//         },
+//         Authenticate(sessionAuth, apiKeyAuth),
+//     )...)
+//
+func Group(routes []Route, middleware ...Middleware) []Route {
+	grouped := make([]Route, len(routes))
+	for i, route := range routes {
+		route.Middleware = append(
+			middleware[:len(middleware):len(middleware)],
+			route.Middleware...,
+		)
+		grouped[i] = route
+	}
+	return grouped
+}
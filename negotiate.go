@@ -0,0 +1,150 @@
+package httpeasy
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Offer is one of the representations from which `Negotiate` chooses.
+type Offer struct {
+	// MediaType is the media type of the representation, written as the
+	// `Content-Type` header if the offer is chosen, e.g., `application/json`
+	// or `text/html; charset=utf-8`.
+	MediaType string
+
+	// Data serializes the representation.
+	Data Serializer
+}
+
+// Negotiate builds a response with the provided status whose body is the offer
+// which best satisfies the request's `Accept` header. Offers are weighed per
+// RFC 9110 section 12.5.1 (q-values, wildcards and media type parameters);
+// ties go to the offer listed first, as does a request without an `Accept`
+// header. The response's `Content-Type` is set from the chosen offer, and
+// `Vary: Accept` is always set. If none of the offers are acceptable, a 406
+// Not Acceptable is returned instead. For example:
+//
+//     return Negotiate(
+//         r,
+//         http.StatusOK,
+//         Offer{"application/json", JSON(user)},
+//         Offer{"text/html; charset=utf-8", HTMLTemplate(userPage, user)},
+//     )
+//
+func Negotiate(r Request, status int, offers ...Offer) Response {
+	mediaTypes := make([]string, len(offers))
+	for i, offer := range offers {
+		mediaTypes[i] = offer.MediaType
+	}
+
+	vary := http.Header{"Vary": []string{"Accept"}}
+	i, ok := negotiateMediaType(r.Headers["Accept"], mediaTypes)
+	if !ok {
+		return NotAcceptable(nil, struct {
+			Context string   `json:"context"`
+			Accept  []string `json:"accept"`
+			Offered []string `json:"offered"`
+		}{
+			Context: "No offered media type satisfies the `Accept` header",
+			Accept:  r.Headers["Accept"],
+			Offered: mediaTypes,
+		}).WithHeaders(vary)
+	}
+
+	return Response{
+		Status: status,
+		Data:   offers[i].Data,
+		Headers: http.Header{
+			"Content-Type": []string{offers[i].MediaType},
+			"Vary":         vary["Vary"],
+		},
+	}
+}
+
+// PreferredMediaType returns whichever of the offered media types best
+// satisfies the request's `Accept` header using the same rules as
+// `Negotiate`. It returns false if none of them are acceptable.
+func (r Request) PreferredMediaType(offered ...string) (string, bool) {
+	i, ok := negotiateMediaType(r.Headers["Accept"], offered)
+	if !ok {
+		return "", false
+	}
+	return offered[i], true
+}
+
+// negotiateMediaType returns the index of the offered media type with the
+// highest weight in the `Accept` header.
+func negotiateMediaType(accept []string, offered []string) (int, bool) {
+	if len(offered) == 0 {
+		return 0, false
+	}
+	items := parseAccept(accept)
+	if len(items) == 0 {
+		return 0, true
+	}
+
+	best, bestQ := 0, 0.0
+	for i, mediaType := range offered {
+		if q := mediaTypeWeight(items, mediaType); q > bestQ {
+			best, bestQ = i, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// mediaTypeWeight returns the weight of the most specific media range in
+// `items` which matches `mediaType`. Specificity is, from most to least
+// specific: `type/subtype` with parameters, `type/subtype`, `type/*` and
+// `*/*`.
+func mediaTypeWeight(items []acceptItem, mediaType string) float64 {
+	base, params, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return 0
+	}
+	typ, subtype := splitMediaType(base)
+
+	q, specificity := 0.0, -1
+	for _, item := range items {
+		itemType, itemSubtype := splitMediaType(item.value)
+		var s int
+		switch {
+		case itemType == "*" && itemSubtype == "*":
+			s = 0
+		case itemType == typ && itemSubtype == "*":
+			s = 1
+		case itemType == typ && itemSubtype == subtype:
+			s = 2
+			if len(item.params) > 0 {
+				if !paramsMatch(item.params, params) {
+					continue
+				}
+				s = 3
+			}
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = item.q, s
+		}
+	}
+	return q
+}
+
+func splitMediaType(mediaType string) (string, string) {
+	if i := strings.Index(mediaType, "/"); i >= 0 {
+		return mediaType[:i], mediaType[i+1:]
+	}
+	return mediaType, ""
+}
+
+// paramsMatch reports whether every parameter of a media range is present in
+// the media type's parameters.
+func paramsMatch(rangeParams, params map[string]string) bool {
+	for key, value := range rangeParams {
+		if !strings.EqualFold(params[key], value) {
+			return false
+		}
+	}
+	return true
+}
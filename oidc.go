@@ -0,0 +1,690 @@
+package httpeasy
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoSession is returned when a feature which stores state in the session
+// is used without the `Sessions` middleware.
+var ErrNoSession = errors.New("httpeasy: the Sessions middleware is required")
+
+// oidcSessionKey is the session key holding the logged in user.
+const oidcSessionKey = "httpeasy.oidc"
+
+// oidcFlowTimeout is how long a user has to complete a login at the provider.
+const oidcFlowTimeout = 10 * time.Minute
+
+// OIDCOptions configures an `OIDC` login flow.
+type OIDCOptions struct {
+	// Issuer is the provider's issuer URL, e.g.,
+	// `https://accounts.example.com`. The provider's endpoints are
+	// discovered from `{Issuer}/.well-known/openid-configuration`.
+	Issuer string
+
+	// ClientID and ClientSecret are the app's credentials with the provider.
+	// ClientSecret may be empty for public clients, which rely on PKCE alone.
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the absolute URL of the callback route registered with
+	// the provider, e.g., `https://app.example.com/auth/callback`. The
+	// callback route's path is taken from it.
+	RedirectURL string
+
+	// Scopes are the requested scopes. Defaults to `openid`, `profile` and
+	// `email`.
+	Scopes []string
+
+	// Codec encrypts the cookie which carries the state, nonce and PKCE
+	// verifier between the login and callback routes. It should use
+	// `Encrypted` mode.
+	Codec *CookieCodec
+
+	// LoginPath and LogoutPath are the paths of the login and logout routes.
+	// They default to `/login` and `/logout`.
+	LoginPath  string
+	LogoutPath string
+
+	// AfterLogin is where users are sent after logging in, unless the login
+	// route was given a `return_to` path. Defaults to `/`.
+	AfterLogin string
+
+	// AfterLogout is where users are sent after logging out. Defaults to
+	// `/`. If the provider supports RP-initiated logout, users are sent to
+	// the provider first, which redirects them to PostLogoutRedirectURL.
+	AfterLogout string
+
+	// PostLogoutRedirectURL is the absolute URL the provider redirects to
+	// after logging out, if it supports RP-initiated logout.
+	PostLogoutRedirectURL string
+
+	// Insecure omits the flow cookie's `Secure` attribute so logins work over
+	// plain HTTP, e.g., during local development.
+	Insecure bool
+
+	// Client makes requests to the provider. Defaults to a client with a 10
+	// second timeout.
+	Client *http.Client
+
+	// Now is the clock by which login flows expire, ID tokens are checked
+	// and failed discovery is retried. Defaults to `time.Now`.
+	Now func() time.Time
+}
+
+// OIDC is an OpenID Connect login flow for server-rendered apps, using the
+// authorization code flow with PKCE. Register its `Routes` (login, callback
+// and logout) inside the `Sessions` middleware; the logged in user is stored
+// in the session (see `Request.OIDCUser`), and OIDC is an Authenticator so
+// it can be combined with other schemes:
+//
+//     login, err := NewOIDC(OIDCOptions{
+//         Issuer:       "https://accounts.example.com",
+//         ClientID:     "app",
+//         ClientSecret: secret,
+//         RedirectURL:  "https://app.example.com/auth/callback",
+//         Codec:        &CookieCodec{Mode: Encrypted, Keys: keys},
+//     })
+//     if err != nil {
+//         return err
+//     }
+//     sessions := Sessions(SessionOptions{Store: &MemoryStore{}})
+//     router.Register(log, Group(login.Routes(), sessions)...)
+//     router.Register(log, Group(
+//         appRoutes,
+//         sessions,
+//         login.RequireLogin(),
+//     )...)
+type OIDC struct {
+	options      OIDCOptions
+	callbackPath string
+
+	lock        sync.Mutex
+	provider    *oidcProvider
+	failed      time.Time
+	err         error
+	discovering chan struct{}
+}
+
+// oidcProvider holds the discovered provider metadata.
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+
+	verifier *JWTVerifier
+}
+
+// NewOIDC validates the options and returns the login flow. The provider
+// isn't contacted until the first login.
+func NewOIDC(options OIDCOptions) (*OIDC, error) {
+	if options.Issuer == "" || options.ClientID == "" ||
+		options.RedirectURL == "" || options.Codec == nil {
+		return nil, errors.New(
+			"httpeasy: OIDC requires Issuer, ClientID, RedirectURL and Codec",
+		)
+	}
+	redirect, err := url.Parse(options.RedirectURL)
+	if err != nil || !redirect.IsAbs() {
+		return nil, fmt.Errorf(
+			"httpeasy: OIDC RedirectURL `%s` isn't an absolute URL",
+			options.RedirectURL,
+		)
+	}
+	if len(options.Scopes) < 1 {
+		options.Scopes = []string{"openid", "profile", "email"}
+	}
+	if options.LoginPath == "" {
+		options.LoginPath = "/login"
+	}
+	if options.LogoutPath == "" {
+		options.LogoutPath = "/logout"
+	}
+	if options.AfterLogin == "" {
+		options.AfterLogin = "/"
+	}
+	if options.AfterLogout == "" {
+		options.AfterLogout = "/"
+	}
+	if options.Client == nil {
+		options.Client = defaultFetchClient
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+	return &OIDC{options: options, callbackPath: redirect.Path}, nil
+}
+
+// Routes returns the login (GET), callback (GET) and logout (POST) routes.
+// The login route accepts a `return_to` query parameter: a local path to
+// send the user to after logging in.
+func (o *OIDC) Routes() []Route {
+	return []Route{
+		{Method: "GET", Path: o.options.LoginPath, Handler: o.login},
+		{Method: "GET", Path: o.callbackPath, Handler: o.callback},
+		{Method: "POST", Path: o.options.LogoutPath, Handler: o.logout},
+	}
+}
+
+// oidcDiscoveryRetryInterval is how long discovery failures are cached
+// before the provider is contacted again.
+const oidcDiscoveryRetryInterval = time.Minute
+
+// discover returns the provider metadata, fetching it on first use.
+// Concurrent requests wait for a single fetch, and failures are cached for
+// oidcDiscoveryRetryInterval so a provider which is down isn't hammered.
+func (o *OIDC) discover() (*oidcProvider, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for {
+		if o.provider != nil {
+			return o.provider, nil
+		}
+		if done := o.discovering; done != nil {
+			o.lock.Unlock()
+			<-done
+			o.lock.Lock()
+			continue
+		}
+		if o.options.Now().Sub(o.failed) < oidcDiscoveryRetryInterval {
+			return nil, o.err
+		}
+
+		done := make(chan struct{})
+		o.discovering = done
+		o.lock.Unlock()
+		provider, err := o.fetchProvider()
+		o.lock.Lock()
+		o.discovering = nil
+		close(done)
+		if err != nil {
+			o.failed, o.err = o.options.Now(), err
+			return nil, err
+		}
+		o.provider = provider
+	}
+}
+
+// fetchProvider fetches and validates the provider metadata.
+func (o *OIDC) fetchProvider() (*oidcProvider, error) {
+	var provider oidcProvider
+	if err := o.getJSON(
+		strings.TrimSuffix(o.options.Issuer, "/")+
+			"/.well-known/openid-configuration",
+		"",
+		&provider,
+	); err != nil {
+		return nil, fmt.Errorf("discovering OIDC provider: %w", err)
+	}
+	if provider.Issuer != o.options.Issuer {
+		return nil, fmt.Errorf(
+			"discovering OIDC provider: issuer `%s` doesn't match `%s`",
+			provider.Issuer,
+			o.options.Issuer,
+		)
+	}
+	if provider.AuthorizationEndpoint == "" ||
+		provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, errors.New(
+			"discovering OIDC provider: metadata is missing endpoints",
+		)
+	}
+	provider.verifier = &JWTVerifier{
+		Keys: &RemoteJWKS{
+			URL:    provider.JWKSURI,
+			Client: o.options.Client,
+		},
+		Issuer:   provider.Issuer,
+		Audience: o.options.ClientID,
+		Now:      o.options.Now,
+	}
+	return &provider, nil
+}
+
+// getJSON fetches JSON from the provider, authorizing the request with
+// `token` if it isn't empty.
+func (o *OIDC) getJSON(url, token string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return o.do(req, v)
+}
+
+// do sends a request to the provider and decodes its JSON response.
+func (o *OIDC) do(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	rsp, err := o.options.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(rsp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("reading `%s`: %w", req.URL, err)
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return &oidcProviderError{req.URL.String(), rsp.StatusCode, data}
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decoding `%s`: %w", req.URL, err)
+	}
+	return nil
+}
+
+// oidcProviderError is an error response from the provider.
+type oidcProviderError struct {
+	url    string
+	status int
+	body   []byte
+}
+
+func (err *oidcProviderError) Error() string {
+	return fmt.Sprintf(
+		"`%s` returned status `%d`: %s",
+		err.url,
+		err.status,
+		err.body,
+	)
+}
+
+// oidcFlow is the state carried between the login and callback routes in
+// the flow cookie.
+type oidcFlow struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	ReturnTo string    `json:"returnTo"`
+	Started  time.Time `json:"started"`
+}
+
+// flowCookie returns the flow cookie's attributes; it's only sent to the
+// callback route.
+func (o *OIDC) flowCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     "oidc_flow",
+		Value:    value,
+		Path:     o.callbackPath,
+		MaxAge:   maxAge,
+		Secure:   !o.options.Insecure,
+		HttpOnly: true,
+		// Lax, so the cookie is sent on the provider's top-level redirect
+		// back to the callback.
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// randomToken returns 32 random bytes, base64url-encoded.
+func randomToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("generating random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func (o *OIDC) login(r Request) Response {
+	provider, err := o.discover()
+	if err != nil {
+		return HandleError("Error starting login", err)
+	}
+
+	returnTo := r.URL.Query().Get("return_to")
+	flow := oidcFlow{
+		ReturnTo: localPath(returnTo, o.options.AfterLogin),
+		Started:  o.options.Now(),
+	}
+	for _, token := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		if *token, err = randomToken(); err != nil {
+			return HandleError("Error starting login", err)
+		}
+	}
+	value, err := o.options.Codec.Encode("oidc_flow", flow)
+	if err != nil {
+		return HandleError("Error starting login", err)
+	}
+
+	location, err := url.Parse(provider.AuthorizationEndpoint)
+	if err != nil {
+		return HandleError("Error starting login", err)
+	}
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+	query := location.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", o.options.ClientID)
+	query.Set("redirect_uri", o.options.RedirectURL)
+	query.Set("scope", strings.Join(o.options.Scopes, " "))
+	query.Set("state", flow.State)
+	query.Set("nonce", flow.Nonce)
+	query.Set(
+		"code_challenge",
+		base64.RawURLEncoding.EncodeToString(challenge[:]),
+	)
+	query.Set("code_challenge_method", "S256")
+	location.RawQuery = query.Encode()
+
+	return Found(location.String()).WithCookies(
+		o.flowCookie(value, int(oidcFlowTimeout/time.Second)),
+	)
+}
+
+// localPath returns `path` if it's a path on this site, or `fallback`, so
+// `return_to` can't be used to redirect users to other sites. Backslashes and
+// control characters are rejected outright, since browsers treat the former
+// like slashes and strip the latter (e.g., `/\t/evil.example` is followed as
+// `//evil.example`).
+func localPath(path, fallback string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") ||
+		strings.Contains(path, `\`) {
+		return fallback
+	}
+	for _, c := range path {
+		if c < 0x20 || c == 0x7f {
+			return fallback
+		}
+	}
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return fallback
+	}
+	return path
+}
+
+// oidcTokens is the token endpoint's response.
+type oidcTokens struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+}
+
+// loginFailed rejects a callback with 401 Unauthorized.
+func loginFailed(detail string, err error) Response {
+	return HandleError(
+		"Rejected login",
+		&HTTPError{Status: http.StatusUnauthorized, Detail: detail},
+		authLog{"Login failed", err.Error()},
+	)
+}
+
+func (o *OIDC) callback(r Request) Response {
+	session := r.Session()
+	if session == nil {
+		return HandleError("Error completing login", ErrNoSession)
+	}
+	expire := o.flowCookie("", -1)
+	query := r.URL.Query()
+	if code := query.Get("error"); code != "" {
+		return loginFailed(
+			"The identity provider declined the login.",
+			fmt.Errorf("provider returned `%s`: %s", code, query.Get(
+				"error_description",
+			)),
+		).WithCookies(expire)
+	}
+
+	var flow oidcFlow
+	cookie, err := r.Cookie("oidc_flow")
+	if err == nil {
+		err = o.options.Codec.Decode("oidc_flow", cookie.Value, &flow)
+	}
+	if expires := flow.Started.Add(oidcFlowTimeout); err == nil &&
+		!o.options.Now().Before(expires) {
+		err = errors.New("login flow expired")
+	}
+	if err != nil {
+		return loginFailed(
+			"The login expired or wasn't started here; please try again.",
+			err,
+		).WithCookies(expire)
+	}
+	if subtle.ConstantTimeCompare(
+		[]byte(query.Get("state")),
+		[]byte(flow.State),
+	) != 1 {
+		return loginFailed(
+			"The login expired or wasn't started here; please try again.",
+			errors.New("state mismatch"),
+		).WithCookies(expire)
+	}
+
+	provider, err := o.discover()
+	if err != nil {
+		return HandleError("Error completing login", err)
+	}
+	tokens, err := o.exchange(provider, query.Get("code"), flow.Verifier)
+	var providerErr *oidcProviderError
+	if errors.As(err, &providerErr) && providerErr.status < 500 {
+		return loginFailed(
+			"The login couldn't be completed; please try again.",
+			err,
+		).WithCookies(expire)
+	}
+	if err != nil {
+		return HandleError("Error completing login", err)
+	}
+
+	principal, err := provider.verifier.Verify(tokens.IDToken)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return loginFailed("The identity token is invalid.", err).
+			WithCookies(expire)
+	}
+	if err != nil {
+		return HandleError("Error completing login", err)
+	}
+	nonce, _ := principal.Claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(flow.Nonce)) != 1 {
+		return loginFailed(
+			"The identity token is invalid.",
+			errors.New("nonce mismatch"),
+		).WithCookies(expire)
+	}
+
+	user, err := o.user(provider, principal, tokens)
+	if err != nil {
+		return HandleError("Error completing login", err)
+	}
+	// Renew the session ID so a session fixed before login can't be used
+	// to hijack the logged in session
+	session.RenewID()
+	if err := session.Set(oidcSessionKey, oidcSession{
+		User:    user,
+		IDToken: tokens.IDToken,
+	}); err != nil {
+		return HandleError("Error completing login", err)
+	}
+	return SeeOther(flow.ReturnTo).WithCookies(expire)
+}
+
+// exchange redeems the authorization code at the token endpoint.
+func (o *OIDC) exchange(
+	provider *oidcProvider,
+	code string,
+	verifier string,
+) (*oidcTokens, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.options.RedirectURL},
+		"client_id":     {o.options.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(
+		"POST",
+		provider.TokenEndpoint,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if o.options.ClientSecret != "" {
+		req.SetBasicAuth(
+			url.QueryEscape(o.options.ClientID),
+			url.QueryEscape(o.options.ClientSecret),
+		)
+	}
+	var tokens oidcTokens
+	if err := o.do(req, &tokens); err != nil {
+		return nil, fmt.Errorf("exchanging authorization code: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New(
+			"exchanging authorization code: no ID token was returned",
+		)
+	}
+	return &tokens, nil
+}
+
+// user builds the user from the ID token's claims, adding the provider's
+// user info if it has a user info endpoint.
+func (o *OIDC) user(
+	provider *oidcProvider,
+	principal *Principal,
+	tokens *oidcTokens,
+) (*OIDCUser, error) {
+	claims := principal.Claims
+	if provider.UserInfoEndpoint != "" && tokens.AccessToken != "" {
+		var info map[string]interface{}
+		if err := o.getJSON(
+			provider.UserInfoEndpoint,
+			tokens.AccessToken,
+			&info,
+		); err != nil {
+			return nil, fmt.Errorf("fetching user info: %w", err)
+		}
+		// The user info must be for the same user as the ID token (OpenID
+		// Connect Core 5.3.2)
+		if info["sub"] != principal.Subject {
+			return nil, errors.New("fetching user info: subject mismatch")
+		}
+		for key, value := range info {
+			if _, ok := claims[key]; !ok {
+				claims[key] = value
+			}
+		}
+	}
+
+	user := &OIDCUser{Subject: principal.Subject, Claims: claims}
+	user.Email, _ = claims["email"].(string)
+	user.Name, _ = claims["name"].(string)
+	user.Roles = principal.Roles
+	return user, nil
+}
+
+func (o *OIDC) logout(r Request) Response {
+	session := r.Session()
+	if session == nil {
+		return HandleError("Error logging out", ErrNoSession)
+	}
+	var state oidcSession
+	loggedIn := session.Get(oidcSessionKey, &state)
+	session.Destroy()
+
+	provider, err := o.discover()
+	if !loggedIn || err != nil || provider.EndSessionEndpoint == "" {
+		// Logging out of the app doesn't depend on the provider
+		return SeeOther(o.options.AfterLogout)
+	}
+	location, err := url.Parse(provider.EndSessionEndpoint)
+	if err != nil {
+		return SeeOther(o.options.AfterLogout)
+	}
+	query := location.Query()
+	query.Set("client_id", o.options.ClientID)
+	query.Set("id_token_hint", state.IDToken)
+	if o.options.PostLogoutRedirectURL != "" {
+		query.Set("post_logout_redirect_uri", o.options.PostLogoutRedirectURL)
+	}
+	location.RawQuery = query.Encode()
+	return SeeOther(location.String())
+}
+
+// OIDCUser is a user logged in by `OIDC`.
+type OIDCUser struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email,omitempty"`
+	Name    string   `json:"name,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+
+	// Claims holds the ID token's claims, plus the provider's user info.
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// oidcSession is the session value stored by `OIDC`.
+type oidcSession struct {
+	User    *OIDCUser `json:"user"`
+	IDToken string    `json:"idToken"`
+}
+
+// OIDCUser returns the user logged in by `OIDC`, or nil if the request's
+// session (see `Sessions`) has none.
+func (r Request) OIDCUser() *OIDCUser {
+	var state oidcSession
+	if r.session == nil || !r.session.Get(oidcSessionKey, &state) {
+		return nil
+	}
+	return state.User
+}
+
+// Authenticate implements Authenticator using the session's logged in user.
+func (o *OIDC) Authenticate(r Request) (*Principal, error) {
+	user := r.OIDCUser()
+	if user == nil {
+		return nil, ErrNoCredentials
+	}
+	return &Principal{
+		Subject: user.Subject,
+		Scheme:  "oidc",
+		Roles:   user.Roles,
+		Claims:  user.Claims,
+	}, nil
+}
+
+// Challenge implements Authenticator. Browsers are sent to log in by
+// `RequireLogin` rather than challenged.
+func (o *OIDC) Challenge(error) string { return "" }
+
+// RequireLogin returns middleware which sends users who aren't logged in to
+// the login route, returning them to the requested page afterwards. Only
+// GET and HEAD requests are redirected; others get 401 Unauthorized. Apply
+// it inside the `Sessions` middleware; the principal is available via
+// `Request.Principal`.
+func (o *OIDC) RequireLogin() Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) Response {
+			principal, err := o.Authenticate(r)
+			if err == nil {
+				r.principal = principal
+				return next(r)
+			}
+			if r.Method != "GET" && r.Method != "HEAD" {
+				return HandleError(
+					"Rejected unauthenticated request",
+					&HTTPError{
+						Status: http.StatusUnauthorized,
+						Detail: "Authentication is required.",
+					},
+				)
+			}
+			return Found(o.options.LoginPath + "?" + url.Values{
+				"return_to": {r.URL.RequestURI()},
+			}.Encode())
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package httpeasy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// PanicError is the error passed to the router's `ErrorRenderer` when a
+// handler or serializer panics. If the panic value is itself an error (e.g.,
+// a `runtime.Error`), PanicError wraps it.
+type PanicError struct {
+	// Value is the value passed to `panic()`.
+	Value interface{}
+
+	// Stack is the stack trace of the panicking goroutine, excluding frames
+	// from the Go runtime and net/http.
+	Stack string
+}
+
+func (err *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", err.Value)
+}
+
+func (err *PanicError) Unwrap() error {
+	if e, ok := err.Value.(error); ok {
+		return e
+	}
+	return nil
+}
+
+// catch calls `f`, recovering from any panic. `http.ErrAbortHandler` is
+// re-panicked, since it's net/http's signal to abort the response.
+func catch(f func()) (panicErr *PanicError) {
+	defer func() {
+		if v := recover(); v != nil {
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			// skip `runtime.Callers`, `callers` and this function
+			panicErr = &PanicError{Value: v, Stack: formatStack(callers(3))}
+		}
+	}()
+	f()
+	return nil
+}
+
+// panicLog describes a recovered panic in the request log.
+type panicLog struct {
+	Context string `json:"context"`
+	Panic   string `json:"panic"`
+	Route   string `json:"route,omitempty"`
+	Stack   string `json:"stack"`
+}
+
+func newPanicLog(context string, r *http.Request, err *PanicError) panicLog {
+	return panicLog{
+		Context: context,
+		Panic:   fmt.Sprint(err.Value),
+		Route:   routeTemplate(r),
+		Stack:   err.Stack,
+	}
+}
+
+// routeTemplate returns the path template of the route which matched `r`, or
+// the empty string if `r` wasn't routed by a Router.
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return template
+}
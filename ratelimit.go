@@ -0,0 +1,388 @@
+package httpeasy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rateLimitAlgorithm decides whether a request is within a key's limit. It's
+// implemented by `TokenBucket` and `SlidingWindow`.
+type rateLimitAlgorithm interface {
+	// take updates a key's state (nil for a new key) for a request at `now`
+	// and returns the new state and the decision.
+	take(state []byte, now time.Time) ([]byte, rateDecision)
+
+	// ttl is how long a key's state is relevant after its last update.
+	ttl() time.Duration
+
+	// policy describes the limit for the `RateLimit-Policy` header.
+	policy() string
+
+	// limit is the number of requests in the policy's quota.
+	limit() int
+
+	// validate reports whether the algorithm's parameters are usable.
+	validate() error
+}
+
+// rateDecision is the outcome of a `rateLimitAlgorithm.take`.
+type rateDecision struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration // until the quota is fully restored
+	retryAfter time.Duration // until the next request would be allowed
+}
+
+// TokenBucket allows bursts of up to Limit requests and refills at a steady
+// Limit requests per Period: a key which has been idle for a Period has its
+// full Limit available.
+type TokenBucket struct {
+	Limit  int
+	Period time.Duration
+}
+
+func (b TokenBucket) ttl() time.Duration { return b.Period }
+func (b TokenBucket) limit() int         { return b.Limit }
+
+func (b TokenBucket) validate() error {
+	if b.Limit < 1 || b.Period <= 0 {
+		return fmt.Errorf(
+			"TokenBucket requires a positive Limit and Period; found %d and %s",
+			b.Limit,
+			b.Period,
+		)
+	}
+	return nil
+}
+
+func (b TokenBucket) policy() string {
+	return fmt.Sprintf("%d;w=%d", b.Limit, int64(b.Period/time.Second))
+}
+
+func (b TokenBucket) take(state []byte, now time.Time) ([]byte, rateDecision) {
+	// State is the token count (float64 bits) and the time it was computed
+	// (unix nanoseconds).
+	tokens := float64(b.Limit)
+	if len(state) == 16 {
+		tokens = math.Float64frombits(binary.BigEndian.Uint64(state))
+		last := time.Unix(0, int64(binary.BigEndian.Uint64(state[8:])))
+		if elapsed := now.Sub(last); elapsed > 0 {
+			tokens += elapsed.Seconds() * b.rate()
+		}
+		if tokens > float64(b.Limit) {
+			tokens = float64(b.Limit)
+		}
+	}
+
+	var decision rateDecision
+	if tokens >= 1 {
+		tokens--
+		decision.allowed = true
+	} else {
+		decision.retryAfter = b.duration(1 - tokens)
+	}
+	decision.remaining = int(tokens)
+	decision.reset = b.duration(float64(b.Limit) - tokens)
+
+	state = make([]byte, 16)
+	binary.BigEndian.PutUint64(state, math.Float64bits(tokens))
+	binary.BigEndian.PutUint64(state[8:], uint64(now.UnixNano()))
+	return state, decision
+}
+
+// rate is the refill rate in tokens per second.
+func (b TokenBucket) rate() float64 {
+	return float64(b.Limit) / b.Period.Seconds()
+}
+
+// duration is how long it takes to refill `tokens` tokens.
+func (b TokenBucket) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / b.rate() * float64(time.Second))
+}
+
+// SlidingWindow allows Limit requests in any Window-long period. It uses the
+// sliding window counter approximation: the count for the previous fixed
+// window is weighted by how much of it the sliding window still overlaps, so
+// only two counters are stored per key.
+type SlidingWindow struct {
+	Limit  int
+	Window time.Duration
+}
+
+func (w SlidingWindow) ttl() time.Duration { return 2 * w.Window }
+func (w SlidingWindow) limit() int         { return w.Limit }
+
+func (w SlidingWindow) validate() error {
+	if w.Limit < 1 || w.Window <= 0 {
+		return fmt.Errorf(
+			"SlidingWindow requires a positive Limit and Window; found %d "+
+				"and %s",
+			w.Limit,
+			w.Window,
+		)
+	}
+	return nil
+}
+
+func (w SlidingWindow) policy() string {
+	return fmt.Sprintf("%d;w=%d", w.Limit, int64(w.Window/time.Second))
+}
+
+func (w SlidingWindow) take(
+	state []byte,
+	now time.Time,
+) ([]byte, rateDecision) {
+	// State is the current window's start (unix nanoseconds) and the
+	// current and previous windows' counts.
+	start := now.Truncate(w.Window)
+	var current, previous uint64
+	if len(state) == 24 {
+		stateStart := time.Unix(0, int64(binary.BigEndian.Uint64(state)))
+		switch {
+		case stateStart.Equal(start):
+			current = binary.BigEndian.Uint64(state[8:])
+			previous = binary.BigEndian.Uint64(state[16:])
+		case stateStart.Add(w.Window).Equal(start):
+			previous = binary.BigEndian.Uint64(state[8:])
+		}
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(w.Window)
+	used := float64(previous)*weight + float64(current)
+
+	var decision rateDecision
+	if used+1 <= float64(w.Limit) {
+		current++
+		used++
+		decision.allowed = true
+	} else {
+		decision.retryAfter = w.retryAfter(elapsed, current, previous)
+	}
+	decision.remaining = int(math.Max(0, float64(w.Limit)-used))
+	decision.reset = w.Window - elapsed
+
+	state = make([]byte, 24)
+	binary.BigEndian.PutUint64(state, uint64(start.UnixNano()))
+	binary.BigEndian.PutUint64(state[8:], current)
+	binary.BigEndian.PutUint64(state[16:], previous)
+	return state, decision
+}
+
+// retryAfter is how long until the weighted count drops enough to allow
+// another request.
+func (w SlidingWindow) retryAfter(
+	elapsed time.Duration,
+	current uint64,
+	previous uint64,
+) time.Duration {
+	window := float64(w.Window)
+	budget := float64(w.Limit - 1)
+
+	// Within this window, the previous window's weight must fall far enough
+	if float64(current) <= budget && previous > 0 {
+		t := window*(1-(budget-float64(current))/float64(previous)) -
+			float64(elapsed)
+		if float64(elapsed)+t < window {
+			return time.Duration(math.Max(t, 0))
+		}
+	}
+
+	// Otherwise wait for the next window, in which this window's count
+	// becomes the previous one
+	wait := w.Window - elapsed
+	if float64(current) > budget && current > 0 {
+		wait += time.Duration(window * (1 - budget/float64(current)))
+	}
+	return wait
+}
+
+// RateLimitStore holds rate limiter state. Implementations must apply
+// updates to a key atomically; see `MemoryRateLimitStore`.
+type RateLimitStore interface {
+	// Update atomically replaces the state stored under `key` with the
+	// result of `f`, which is passed the current state (nil if there is
+	// none). The store may discard the state once it's `ttl` old, going by
+	// `now` (the middleware's clock) rather than its own.
+	Update(
+		key string,
+		now time.Time,
+		ttl time.Duration,
+		f func(state []byte) []byte,
+	) error
+}
+
+// RateLimitOptions configures the `RateLimit` middleware. Only Algorithm is
+// required.
+type RateLimitOptions struct {
+	// Algorithm determines the limit: a `TokenBucket` or a
+	// `SlidingWindow`.
+	Algorithm rateLimitAlgorithm
+
+	// Store holds the limiter's state. Defaults to a new
+	// `MemoryRateLimitStore`; set it to share limits between handlers or
+	// processes.
+	Store RateLimitStore
+
+	// Key returns the key to limit the request by, or "" to exempt the
+	// request. Defaults to `RateLimitByIP`.
+	Key func(Request) string
+
+	// Scope is prefixed to keys, so handlers which share a store can have
+	// separate limits (e.g., per route).
+	Scope string
+
+	// Now is the clock which refills token buckets, advances sliding
+	// windows and expires the Store's state. Defaults to `time.Now`.
+	Now func() time.Time
+}
+
+// RateLimitByIP keys requests by the client's IP address (see
+// `Request.ClientIP`).
+func RateLimitByIP(r Request) string { return "ip:" + r.ClientIP() }
+
+// RateLimitByHeader returns a key function which keys requests by the value of
+// a header, e.g., `X-API-Key`. Requests without the header are keyed by IP
+// address instead. The value is hashed, so credentials don't end up in the
+// request log or the store.
+func RateLimitByHeader(name string) func(Request) string {
+	return func(r Request) string {
+		if value := r.Headers.Get(name); value != "" {
+			sum := sha256.Sum256([]byte(value))
+			return "header:" + hex.EncodeToString(sum[:16])
+		}
+		return RateLimitByIP(r)
+	}
+}
+
+// RateLimitByPrincipal keys requests by the authenticated principal (see
+// `Authenticate`), so each user or API client has its own limit wherever it
+// connects from. Unauthenticated requests are keyed by IP address instead.
+func RateLimitByPrincipal(r Request) string {
+	if r.principal == nil {
+		return RateLimitByIP(r)
+	}
+	return "principal:" + r.principal.Scheme + ":" + r.principal.Subject
+}
+
+// RateLimitByRoute keys requests by the method and path template of the route
+// which matched them (e.g., `GET /users/{id}`), so all clients share each
+// route's limit. This protects expensive routes as a whole; requests which
+// weren't routed by a Router share a single key.
+func RateLimitByRoute(r Request) string {
+	return "route:" + r.Method + " " + r.route
+}
+
+// rateLimitLog records a rate limiting decision in the request log.
+type rateLimitLog struct {
+	Context    string  `json:"context"`
+	Key        string  `json:"key"`
+	Limit      int     `json:"limit"`
+	Remaining  int     `json:"remaining"`
+	RetryAfter float64 `json:"retryAfter,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// RateLimit returns middleware which limits the rate of requests per key.
+// Responses carry `RateLimit-Limit`, `RateLimit-Remaining`,
+// `RateLimit-Reset` and `RateLimit-Policy` headers; requests over the limit
+// get 429 Too Many Requests with a `Retry-After` header and are logged with
+// their key and remaining budget. If the store fails, requests are allowed
+// and the error is logged.
+//
+// RateLimit panics if the Algorithm is missing or its parameters aren't
+// positive.
+func RateLimit(options RateLimitOptions) Middleware {
+	if options.Algorithm == nil {
+		panic("httpeasy: RateLimit requires an Algorithm")
+	}
+	if err := options.Algorithm.validate(); err != nil {
+		panic("httpeasy: RateLimit: " + err.Error())
+	}
+	if options.Store == nil {
+		options.Store = &MemoryRateLimitStore{}
+	}
+	if options.Key == nil {
+		options.Key = RateLimitByIP
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+	algorithm := options.Algorithm
+
+	return func(next Handler) Handler {
+		return func(r Request) Response {
+			key := options.Key(r)
+			if key == "" {
+				return next(r)
+			}
+			key = options.Scope + key
+
+			var decision rateDecision
+			now := options.Now()
+			err := options.Store.Update(
+				key,
+				now,
+				algorithm.ttl(),
+				func(state []byte) []byte {
+					state, decision = algorithm.take(state, now)
+					return state
+				},
+			)
+			if err != nil {
+				r.log(rateLimitLog{
+					Context: "Rate limiter failed; allowing request",
+					Key:     key,
+					Limit:   algorithm.limit(),
+					Error:   err.Error(),
+				})
+				return next(r)
+			}
+
+			headers := http.Header{
+				"Ratelimit-Limit": []string{
+					strconv.Itoa(algorithm.limit()),
+				},
+				"Ratelimit-Remaining": []string{
+					strconv.Itoa(decision.remaining),
+				},
+				"Ratelimit-Reset":  []string{seconds(decision.reset)},
+				"Ratelimit-Policy": []string{algorithm.policy()},
+			}
+			if !decision.allowed {
+				headers.Set("Retry-After", seconds(decision.retryAfter))
+				return TooManyRequests(nil, rateLimitLog{
+					Context:    "Rate limit exceeded",
+					Key:        key,
+					Limit:      algorithm.limit(),
+					Remaining:  decision.remaining,
+					RetryAfter: decision.retryAfter.Seconds(),
+				}).WithHeaders(headers)
+			}
+			return next(r).WithHeaders(headers)
+		}
+	}
+}
+
+// seconds formats a duration as a whole number of seconds, rounding up so
+// clients don't retry too early.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// ClientIP returns the IP address of the client (or the last proxy) from
+// RemoteAddr.
+func (r Request) ClientIP() string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
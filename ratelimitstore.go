@@ -0,0 +1,67 @@
+package httpeasy
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// rateLimitShards is the number of shards in a MemoryRateLimitStore. Keys are
+// spread across shards so concurrent requests for different keys rarely
+// contend for the same lock.
+const rateLimitShards = 64
+
+// MemoryRateLimitStore is a RateLimitStore which keeps state in memory,
+// sharded by key. State isn't shared between processes, so each instance of
+// a horizontally scaled service enforces its own limits. The zero value is
+// ready to use.
+type MemoryRateLimitStore struct {
+	once   sync.Once
+	seed   maphash.Seed
+	shards [rateLimitShards]rateLimitShard
+}
+
+type rateLimitShard struct {
+	lock    sync.Mutex
+	entries map[string]rateLimitEntry
+	updates int
+}
+
+type rateLimitEntry struct {
+	state   []byte
+	expires time.Time
+}
+
+// Update implements RateLimitStore.
+func (s *MemoryRateLimitStore) Update(
+	key string,
+	now time.Time,
+	ttl time.Duration,
+	f func(state []byte) []byte,
+) error {
+	s.once.Do(func() { s.seed = maphash.MakeSeed() })
+	shard := &s.shards[maphash.String(s.seed, key)%rateLimitShards]
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if shard.entries == nil {
+		shard.entries = map[string]rateLimitEntry{}
+	}
+
+	entry, ok := shard.entries[key]
+	if ok && !now.Before(entry.expires) {
+		entry.state = nil
+	}
+	shard.entries[key] = rateLimitEntry{f(entry.state), now.Add(ttl)}
+
+	// Periodically sweep expired entries so idle keys don't accumulate
+	// forever.
+	if shard.updates++; shard.updates%1000 == 0 {
+		for key, entry := range shard.entries {
+			if !now.Before(entry.expires) {
+				delete(shard.entries, key)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package httpeasy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CookieMode determines how a CookieCodec protects cookie values.
+type CookieMode int
+
+const (
+	// Signed cookies are authenticated with HMAC-SHA256. Clients can read
+	// them but can't modify or forge them.
+	Signed CookieMode = iota
+
+	// Encrypted cookies are encrypted and authenticated with AES-GCM, so
+	// clients can neither read nor modify them.
+	Encrypted
+)
+
+var (
+	// ErrInvalidCookie is returned when a cookie value was tampered with,
+	// was encoded for a different cookie name, or wasn't produced by any key
+	// in the key ring.
+	ErrInvalidCookie = errors.New("httpeasy: invalid secure cookie")
+
+	// ErrCookieExpired is returned when a cookie value is older than the
+	// codec's MaxAge allowed when it was encoded.
+	ErrCookieExpired = errors.New("httpeasy: secure cookie expired")
+
+	// ErrNoCookieCodec is returned by `Request.SecureCookie` when the
+	// `SecureCookies` middleware hasn't been applied.
+	ErrNoCookieCodec = errors.New(
+		"httpeasy: secure cookies require the SecureCookies middleware",
+	)
+)
+
+// CookieCodec encodes values into signed or encrypted cookie values. Values
+// are serialized as JSON along with their expiry time, and are bound to the
+// cookie's name so a value can't be replayed under a different name:
+//
+//     codec := &CookieCodec{
+//         Mode:   Encrypted,
+//         Keys:   [][]byte{currentKey, previousKey},
+//         MaxAge: 7 * 24 * time.Hour,
+//     }
+//
+// See `SecureCookies` to use a codec with `Request.SecureCookie` and
+// `Response.WithSecureCookie`.
+type CookieCodec struct {
+	// Mode determines whether values are signed or encrypted.
+	Mode CookieMode
+
+	// Keys is the key ring. New values are encoded with the first key; values
+	// encoded with any of the keys are accepted, so keys can be rotated by
+	// prepending a new key and dropping the oldest once its cookies have
+	// expired. Signing keys should be at least 32 random bytes; encryption
+	// keys must be 16, 24 or 32 random bytes (AES-128, -192 or -256).
+	Keys [][]byte
+
+	// MaxAge is how long encoded values remain valid. Defaults to 30 days.
+	MaxAge time.Duration
+
+	// Now is the clock by which encoded values' expiry (see MaxAge) is set
+	// and checked when they're decoded. Defaults to `time.Now`.
+	Now func() time.Time
+}
+
+func (c *CookieCodec) maxAge() time.Duration {
+	if c.MaxAge <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return c.MaxAge
+}
+
+func (c *CookieCodec) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+// Encode serializes `v` as JSON and signs or encrypts it for the cookie named
+// `name`.
+func (c *CookieCodec) Encode(name string, v interface{}) (string, error) {
+	if len(c.Keys) < 1 {
+		return "", errors.New("httpeasy: cookie codec has no keys")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("marshaling cookie `%s`: %w", name, err)
+	}
+
+	// The payload is the expiry time (big-endian unix seconds) followed by
+	// the JSON.
+	payload := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(
+		payload,
+		uint64(c.now().Add(c.maxAge()).Unix()),
+	)
+	payload = append(payload, data...)
+
+	switch c.Mode {
+	case Signed:
+		encoded := base64.RawURLEncoding.EncodeToString(payload)
+		return encoded + "." + base64.RawURLEncoding.EncodeToString(
+			sign(c.Keys[0], name, encoded),
+		), nil
+	case Encrypted:
+		aead, err := newGCM(c.Keys[0])
+		if err != nil {
+			return "", err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return "", fmt.Errorf("generating nonce: %w", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(
+			aead.Seal(nonce, nonce, payload, []byte(name)),
+		), nil
+	default:
+		return "", fmt.Errorf("httpeasy: unknown cookie mode `%d`", c.Mode)
+	}
+}
+
+// Decode verifies or decrypts a value produced by `Encode` for the cookie
+// named `name` and unmarshals it into `v`. It returns ErrInvalidCookie if the
+// value can't be authenticated and ErrCookieExpired if it has expired.
+func (c *CookieCodec) Decode(name, value string, v interface{}) error {
+	payload, err := c.open(name, value)
+	if err != nil {
+		return err
+	}
+	if len(payload) < 8 {
+		return ErrInvalidCookie
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if !c.now().Before(expires) {
+		return ErrCookieExpired
+	}
+	if err := json.Unmarshal(payload[8:], v); err != nil {
+		return fmt.Errorf("unmarshaling cookie `%s`: %w", name, err)
+	}
+	return nil
+}
+
+// open authenticates `value` against each key in the ring and returns its
+// payload.
+func (c *CookieCodec) open(name, value string) ([]byte, error) {
+	switch c.Mode {
+	case Signed:
+		i := strings.LastIndexByte(value, '.')
+		if i < 0 {
+			return nil, ErrInvalidCookie
+		}
+		encoded := value[:i]
+		mac, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+		if err != nil {
+			return nil, ErrInvalidCookie
+		}
+		for _, key := range c.Keys {
+			if hmac.Equal(mac, sign(key, name, encoded)) {
+				payload, err := base64.RawURLEncoding.DecodeString(encoded)
+				if err != nil {
+					return nil, ErrInvalidCookie
+				}
+				return payload, nil
+			}
+		}
+		return nil, ErrInvalidCookie
+	case Encrypted:
+		sealed, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, ErrInvalidCookie
+		}
+		for _, key := range c.Keys {
+			aead, err := newGCM(key)
+			if err != nil {
+				return nil, err
+			}
+			if len(sealed) < aead.NonceSize() {
+				return nil, ErrInvalidCookie
+			}
+			nonce, ciphertext := sealed[:aead.NonceSize()],
+				sealed[aead.NonceSize():]
+			payload, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+			if err == nil {
+				return payload, nil
+			}
+		}
+		return nil, ErrInvalidCookie
+	default:
+		return nil, fmt.Errorf("httpeasy: unknown cookie mode `%d`", c.Mode)
+	}
+}
+
+// sign computes the HMAC-SHA256 of the cookie name and encoded payload.
+func sign(key []byte, name, encoded string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"httpeasy: invalid cookie encryption key: %w",
+			err,
+		)
+	}
+	return cipher.NewGCM(block)
+}
+
+// secureCookie is a cookie whose value will be encoded by the `SecureCookies`
+// middleware.
+type secureCookie struct {
+	cookie *http.Cookie
+	value  interface{}
+}
+
+// SecureCookies returns middleware which makes `codec` available to
+// `Request.SecureCookie` and encodes the cookies added with
+// `Response.WithSecureCookie`.
+func SecureCookies(codec *CookieCodec) Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) Response {
+			r.codec = codec
+			rsp := next(r)
+			pending := rsp.secureCookies
+			rsp.secureCookies = nil
+			for _, sc := range pending {
+				value, err := codec.Encode(sc.cookie.Name, sc.value)
+				if err != nil {
+					return HandleError("Error encoding secure cookie", err).
+						WithLogging(rsp.Logging...)
+				}
+				cookie := *sc.cookie
+				cookie.Value = value
+				if cookie.MaxAge == 0 && cookie.Expires.IsZero() {
+					cookie.MaxAge = int(codec.maxAge() / time.Second)
+				}
+				rsp.Cookies = append(rsp.Cookies, &cookie)
+			}
+			return rsp
+		}
+	}
+}
+
+// SecureCookie decodes the named cookie into `v` using the codec provided by
+// the `SecureCookies` middleware. Cookies which were tampered with or have
+// expired are treated as missing (i.e., `http.ErrNoCookie` is returned), and
+// the reason is recorded in the request log.
+func (r Request) SecureCookie(name string, v interface{}) error {
+	if r.codec == nil {
+		return ErrNoCookieCodec
+	}
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return err
+	}
+	if err := r.codec.Decode(name, cookie.Value, v); err != nil {
+		r.log(struct {
+			Context string `json:"context"`
+			Cookie  string `json:"cookie"`
+			Error   string `json:"error"`
+		}{
+			Context: "Rejected secure cookie",
+			Cookie:  name,
+			Error:   err.Error(),
+		})
+		return http.ErrNoCookie
+	}
+	return nil
+}
+
+// WithSecureCookie returns a copy of the response with a cookie whose value is
+// `v`, signed or encrypted by the `SecureCookies` middleware. The cookie's
+// other attributes (Path, Secure, HttpOnly, SameSite, etc) are taken from
+// `cookie`; if it sets neither MaxAge nor Expires, MaxAge defaults to the
+// codec's MaxAge:
+//
+//     rsp.WithSecureCookie(
+//         &http.Cookie{Name: "prefs", Path: "/", Secure: true, HttpOnly: true},
+//         prefs,
+//     )
+//
+func (r Response) WithSecureCookie(
+	cookie *http.Cookie,
+	v interface{},
+) Response {
+	r.secureCookies = append(
+		r.secureCookies[:len(r.secureCookies):len(r.secureCookies)],
+		secureCookie{cookie, v},
+	)
+	return r
+}
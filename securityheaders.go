@@ -0,0 +1,160 @@
+package httpeasy
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrNoCSPNonce is returned by the `cspNonce` template function when the
+// `SecurityHeaders` middleware hasn't been applied or has no CSP.
+var ErrNoCSPNonce = errors.New(
+	"httpeasy: CSP nonces require the SecurityHeaders middleware with a CSP",
+)
+
+// SecurityHeadersOptions configures the `SecurityHeaders` middleware. The
+// zero value sets conservative defaults and no Content-Security-Policy.
+type SecurityHeadersOptions struct {
+	// HSTSMaxAge is how long browsers should only connect over HTTPS
+	// (`Strict-Transport-Security`). Defaults to 2 years.
+	HSTSMaxAge time.Duration
+
+	// HSTSIncludeSubdomains and HSTSPreload add the `includeSubDomains`
+	// and `preload` HSTS directives.
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	// DisableHSTS omits the `Strict-Transport-Security` header, e.g., for
+	// sites which must remain reachable over plain HTTP.
+	DisableHSTS bool
+
+	// ReferrerPolicy is the `Referrer-Policy` header. Defaults to
+	// `strict-origin-when-cross-origin`.
+	ReferrerPolicy string
+
+	// PermissionsPolicy is the `Permissions-Policy` header (e.g.,
+	// `camera=(), geolocation=(self)`). Omitted if empty.
+	PermissionsPolicy string
+
+	// FrameOptions is the `X-Frame-Options` header: `DENY` (the default) or
+	// `SAMEORIGIN`. Unless the CSP sets FrameAncestors, it's also expressed
+	// as the CSP's `frame-ancestors` directive, which supersedes
+	// X-Frame-Options in modern browsers.
+	FrameOptions string
+
+	// CSP is the Content-Security-Policy. If set, a nonce is generated for
+	// each request and substituted for CSPNonce sources; templates rendered
+	// with `Request.HTMLTemplate` can read it with `{{cspNonce}}`:
+	//
+	//     <script nonce="{{cspNonce}}">...</script>
+	//
+	CSP *CSP
+
+	// CSPReportOnly sends the policy as
+	// `Content-Security-Policy-Report-Only`, so violations are reported
+	// but not blocked. This is useful for trialling a policy.
+	CSPReportOnly bool
+}
+
+// SecurityHeaders returns middleware which adds security-related headers to
+// the handler's responses: `Strict-Transport-Security`,
+// `X-Content-Type-Options: nosniff`, `Referrer-Policy`, `X-Frame-Options`,
+// and, if configured, `Permissions-Policy` and `Content-Security-Policy`.
+// Headers the handler sets itself are left alone.
+func SecurityHeaders(options SecurityHeadersOptions) Middleware {
+	if options.HSTSMaxAge == 0 {
+		options.HSTSMaxAge = 2 * 365 * 24 * time.Hour
+	}
+	if options.ReferrerPolicy == "" {
+		options.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+	if options.FrameOptions == "" {
+		options.FrameOptions = "DENY"
+	}
+
+	headers := http.Header{}
+	if !options.DisableHSTS {
+		hsts := "max-age=" + strconv.FormatInt(
+			int64(options.HSTSMaxAge/time.Second),
+			10,
+		)
+		if options.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if options.HSTSPreload {
+			hsts += "; preload"
+		}
+		headers.Set("Strict-Transport-Security", hsts)
+	}
+	headers.Set("X-Content-Type-Options", "nosniff")
+	headers.Set("Referrer-Policy", options.ReferrerPolicy)
+	headers.Set("X-Frame-Options", options.FrameOptions)
+	if options.PermissionsPolicy != "" {
+		headers.Set("Permissions-Policy", options.PermissionsPolicy)
+	}
+
+	var csp CSP
+	cspHeader := "Content-Security-Policy"
+	if options.CSP != nil {
+		csp = *options.CSP
+		if csp.FrameAncestors == nil {
+			switch options.FrameOptions {
+			case "DENY":
+				csp.FrameAncestors = []string{CSPNone}
+			case "SAMEORIGIN":
+				csp.FrameAncestors = []string{CSPSelf}
+			}
+		}
+		if options.CSPReportOnly {
+			cspHeader = "Content-Security-Policy-Report-Only"
+		}
+	}
+
+	return func(next Handler) Handler {
+		return func(r Request) Response {
+			if options.CSP != nil {
+				nonce, err := newCSPNonce()
+				if err != nil {
+					return HandleError("Error generating CSP nonce", err)
+				}
+				r.cspNonce = nonce
+			}
+			rsp := next(r)
+
+			merged := rsp.Headers.Clone()
+			if merged == nil {
+				merged = http.Header{}
+			}
+			for key, values := range headers {
+				if _, ok := merged[key]; !ok {
+					merged[key] = values
+				}
+			}
+			if options.CSP != nil && merged.Get(cspHeader) == "" {
+				merged.Set(cspHeader, csp.String(r.cspNonce))
+			}
+			rsp.Headers = merged
+			return rsp
+		}
+	}
+}
+
+// newCSPNonce returns a random nonce with 128 bits of entropy. It uses the
+// URL-safe alphabet, which CSP allows, so it needs no escaping in templates.
+func newCSPNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("generating CSP nonce: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(nonce), nil
+}
+
+// CSPNonce returns the request's Content-Security-Policy nonce, or "" if the
+// `SecurityHeaders` middleware hasn't been applied with a CSP. Templates
+// rendered with `Request.HTMLTemplate` can use `{{cspNonce}}` instead.
+func (r Request) CSPNonce() string { return r.cspNonce }
@@ -2,7 +2,9 @@ package httpeasy
 
 import (
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	html "html/template"
 	"io"
@@ -26,8 +28,76 @@ import (
 //
 //     return Ok(JSON(Person{Name: "Bob", Age: 58}))
 //
+// If the `io.WriterTo` has a `ContentType() string` method, its result is used
+// as the response's `Content-Type` header unless the handler already set one.
 type Serializer func() (io.WriterTo, error)
 
+// contentType returns the media type declared by a serializer's
+// `io.WriterTo`, if any.
+func contentType(writerTo io.WriterTo) string {
+	if typed, ok := writerTo.(interface{ ContentType() string }); ok {
+		return typed.ContentType()
+	}
+	return ""
+}
+
+// typedWriterTo declares the media type of an `io.WriterTo`.
+type typedWriterTo struct {
+	io.WriterTo
+	contentType string
+}
+
+func (twt typedWriterTo) ContentType() string { return twt.contentType }
+
+// sizedTypedWriterTo is a typedWriterTo which forwards its `io.WriterTo`'s
+// `Len() int` method, so middleware (e.g., `Compress`) can still see the size
+// of the output.
+type sizedTypedWriterTo struct {
+	typedWriterTo
+	len func() int
+}
+
+func (stwt sizedTypedWriterTo) Len() int { return stwt.len() }
+
+// withContentType returns a serializer whose output is declared to have the
+// provided media type.
+func withContentType(s Serializer, contentType string) Serializer {
+	return func() (io.WriterTo, error) {
+		writerTo, err := s()
+		if err != nil {
+			return nil, err
+		}
+		typed := typedWriterTo{writerTo, contentType}
+		if sized, ok := writerTo.(interface{ Len() int }); ok {
+			return sizedTypedWriterTo{typed, sized.Len}, nil
+		}
+		return typed, nil
+	}
+}
+
+// streamWriterTo is an `io.WriterTo` for serializers which write their output
+// as they go rather than buffering it up front.
+type streamWriterTo struct {
+	contentType string
+	write       func(w io.Writer) error
+}
+
+func (swt streamWriterTo) ContentType() string { return swt.contentType }
+
+func (swt streamWriterTo) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := swt.write(cw)
+	return cw.n, err
+}
+
+// stream wraps a write function in a serializer. The returned serializer
+// always succeeds; errors are returned when the output is written.
+func stream(contentType string, write func(w io.Writer) error) Serializer {
+	return func() (io.WriterTo, error) {
+		return streamWriterTo{contentType, write}, nil
+	}
+}
+
 // String wraps a string in a serializer.
 func String(s string) Serializer {
 	return func() (io.WriterTo, error) { return strings.NewReader(s), nil }
@@ -107,3 +177,98 @@ func TextTemplate(t *text.Template, v interface{}) Serializer {
 		return &buf, err
 	}
 }
+
+// XML wraps a value in an XML serializer with the `application/xml` media
+// type. The value is encoded with `encoding/xml` directly to the response, so
+// encoding errors are reported when the response is written rather than when
+// it's serialized.
+func XML(v interface{}) Serializer {
+	return stream("application/xml; charset=utf-8", func(w io.Writer) error {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		return xml.NewEncoder(w).Encode(v)
+	})
+}
+
+// CSV wraps a header and rows in a serializer with the `text/csv` media type.
+// If `header` is nil, no header row is written.
+func CSV(header []string, rows [][]string) Serializer {
+	return func() (io.WriterTo, error) {
+		i := 0
+		return CSVRows(header, func() ([]string, error) {
+			if i >= len(rows) {
+				return nil, io.EOF
+			}
+			i++
+			return rows[i-1], nil
+		})()
+	}
+}
+
+// CSVRows is like CSV, except the rows come from `next`, which is called
+// until it returns `io.EOF`. Each row is written as soon as it's returned, so
+// this is suitable for large exports (e.g., iterating over a database cursor).
+// Any other error from `next` aborts the response. Since the rows are
+// consumed, the returned serializer can only be written once.
+func CSVRows(header []string, next func() ([]string, error)) Serializer {
+	return stream("text/csv; charset=utf-8", func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		if header != nil {
+			if err := cw.Write(header); err != nil {
+				return err
+			}
+		}
+		for {
+			row, err := next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				cw.Flush()
+				return err
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+}
+
+// NDJSON serializes the values returned by `next` as newline-delimited JSON
+// with the `application/x-ndjson` media type. `next` is called until it
+// returns `io.EOF` and each value is written as soon as it's returned. Any
+// other error from `next` aborts the response. Since the values are consumed,
+// the returned serializer can only be written once.
+func NDJSON(next func() (interface{}, error)) Serializer {
+	return stream("application/x-ndjson", func(w io.Writer) error {
+		// `json.Encoder` terminates each value with a newline.
+		encoder := json.NewEncoder(w)
+		for {
+			v, err := next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := encoder.Encode(v); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// SerializerError is passed to the router's `ErrorRenderer` when a response's
+// serializer fails.
+type SerializerError struct {
+	Err error
+}
+
+func (err *SerializerError) Error() string {
+	return "serializing response data: " + err.Err.Error()
+}
+
+func (err *SerializerError) Unwrap() error { return err.Err }
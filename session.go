@@ -0,0 +1,304 @@
+package httpeasy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Session is the server-side state associated with a client via a cookie. Get
+// the current request's session with `Request.Session`; changes are saved by
+// the `Sessions` middleware once the handler returns. A Session is safe for
+// concurrent use.
+type Session struct {
+	lock      sync.Mutex
+	record    sessionRecord
+	modified  bool
+	renew     bool
+	destroyed bool
+}
+
+// sessionRecord is the serialized form of a Session.
+type sessionRecord struct {
+	Values   map[string]json.RawMessage `json:"values,omitempty"`
+	Flashes  []string                   `json:"flashes,omitempty"`
+	Created  time.Time                  `json:"created"`
+	LastSeen time.Time                  `json:"lastSeen"`
+}
+
+// Get unmarshals the value stored under `key` into `v`, which must be a
+// pointer. It returns false if there is no such value or it can't be
+// unmarshaled into `v`.
+func (s *Session) Get(key string, v interface{}) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, ok := s.record.Values[key]
+	return ok && json.Unmarshal(data, v) == nil
+}
+
+// Set stores `v` (which must be JSON-serializable) under `key`.
+func (s *Session) Set(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.record.Values == nil {
+		s.record.Values = map[string]json.RawMessage{}
+	}
+	s.record.Values[key] = data
+	s.modified = true
+	return nil
+}
+
+// Delete removes the value stored under `key`.
+func (s *Session) Delete(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.record.Values[key]; ok {
+		delete(s.record.Values, key)
+		s.modified = true
+	}
+}
+
+// Flash adds a message to be shown on a subsequent request, e.g., after a
+// redirect. See `Session.Flashes`.
+func (s *Session) Flash(message string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.record.Flashes = append(s.record.Flashes, message)
+	s.modified = true
+}
+
+// Flashes returns and removes the session's flash messages.
+func (s *Session) Flashes() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	flashes := s.record.Flashes
+	if len(flashes) > 0 {
+		s.record.Flashes = nil
+		s.modified = true
+	}
+	return flashes
+}
+
+// RenewID moves the session to a new ID (and therefore a new cookie value),
+// keeping its values. Call it whenever the user's privilege level changes,
+// e.g., on login, to prevent session fixation attacks.
+func (s *Session) RenewID() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.renew = true
+	s.modified = true
+}
+
+// Destroy deletes the session from the store and expires its cookie, e.g., on
+// logout.
+func (s *Session) Destroy() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.destroyed = true
+	s.record.Values = nil
+	s.record.Flashes = nil
+}
+
+// Session returns the request's session, or nil if the `Sessions` middleware
+// hasn't been applied.
+func (r Request) Session() *Session { return r.session }
+
+// SessionOptions configures the `Sessions` middleware. Only Store is
+// required.
+type SessionOptions struct {
+	// Store persists sessions. See `MemoryStore`, `FileStore` and
+	// `CookieStore`.
+	Store SessionStore
+
+	// CookieName is the name of the session cookie. Defaults to `session`.
+	CookieName string
+
+	// Path and Domain scope the session cookie. Path defaults to `/`.
+	Path   string
+	Domain string
+
+	// Insecure omits the cookie's `Secure` attribute so the session works
+	// over plain HTTP, e.g., during local development.
+	Insecure bool
+
+	// SameSite is the cookie's `SameSite` attribute. Defaults to
+	// `http.SameSiteLaxMode`.
+	SameSite http.SameSite
+
+	// IdleTimeout is how long a session lasts without being used. Defaults
+	// to 24 hours. To avoid saving the session on every request, its
+	// last-used time is only updated once a tenth of IdleTimeout has passed,
+	// so idle sessions may expire up to 10% early.
+	IdleTimeout time.Duration
+
+	// AbsoluteTimeout is how long a session lasts from its creation,
+	// regardless of use. Defaults to 7 days.
+	AbsoluteTimeout time.Duration
+
+	// Now is the clock by which sessions' idle and absolute timeouts are
+	// measured, including by the Store. Defaults to `time.Now`.
+	Now func() time.Time
+}
+
+// sessionLog is attached to the response logging by `Sessions` when a
+// session can't be restored.
+type sessionLog struct {
+	Context string `json:"context"`
+	Reason  string `json:"reason"`
+}
+
+// Sessions returns middleware which loads the session identified by the
+// request's session cookie (see `Request.Session`), or starts a new one, and
+// saves it once the handler returns. Sessions are only saved (and their
+// cookie only set) when they've changed or their idle timeout needs
+// extending, so new sessions aren't saved until something is stored in them.
+// Sessions which have exceeded the idle or absolute timeout are discarded.
+func Sessions(options SessionOptions) Middleware {
+	if options.CookieName == "" {
+		options.CookieName = "session"
+	}
+	if options.Path == "" {
+		options.Path = "/"
+	}
+	if options.SameSite == 0 {
+		options.SameSite = http.SameSiteLaxMode
+	}
+	if options.IdleTimeout == 0 {
+		options.IdleTimeout = 24 * time.Hour
+	}
+	if options.AbsoluteTimeout == 0 {
+		options.AbsoluteTimeout = 7 * 24 * time.Hour
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+
+	return func(next Handler) Handler {
+		return func(r Request) Response {
+			now := options.Now()
+			session, token, err := loadSession(r, options, now)
+			if err != nil {
+				return HandleError("Error loading session", err)
+			}
+			r.session = session
+			rsp := next(r)
+
+			cookie, err := saveSession(session, token, options, now)
+			if err != nil {
+				return HandleError("Error saving session", err).
+					WithLogging(rsp.Logging...)
+			}
+			if cookie != nil {
+				rsp = rsp.WithCookies(cookie)
+			}
+			return rsp
+		}
+	}
+}
+
+// loadSession restores the request's session, returning a new session if
+// there isn't a valid one. It also returns the session's current token, which
+// is empty for new sessions.
+func loadSession(r Request, options SessionOptions, now time.Time) (
+	*Session,
+	string,
+	error,
+) {
+	fresh := &Session{record: sessionRecord{Created: now, LastSeen: now}}
+	cookie, err := r.Cookie(options.CookieName)
+	if err != nil {
+		return fresh, "", nil
+	}
+
+	data, err := options.Store.Load(cookie.Value, now)
+	if errors.Is(err, ErrSessionNotFound) {
+		r.log(sessionLog{"Discarded session", "not found"})
+		return fresh, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var record sessionRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		r.log(sessionLog{"Discarded session", "malformed: " + err.Error()})
+		return fresh, "", nil
+	}
+	if now.Sub(record.LastSeen) > options.IdleTimeout ||
+		now.Sub(record.Created) > options.AbsoluteTimeout {
+		r.log(sessionLog{"Discarded session", "expired"})
+		if err := options.Store.Delete(cookie.Value); err != nil {
+			return nil, "", err
+		}
+		return fresh, "", nil
+	}
+	return &Session{record: record}, cookie.Value, nil
+}
+
+// saveSession persists the session and returns the cookie to set, if any.
+func saveSession(
+	s *Session,
+	token string,
+	options SessionOptions,
+	now time.Time,
+) (*http.Cookie, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	cookie := &http.Cookie{
+		Name:     options.CookieName,
+		Path:     options.Path,
+		Domain:   options.Domain,
+		Secure:   !options.Insecure,
+		HttpOnly: true,
+		SameSite: options.SameSite,
+	}
+
+	if s.destroyed {
+		if token == "" {
+			return nil, nil
+		}
+		if err := options.Store.Delete(token); err != nil {
+			return nil, err
+		}
+		cookie.MaxAge = -1
+		return cookie, nil
+	}
+
+	// Unmodified sessions needn't be stored, unless they're still in use
+	// and their idle timeout needs extending. New sessions aren't in use
+	// until something is stored in them.
+	touch := token != "" &&
+		now.Sub(s.record.LastSeen) >= options.IdleTimeout/10
+	if !s.modified && !touch {
+		return nil, nil
+	}
+	s.record.LastSeen = now
+	if s.renew && token != "" {
+		if err := options.Store.Delete(token); err != nil {
+			return nil, err
+		}
+		token = ""
+	}
+
+	data, err := json.Marshal(s.record)
+	if err != nil {
+		return nil, err
+	}
+	expires := s.record.Created.Add(options.AbsoluteTimeout)
+	if idle := now.Add(options.IdleTimeout); idle.Before(expires) {
+		expires = idle
+	}
+	cookie.Value, err = options.Store.Save(token, data, now, expires)
+	if err != nil {
+		return nil, err
+	}
+	cookie.Expires = expires
+	return cookie, nil
+}
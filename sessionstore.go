@@ -0,0 +1,267 @@
+package httpeasy
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound is returned by `SessionStore.Load` when there is no
+// (unexpired) session for a token.
+var ErrSessionNotFound = errors.New("httpeasy: session not found")
+
+// SessionStore persists sessions for the `Sessions` middleware. Sessions are
+// identified by an opaque token which is stored in the session cookie; it may
+// be a random ID referring to server-side storage (`MemoryStore`,
+// `FileStore`) or the session data itself (`CookieStore`).
+//
+// Stores are passed the current time by the `Sessions` middleware, so they
+// expire sessions by the same clock as the middleware (see
+// `SessionOptions.Now`).
+type SessionStore interface {
+	// Load returns the data of the session identified by `token`, or
+	// ErrSessionNotFound if there is no such session or it has expired as
+	// of `now`.
+	Load(token string, now time.Time) ([]byte, error)
+
+	// Save stores the session data until `expires` and returns the token
+	// which identifies it. `token` is the session's current token, or empty
+	// if the session is new (or its ID is being renewed). `now` is the
+	// current time, e.g., for discarding other expired sessions.
+	Save(token string, data []byte, now, expires time.Time) (string, error)
+
+	// Delete deletes the session identified by `token`. Deleting a session
+	// which doesn't exist isn't an error.
+	Delete(token string) error
+}
+
+// newSessionID returns a random, URL-safe session ID with 256 bits of entropy.
+func newSessionID() (string, error) {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("generating session ID: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// MemoryStore is a SessionStore which keeps sessions in memory. Sessions are
+// lost when the process exits and aren't shared between processes, so it's
+// best suited to development and single-instance deployments. The zero value
+// is ready to use.
+type MemoryStore struct {
+	lock     sync.Mutex
+	sessions map[string]memorySession
+	saves    int
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+// Load implements SessionStore.
+func (s *MemoryStore) Load(token string, now time.Time) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[token]
+	if !ok || !now.Before(session.expires) {
+		return nil, ErrSessionNotFound
+	}
+	return session.data, nil
+}
+
+// Save implements SessionStore.
+func (s *MemoryStore) Save(
+	token string,
+	data []byte,
+	now time.Time,
+	expires time.Time,
+) (string, error) {
+	if token == "" {
+		var err error
+		if token, err = newSessionID(); err != nil {
+			return "", err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sessions == nil {
+		s.sessions = map[string]memorySession{}
+	}
+	s.sessions[token] = memorySession{data, expires}
+
+	// Periodically sweep expired sessions so abandoned sessions don't
+	// accumulate forever.
+	if s.saves++; s.saves%1000 == 0 {
+		for token, session := range s.sessions {
+			if !now.Before(session.expires) {
+				delete(s.sessions, token)
+			}
+		}
+	}
+	return token, nil
+}
+
+// Delete implements SessionStore.
+func (s *MemoryStore) Delete(token string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, token)
+	return nil
+}
+
+// FileStore is a SessionStore which keeps each session in a file in Dir, so
+// sessions survive restarts and can be shared by processes on the same host.
+// Expired session files are deleted when they're next loaded.
+type FileStore struct {
+	// Dir is the directory which holds the session files. It must exist.
+	Dir string
+}
+
+type fileSession struct {
+	Data    []byte    `json:"data"`
+	Expires time.Time `json:"expires"`
+}
+
+// path returns the file for `token`, refusing tokens which aren't session IDs
+// so clients can't address arbitrary files.
+func (s FileStore) path(token string) (string, bool) {
+	if len(token) != 43 || strings.IndexFunc(token, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' || r == '-' || r == '_')
+	}) >= 0 {
+		return "", false
+	}
+	return filepath.Join(s.Dir, "session-"+token), true
+}
+
+// Load implements SessionStore.
+func (s FileStore) Load(token string, now time.Time) ([]byte, error) {
+	path, ok := s.path(token)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading session: %w", err)
+	}
+
+	var session fileSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("unmarshaling session: %w", err)
+	}
+	if !now.Before(session.Expires) {
+		if err := s.Delete(token); err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionNotFound
+	}
+	return session.Data, nil
+}
+
+// Save implements SessionStore. The file is replaced atomically, so
+// concurrent loads never see a partially written session.
+func (s FileStore) Save(
+	token string,
+	data []byte,
+	_ time.Time,
+	expires time.Time,
+) (string, error) {
+	if token == "" {
+		var err error
+		if token, err = newSessionID(); err != nil {
+			return "", err
+		}
+	}
+	path, ok := s.path(token)
+	if !ok {
+		return "", fmt.Errorf("invalid session token")
+	}
+
+	contents, err := json.Marshal(fileSession{data, expires})
+	if err != nil {
+		return "", fmt.Errorf("marshaling session: %w", err)
+	}
+	tmp, err := ioutil.TempFile(s.Dir, "tmp-session-")
+	if err != nil {
+		return "", fmt.Errorf("creating session file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("writing session file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("writing session file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("replacing session file: %w", err)
+	}
+	return token, nil
+}
+
+// Delete implements SessionStore.
+func (s FileStore) Delete(token string) error {
+	path, ok := s.path(token)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("deleting session file: %w", err)
+	}
+	return nil
+}
+
+// CookieStore is a SessionStore which keeps the session data in the session
+// cookie itself, signed or encrypted by Codec, so no server-side storage is
+// needed. Sessions can't be revoked before they expire (deleting one only
+// expires its cookie), and browsers limit cookies to about 4KB, so keep
+// sessions small. Codec's MaxAge should be at least the session's
+// AbsoluteTimeout.
+type CookieStore struct {
+	Codec *CookieCodec
+}
+
+// cookieStoreName binds cookie store values to their purpose; see
+// `CookieCodec`.
+const cookieStoreName = "httpeasy-session"
+
+// Load implements SessionStore.
+func (s CookieStore) Load(token string, _ time.Time) ([]byte, error) {
+	var data []byte
+	if err := s.Codec.Decode(cookieStoreName, token, &data); err != nil {
+		if errors.Is(err, ErrInvalidCookie) ||
+			errors.Is(err, ErrCookieExpired) {
+			return nil, fmt.Errorf("%w: %v", ErrSessionNotFound, err)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// Save implements SessionStore. The returned token is the encoded session, so
+// a new token is issued on every save.
+func (s CookieStore) Save(
+	_ string,
+	data []byte,
+	_ time.Time,
+	_ time.Time,
+) (string, error) {
+	return s.Codec.Encode(cookieStoreName, data)
+}
+
+// Delete implements SessionStore. It's a no-op; the `Sessions` middleware
+// expires the cookie.
+func (s CookieStore) Delete(string) error { return nil }
@@ -0,0 +1,182 @@
+package httpeasy
+
+import (
+	html "html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// StaticRoute serves the files in a file system (e.g., an `embed.FS` or
+// `os.DirFS()`) beneath a URL path prefix. Files are served via `File()`, so
+// range and conditional requests are supported.
+type StaticRoute struct {
+	// Path is the URL path prefix beneath which the files are served, e.g.,
+	// `/static/`. A request for `/static/css/site.css` serves `css/site.css`
+	// from FS.
+	Path string
+
+	// FS is the file system to serve.
+	FS fs.FS
+
+	// Index is the name of the file which is served for requests to a
+	// directory. Defaults to `index.html`.
+	Index string
+
+	// ListDirectories enables HTML directory listings for directories which
+	// don't contain an index file. Listings are disabled by default, in which
+	// case such requests get a 404 Not Found.
+	ListDirectories bool
+
+	// Fingerprinted reports whether a file's name contains a hash of its
+	// contents, in which case it is served with a `Cache-Control` header
+	// which allows clients to cache it forever. Defaults to
+	// `DefaultFingerprinted`.
+	Fingerprinted func(name string) bool
+
+	// CacheControl is the `Cache-Control` header for files which aren't
+	// fingerprinted. Defaults to `no-cache`, which makes clients revalidate
+	// via `Last-Modified` before reusing a cached copy.
+	CacheControl string
+}
+
+var fingerprintPattern = regexp.MustCompile(`[.-][0-9a-fA-F]{8,}\.[^/.]+$`)
+
+// DefaultFingerprinted reports whether the file name contains a hexadecimal
+// hash of at least 8 digits just before its extension, e.g.,
+// `app.3f9a2b1c.js` or `app-3f9a2b1c0d.css`.
+func DefaultFingerprinted(name string) bool {
+	return fingerprintPattern.MatchString(path.Base(name))
+}
+
+// Handler returns a handler which serves the route's files. It's mostly useful
+// for wrapping a static route in middleware or mounting it on another mux;
+// `Router.RegisterStatic()` is usually more convenient.
+func (route StaticRoute) Handler() Handler {
+	index := route.Index
+	if index == "" {
+		index = "index.html"
+	}
+	fingerprinted := route.Fingerprinted
+	if fingerprinted == nil {
+		fingerprinted = DefaultFingerprinted
+	}
+	cacheControl := route.CacheControl
+	if cacheControl == "" {
+		cacheControl = "no-cache"
+	}
+
+	return func(r Request) Response {
+		name := strings.TrimPrefix(r.URL.Path, route.Path)
+		name = strings.Trim(path.Clean("/"+name), "/")
+		if name == "" {
+			name = "."
+		}
+
+		info, err := fs.Stat(route.FS, name)
+		if err != nil {
+			return fileError(name, err)
+		}
+
+		if info.IsDir() {
+			// Redirect to the canonical directory path so relative links in
+			// the index file or listing resolve correctly.
+			if !strings.HasSuffix(r.URL.Path, "/") {
+				location := r.URL.Path + "/"
+				if r.URL.RawQuery != "" {
+					location += "?" + r.URL.RawQuery
+				}
+				return MovedPermanently(location)
+			}
+
+			indexName := path.Join(name, index)
+			indexInfo, err := fs.Stat(route.FS, indexName)
+			switch {
+			case err == nil && !indexInfo.IsDir():
+				name = indexName
+			case route.ListDirectories:
+				return listDirectory(route.FS, name)
+			default:
+				return NotFound(nil, struct {
+					Context string `json:"context"`
+					Name    string `json:"name"`
+				}{
+					Context: "Directory has no index file and listings " +
+						"are disabled",
+					Name: name,
+				})
+			}
+		}
+
+		rsp := File(r, route.FS, name)
+		switch rsp.Status {
+		case http.StatusOK,
+			http.StatusPartialContent,
+			http.StatusNotModified:
+			value := cacheControl
+			if fingerprinted(name) {
+				value = "public, max-age=31536000, immutable"
+			}
+			rsp = rsp.WithHeaders(http.Header{
+				"Cache-Control": []string{value},
+			})
+		}
+		return rsp
+	}
+}
+
+var directoryListingTemplate = html.Must(html.New("listing").Parse(
+	`<!DOCTYPE html>
+<html>
+<head><title>Index of {{.Name}}</title></head>
+<body>
+<h1>Index of {{.Name}}</h1>
+<ul>
+{{range .Entries}}<li><a href="{{.}}">{{.}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`,
+))
+
+func listDirectory(fsys fs.FS, name string) Response {
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		return fileError(name, err)
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+		if entry.IsDir() {
+			names[i] += "/"
+		}
+	}
+	return Ok(HTMLTemplate(directoryListingTemplate, struct {
+		Name    string
+		Entries []string
+	}{Name: "/" + strings.TrimPrefix(name, "."), Entries: names})).
+		WithHeaders(http.Header{
+			"Content-Type": []string{"text/html; charset=utf-8"},
+		})
+}
+
+// RegisterStatic registers `StaticRoute`s with the provided Router and LogFunc
+// and returns the same modified Router. Each route answers GET and HEAD
+// requests for every path beneath its prefix.
+func (r *Router) RegisterStatic(log LogFunc, routes ...StaticRoute) *Router {
+	for _, route := range routes {
+		handler := route.Handler().With(r.errorBodies(nil)...)
+		for _, method := range []string{"GET", "HEAD"} {
+			r.routes = append(r.routes, RouteInfo{
+				Method: method,
+				Path:   route.Path,
+			})
+		}
+		r.inner.PathPrefix(route.Path).
+			Methods("GET", "HEAD").
+			HandlerFunc(handler.serve(log, r))
+	}
+	return r
+}
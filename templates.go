@@ -0,0 +1,89 @@
+package httpeasy
+
+import (
+	"bytes"
+	"fmt"
+	html "html/template"
+	"io"
+)
+
+// TemplateFuncs returns the request-specific template functions provided by
+// this package, for use with `html/template.Template.Funcs` when parsing
+// templates which will be rendered with `Request.HTMLTemplate`:
+//
+//     var form = template.Must(
+//         template.New("form").Funcs(httpeasy.TemplateFuncs()).Parse(
+//             `<form method="POST">{{csrfField}}...</form>`,
+//         ),
+//     )
+//
+// The functions are:
+//
+// * `csrfField` renders a hidden form field holding the CSRF token. See
+//   `CSRF`.
+// * `csrfToken` returns the CSRF token, e.g., for a `<meta>` tag read by
+//   scripts which send it in a header.
+// * `cspNonce` returns the Content-Security-Policy nonce for inline scripts
+//   and styles. See `SecurityHeaders`.
+//
+// The functions returned here are placeholders which fail if the template is
+// executed directly; `Request.HTMLTemplate` replaces them with the request's
+// values.
+func TemplateFuncs() html.FuncMap {
+	funcs := html.FuncMap{}
+	for name := range (Request{}).templateFuncs() {
+		name := name
+		funcs[name] = func() (string, error) {
+			return "", fmt.Errorf(
+				"httpeasy: template function `%s` requires "+
+					"Request.HTMLTemplate",
+				name,
+			)
+		}
+	}
+	return funcs
+}
+
+// templateFuncs returns the request's implementations of `TemplateFuncs`.
+func (r Request) templateFuncs() html.FuncMap {
+	return html.FuncMap{
+		"csrfField": func() (html.HTML, error) {
+			if r.csrf == nil {
+				return "", ErrNoCSRF
+			}
+			return html.HTML(fmt.Sprintf(
+				`<input type="hidden" name="%s" value="%s">`,
+				html.HTMLEscapeString(r.csrf.field),
+				html.HTMLEscapeString(r.csrf.masked()),
+			)), nil
+		},
+		"csrfToken": func() (string, error) {
+			if r.csrf == nil {
+				return "", ErrNoCSRF
+			}
+			return r.csrf.masked(), nil
+		},
+		"cspNonce": func() (string, error) {
+			if r.cspNonce == "" {
+				return "", ErrNoCSPNonce
+			}
+			return r.cspNonce, nil
+		},
+	}
+}
+
+// HTMLTemplate is like the package-level `HTMLTemplate`, but binds the
+// functions from `TemplateFuncs` to the request. Each rendering executes a
+// clone of `t`, so `t` itself must never be executed (html/template can't
+// clone a template after it has executed).
+func (r Request) HTMLTemplate(t *html.Template, v interface{}) Serializer {
+	return func() (io.WriterTo, error) {
+		clone, err := t.Clone()
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		err = clone.Funcs(r.templateFuncs()).Execute(&buf, v)
+		return &buf, err
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/weberc2/httpeasy"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func basicHeader(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString(
+		[]byte(username+":"+password),
+	)
+}
+
+func TestAuthenticate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	htpasswd, err := ReadHtpasswd(strings.NewReader(
+		"# users\n\nalice:" + string(hash) + "\n",
+	))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}}
+	keys := StaticKeys(map[string]string{"t0ken": "svc"})
+	verifyToken := func(token string) (*Principal, error) {
+		// A buggy verifier which neither accepts nor rejects the token
+		if token == "n1l" {
+			return nil, nil
+		}
+		return keys(token)
+	}
+	whoami := func(r Request) Response {
+		p := r.Principal()
+		return Ok(String(p.Scheme + ":" + p.Subject))
+	}
+	authenticators := []Authenticator{
+		ClientCertAuth{},
+		BasicAuth{Realm: "admin", Verify: htpasswd.Verify},
+		BearerAuth{Realm: "api", Verify: verifyToken},
+		APIKeyAuth{
+			Header: "X-API-Key",
+			Query:  "api_key",
+			Verify: StaticKeys(map[string]string{"k3y": "ci"}),
+		},
+	}
+	router := NewRouter()
+	router.Register(
+		func(interface{}) {},
+		append(
+			Group(
+				[]Route{{Path: "/me", Method: "GET", Handler: whoami}},
+				Authenticate(authenticators...),
+			),
+			Route{
+				Path:    "/public",
+				Method:  "GET",
+				Handler: func(Request) Response { return Ok(String("hi")) },
+			},
+		)...,
+	)
+
+	challenges := []string{
+		`Basic realm="admin", charset="UTF-8"`,
+		`Bearer realm="api"`,
+		`APIKey header="X-API-Key", query="api_key"`,
+	}
+	testCases := []struct {
+		Name             string
+		Path             string
+		Header           http.Header
+		TLS              *tls.ConnectionState
+		WantedStatus     int
+		WantedBody       string
+		WantedChallenges []string
+	}{{
+		Name:         "public",
+		Path:         "/public",
+		WantedStatus: 200,
+		WantedBody:   "hi",
+	}, {
+		Name:             "no-credentials",
+		Path:             "/me",
+		WantedStatus:     401,
+		WantedChallenges: challenges,
+	}, {
+		Name: "basic",
+		Path: "/me",
+		Header: http.Header{
+			"Authorization": {basicHeader("alice", "hunter2")},
+		},
+		WantedStatus: 200,
+		WantedBody:   "basic:alice",
+	}, {
+		Name: "basic-wrong-password",
+		Path: "/me",
+		Header: http.Header{
+			"Authorization": {basicHeader("alice", "nope")},
+		},
+		WantedStatus:     401,
+		WantedChallenges: challenges,
+	}, {
+		Name: "basic-unknown-user",
+		Path: "/me",
+		Header: http.Header{
+			"Authorization": {basicHeader("bob", "hunter2")},
+		},
+		WantedStatus:     401,
+		WantedChallenges: challenges,
+	}, {
+		Name:         "bearer",
+		Path:         "/me",
+		Header:       http.Header{"Authorization": {"bearer t0ken"}},
+		WantedStatus: 200,
+		WantedBody:   "bearer:svc",
+	}, {
+		Name:         "bearer-invalid",
+		Path:         "/me",
+		Header:       http.Header{"Authorization": {"Bearer expired"}},
+		WantedStatus: 401,
+		WantedChallenges: []string{
+			challenges[0],
+			`Bearer realm="api", error="invalid_token"`,
+			challenges[2],
+		},
+	}, {
+		Name:         "bearer-nil-principal",
+		Path:         "/me",
+		Header:       http.Header{"Authorization": {"Bearer n1l"}},
+		WantedStatus: 401,
+		WantedChallenges: []string{
+			challenges[0],
+			`Bearer realm="api", error="invalid_token"`,
+			challenges[2],
+		},
+	}, {
+		Name:         "api-key-header",
+		Path:         "/me",
+		Header:       http.Header{"X-Api-Key": {"k3y"}},
+		WantedStatus: 200,
+		WantedBody:   "apikey:ci",
+	}, {
+		Name:         "api-key-query",
+		Path:         "/me?api_key=k3y",
+		WantedStatus: 200,
+		WantedBody:   "apikey:ci",
+	}, {
+		Name: "client-cert",
+		Path: "/me",
+		TLS: &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+			VerifiedChains:   [][]*x509.Certificate{{cert}},
+		},
+		WantedStatus: 200,
+		WantedBody:   "mtls:billing",
+	}, {
+		Name: "client-cert-unverified",
+		Path: "/me",
+		TLS: &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+		},
+		WantedStatus:     401,
+		WantedChallenges: challenges,
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", testCase.Path, nil)
+			for key, values := range testCase.Header {
+				req.Header[key] = values
+			}
+			req.TLS = testCase.TLS
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != testCase.WantedStatus {
+				t.Fatalf(
+					"Wanted status `%d`; found `%d`",
+					testCase.WantedStatus,
+					w.Code,
+				)
+			}
+			if testCase.WantedBody != "" &&
+				w.Body.String() != testCase.WantedBody {
+				t.Fatalf(
+					"Wanted body `%s`; found `%s`",
+					testCase.WantedBody,
+					w.Body.String(),
+				)
+			}
+			found := w.Header()["Www-Authenticate"]
+			if fmt.Sprint(found) != fmt.Sprint(testCase.WantedChallenges) {
+				t.Fatalf(
+					"Wanted challenges `%q`; found `%q`",
+					testCase.WantedChallenges,
+					found,
+				)
+			}
+		})
+	}
+}
+
+func TestAuthenticateLogsFailures(t *testing.T) {
+	var logs []string
+	handler := Handler(func(Request) Response {
+		return Ok(String("ok"))
+	}).With(Authenticate(BasicAuth{
+		Verify: BasicCredentials(map[string]string{"alice": "hunter2"}),
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", basicHeader("alice", "wrong"))
+	w := httptest.NewRecorder()
+	handler.HTTP(func(v interface{}) {
+		data, _ := json.Marshal(v)
+		logs = append(logs, string(data))
+	})(w, req)
+
+	if w.Code != 401 {
+		t.Fatalf("Wanted status `401`; found `%d`", w.Code)
+	}
+	log := strings.Join(logs, "\n")
+	if !strings.Contains(log, `"context":"Authentication failed"`) ||
+		!strings.Contains(log, "wrong username or password") {
+		t.Fatalf("Wanted the failure to be logged; found:\n%s", log)
+	}
+}
+
+func TestReadHtpasswdRejectsWeakHashes(t *testing.T) {
+	for _, data := range []string{
+		"alice:$apr1$salt$hash\n",
+		"alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n",
+		"no-separator\n",
+	} {
+		if _, err := ReadHtpasswd(strings.NewReader(data)); err == nil {
+			t.Fatalf("Wanted an error for `%s`", strings.TrimSpace(data))
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+func TestRouteRequires(t *testing.T) {
+	// Tokens are "subject:role,role"
+	tokens := func(token string) (*Principal, error) {
+		subject, roles, _ := strings.Cut(token, ":")
+		return &Principal{
+			Subject: subject,
+			Roles:   strings.Split(roles, ","),
+		}, nil
+	}
+	ok := func(Request) Response { return Ok(String("ok")) }
+	var logs []string
+	log := func(v interface{}) {
+		data, _ := json.Marshal(v)
+		logs = append(logs, string(data))
+	}
+	router := NewRouter()
+	router.Register(
+		log,
+		Group(
+			[]Route{{
+				Path:     "/invoices",
+				Method:   "POST",
+				Handler:  ok,
+				Requires: AnyOf("admin", "billing:write"),
+			}, {
+				Path:     "/users/{id}",
+				Method:   "GET",
+				Handler:  ok,
+				Requires: Or(Self("id"), AnyOf("admin")),
+			}, {
+				Path:     "/reports",
+				Method:   "GET",
+				Handler:  ok,
+				Requires: AllOf("billing:read", "reports:read"),
+			}, {
+				Path:    "/health",
+				Method:  "GET",
+				Handler: ok,
+			}},
+			Authenticate(BearerAuth{Verify: tokens}),
+		)...,
+	)
+	router.Register(
+		log,
+		Route{
+			Path:     "/anonymous",
+			Method:   "GET",
+			Handler:  ok,
+			Requires: Authenticated,
+		},
+	)
+
+	testCases := []struct {
+		Name         string
+		Method       string
+		Path         string
+		Token        string
+		WantedStatus int
+		WantedLog    string
+	}{
+		{"any-of", "POST", "/invoices", "u1:billing:write", 200, ""},
+		{"any-of-admin", "POST", "/invoices", "u1:admin", 200, ""},
+		{
+			"any-of-denied", "POST", "/invoices", "u1:billing:read", 403,
+			`"policy":"anyOf(admin, billing:write)","subject":"u1"`,
+		},
+		{"self", "GET", "/users/u1", "u1:", 200, ""},
+		{"self-admin", "GET", "/users/u2", "u1:admin", 200, ""},
+		{
+			"self-denied", "GET", "/users/u2", "u1:", 403,
+			`"policy":"or(self(id), anyOf(admin))"`,
+		},
+		{
+			"all-of", "GET", "/reports", "u1:reports:read,billing:read",
+			200, "",
+		},
+		{
+			"all-of-denied", "GET", "/reports", "u1:reports:read", 403,
+			`"policy":"allOf(billing:read, reports:read)"`,
+		},
+		{"no-policy", "GET", "/health", "u1:", 200, ""},
+		{"unauthenticated", "GET", "/users/u1", "", 401, ""},
+		{
+			"authenticated", "GET", "/anonymous", "", 401,
+			`"policy":"authenticated"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			logs = nil
+			req := httptest.NewRequest(testCase.Method, testCase.Path, nil)
+			if testCase.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+testCase.Token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != testCase.WantedStatus {
+				t.Fatalf(
+					"Wanted status `%d`; found `%d`",
+					testCase.WantedStatus,
+					w.Code,
+				)
+			}
+			found := strings.Join(logs, "\n")
+			if testCase.WantedLog != "" &&
+				!strings.Contains(found, testCase.WantedLog) {
+				t.Fatalf(
+					"Wanted `%s` in the log; found:\n%s",
+					testCase.WantedLog,
+					found,
+				)
+			}
+		})
+	}
+
+	wanted := []string{
+		"POST /invoices anyOf(admin, billing:write)",
+		"GET /users/{id} or(self(id), anyOf(admin))",
+		"GET /reports allOf(billing:read, reports:read)",
+		"GET /health ",
+		"GET /anonymous authenticated",
+	}
+	var found []string
+	for _, route := range router.Routes() {
+		found = append(
+			found,
+			route.Method+" "+route.Path+" "+route.Requires,
+		)
+	}
+	if fmt.Sprintf("%q", found) != fmt.Sprintf("%q", wanted) {
+		t.Fatalf("Wanted routes `%q`; found `%q`", wanted, found)
+	}
+}
+
+func TestJWTRolesAndScopes(t *testing.T) {
+	key := JWTKey{Key: []byte("0123456789abcdef0123456789abcdef")}
+	token, err := JWTSigner{Key: key}.Sign(map[string]interface{}{
+		"sub":   "u1",
+		"roles": "user",
+		"scope": "admin billing:read",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	verifier := &JWTVerifier{Keys: StaticJWTKeys{key}}
+	principal, err := verifier.Verify(token)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if wanted := []string{"user"}; fmt.Sprint(principal.Roles) !=
+		fmt.Sprint(wanted) {
+		t.Fatalf("Wanted roles `%v`; found `%v`", wanted, principal.Roles)
+	}
+	if wanted := []string{"admin", "billing:read"}; fmt.Sprint(
+		principal.Scopes,
+	) != fmt.Sprint(wanted) {
+		t.Fatalf("Wanted scopes `%v`; found `%v`", wanted, principal.Scopes)
+	}
+
+	// A scope the client asked for doesn't grant the role of the same name
+	ok := func(Request) Response { return Ok(String("ok")) }
+	router := Register(
+		func(interface{}) {},
+		Group(
+			[]Route{{
+				Path:     "/role",
+				Method:   "GET",
+				Handler:  ok,
+				Requires: AnyOf("admin"),
+			}, {
+				Path:     "/any-scope",
+				Method:   "GET",
+				Handler:  ok,
+				Requires: AnyScope("billing:write", "billing:read"),
+			}, {
+				Path:     "/all-scopes",
+				Method:   "GET",
+				Handler:  ok,
+				Requires: AllScopes("billing:read", "billing:write"),
+			}},
+			Authenticate(BearerAuth{Verify: verifier.Verify}),
+		)...,
+	)
+	for path, wanted := range map[string]int{
+		"/role":       403,
+		"/any-scope":  200,
+		"/all-scopes": 403,
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != wanted {
+			t.Fatalf("%s: wanted status `%d`; found `%d`", path, wanted, w.Code)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/weberc2/httpeasy"
+	"github.com/weberc2/httpeasy/testsupport"
+)
+
+func TestCacheControl(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		CacheControl CacheControl
+		Wanted       string
+	}{{
+		Name:         "empty",
+		CacheControl: CacheControl{},
+		Wanted:       "",
+	}, {
+		Name: "public",
+		CacheControl: CacheControl{
+			Public:               true,
+			MaxAge:               time.Minute,
+			SMaxAge:              time.Hour,
+			StaleWhileRevalidate: 90 * time.Second,
+		},
+		Wanted: "public, max-age=60, s-maxage=3600, " +
+			"stale-while-revalidate=90",
+	}, {
+		Name:         "private",
+		CacheControl: CacheControl{Private: true, NoCache: true},
+		Wanted:       "private, no-cache",
+	}, {
+		Name:         "no-store",
+		CacheControl: CacheControl{NoStore: true},
+		Wanted:       "no-store",
+	}, {
+		Name: "immutable",
+		CacheControl: CacheControl{
+			MaxAge:         365 * 24 * time.Hour,
+			MustRevalidate: true,
+			Immutable:      true,
+		},
+		Wanted: "max-age=31536000, must-revalidate, immutable",
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			rsp := Ok(nil).WithCache(testCase.CacheControl)
+			if found := rsp.Headers.Get("Cache-Control"); found !=
+				testCase.Wanted {
+				t.Fatalf(
+					"Wanted `Cache-Control: %s`; found `%s`",
+					testCase.Wanted,
+					found,
+				)
+			}
+		})
+	}
+}
+
+// cacheTest drives a handler wrapped in the `Cache` middleware with a fake
+// clock.
+type cacheTest struct {
+	*testsupport.FakeClock
+	t       *testing.T
+	calls   int32
+	handler Handler
+}
+
+func newCacheTest(
+	t *testing.T,
+	options CacheOptions,
+	handler func(r Request, calls int32) Response,
+) *cacheTest {
+	test := &cacheTest{
+		FakeClock: testsupport.NewFakeClock(time.Unix(1700000000, 0)),
+		t:         t,
+	}
+	options.Now = test.Now
+	test.handler = Handler(func(r Request) Response {
+		return handler(r, atomic.AddInt32(&test.calls, 1))
+	}).With(Cache(options))
+	return test
+}
+
+func (test *cacheTest) get(
+	path string,
+	headers http.Header,
+) (Response, string) {
+	u, err := url.Parse(path)
+	if err != nil {
+		test.t.Fatal("Unexpected error:", err)
+	}
+	if headers == nil {
+		headers = http.Header{}
+	}
+	return test.do(Request{Method: "GET", URL: u, Headers: headers})
+}
+
+func (test *cacheTest) do(r Request) (Response, string) {
+	rsp := test.handler(r)
+	body, err := testsupport.ReadAll(rsp.Data)
+	if err != nil {
+		test.t.Fatal("Unexpected error:", err)
+	}
+	return rsp, string(body)
+}
+
+func (test *cacheTest) wantCalls(wanted int32) {
+	test.t.Helper()
+	if calls := atomic.LoadInt32(&test.calls); calls != wanted {
+		test.t.Fatalf("Wanted `%d` handler calls; found `%d`", wanted, calls)
+	}
+}
+
+// waitCalls waits for background refreshes to bring the handler calls up to
+// `wanted`.
+func (test *cacheTest) waitCalls(wanted int32) {
+	test.t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&test.calls) < wanted && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	test.wantCalls(wanted)
+}
+
+func cacheable(cc CacheControl) func(Request, int32) Response {
+	return func(r Request, calls int32) Response {
+		return Ok(Stringf("response %d", calls)).WithCache(cc)
+	}
+}
+
+func TestCacheHitsAndExpiry(t *testing.T) {
+	test := newCacheTest(t, CacheOptions{}, cacheable(CacheControl{
+		MaxAge:               time.Minute,
+		StaleWhileRevalidate: time.Minute,
+	}))
+
+	if _, body := test.get("/catalog", nil); body != "response 1" {
+		t.Fatalf("Wanted `response 1`; found `%s`", body)
+	}
+	test.Advance(30 * time.Second)
+	rsp, body := test.get("/catalog", nil)
+	if body != "response 1" {
+		t.Fatalf("Wanted cached `response 1`; found `%s`", body)
+	}
+	if age := rsp.Headers.Get("Age"); age != "30" {
+		t.Fatalf("Wanted `Age: 30`; found `%s`", age)
+	}
+	test.wantCalls(1)
+
+	// A different query string is a different resource
+	test.get("/catalog?page=2", nil)
+	test.wantCalls(2)
+
+	// So is the same path on a different host
+	u, _ := url.Parse("/catalog")
+	if _, body := test.do(Request{
+		Method:  "GET",
+		URL:     u,
+		Host:    "other.example",
+		Headers: http.Header{},
+	}); body != "response 3" {
+		t.Fatalf("Wanted `response 3`; found `%s`", body)
+	}
+	test.wantCalls(3)
+
+	// Requests can insist on a fresh response
+	test.get("/catalog", http.Header{"Cache-Control": []string{"no-cache"}})
+	test.wantCalls(4)
+
+	// Stale responses are served while a fresh one is fetched
+	test.Advance(2 * time.Minute)
+	test.get("/catalog?page=2", nil)
+	test.waitCalls(5)
+
+	// Once the stale-while-revalidate window passes, the entry is gone
+	test.Advance(time.Hour)
+	if _, body := test.get("/catalog", nil); body != "response 6" {
+		t.Fatalf("Wanted `response 6`; found `%s`", body)
+	}
+}
+
+func TestCacheFailedRefresh(t *testing.T) {
+	test := newCacheTest(
+		t,
+		CacheOptions{},
+		func(r Request, calls int32) Response {
+			if calls > 1 {
+				return InternalServerError()
+			}
+			return cacheable(CacheControl{
+				MaxAge:               time.Minute,
+				StaleWhileRevalidate: time.Hour,
+			})(r, calls)
+		},
+	)
+
+	// A refresh which yields an uncacheable response leaves the stale entry
+	// in place, and later requests try again
+	test.get("/", nil)
+	test.Advance(2 * time.Minute)
+	for wanted := int32(2); wanted < 5; wanted++ {
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt32(&test.calls) < wanted &&
+			time.Now().Before(deadline) {
+			if _, body := test.get("/", nil); body != "response 1" {
+				t.Fatalf("Wanted stale `response 1`; found `%s`", body)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		test.wantCalls(wanted)
+	}
+}
+
+func TestCacheUncacheable(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Headers http.Header
+		Handler func(Request, int32) Response
+	}{{
+		Name:    "no-cache-control",
+		Handler: func(Request, int32) Response { return Ok(String("x")) },
+	}, {
+		Name:    "private",
+		Handler: cacheable(CacheControl{Private: true, MaxAge: time.Hour}),
+	}, {
+		Name:    "no-store",
+		Handler: cacheable(CacheControl{NoStore: true, MaxAge: time.Hour}),
+	}, {
+		Name: "vary-star",
+		Handler: func(r Request, calls int32) Response {
+			return cacheable(CacheControl{MaxAge: time.Hour})(r, calls).
+				WithHeaders(http.Header{"Vary": []string{"*"}})
+		},
+	}, {
+		Name: "cookies",
+		Handler: func(r Request, calls int32) Response {
+			return cacheable(CacheControl{MaxAge: time.Hour})(r, calls).
+				WithCookies(&http.Cookie{Name: "session", Value: "x"})
+		},
+	}, {
+		Name: "secure-cookies",
+		Handler: func(r Request, calls int32) Response {
+			return cacheable(CacheControl{MaxAge: time.Hour})(r, calls).
+				WithSecureCookie(&http.Cookie{Name: "prefs"}, "dark")
+		},
+	}, {
+		Name:    "authorization",
+		Headers: http.Header{"Authorization": []string{"Bearer x"}},
+		Handler: cacheable(CacheControl{MaxAge: time.Hour}),
+	}, {
+		Name:    "server-error",
+		Handler: func(Request, int32) Response { return InternalServerError() },
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			test := newCacheTest(t, CacheOptions{}, testCase.Handler)
+			test.get("/", testCase.Headers)
+			test.get("/", testCase.Headers)
+			test.wantCalls(2)
+		})
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	test := newCacheTest(
+		t,
+		CacheOptions{},
+		func(r Request, calls int32) Response {
+			return Ok(Stringf(
+				"%s %d",
+				r.Headers.Get("Accept-Language"),
+				calls,
+			)).WithCache(CacheControl{MaxAge: time.Hour}).WithHeaders(
+				http.Header{"Vary": []string{"Accept-Language"}},
+			)
+		},
+	)
+
+	english := http.Header{"Accept-Language": []string{"en"}}
+	french := http.Header{"Accept-Language": []string{"fr"}}
+	for _, request := range []struct {
+		headers http.Header
+		wanted  string
+	}{
+		{english, "en 1"},
+		{french, "fr 2"},
+		{english, "en 1"},
+		{french, "fr 2"},
+	} {
+		if _, body := test.get("/", request.headers); body != request.wanted {
+			t.Fatalf("Wanted `%s`; found `%s`", request.wanted, body)
+		}
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	test := newCacheTest(
+		t,
+		CacheOptions{MaxEntries: 2},
+		cacheable(CacheControl{MaxAge: time.Hour}),
+	)
+
+	test.get("/a", nil)
+	test.get("/b", nil)
+	test.get("/a", nil) // `/b` is now the least recently used
+	test.get("/c", nil)
+	test.wantCalls(3)
+
+	test.get("/a", nil)
+	test.get("/c", nil)
+	test.wantCalls(3)
+	test.get("/b", nil)
+	test.wantCalls(4)
+}
+
+func TestCacheCoalescing(t *testing.T) {
+	release := make(chan struct{})
+	test := newCacheTest(
+		t,
+		CacheOptions{},
+		func(r Request, calls int32) Response {
+			<-release
+			return cacheable(CacheControl{MaxAge: time.Hour})(r, calls)
+		},
+	)
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, bodies[i] = test.get("/slow", nil)
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	test.wantCalls(1)
+	for i, body := range bodies {
+		if body != "response 1" {
+			t.Fatalf(
+				"Request %d: wanted `response 1`; found `%s`",
+				i,
+				fmt.Sprint(body),
+			)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("Hello, world! ", 200)
+	testCases := []struct {
+		Name           string
+		AcceptEncoding string
+		Response       Response
+		WantedEncoding string
+		WantedBody     string
+	}{{
+		Name:           "gzip",
+		AcceptEncoding: "gzip",
+		Response:       Ok(String(large)),
+		WantedEncoding: "gzip",
+		WantedBody:     large,
+	}, {
+		Name:           "deflate",
+		AcceptEncoding: "deflate",
+		Response:       Ok(String(large)),
+		WantedEncoding: "deflate",
+		WantedBody:     large,
+	}, {
+		Name:           "q-values",
+		AcceptEncoding: "gzip;q=0.5, deflate;q=0.8",
+		Response:       Ok(String(large)),
+		WantedEncoding: "deflate",
+		WantedBody:     large,
+	}, {
+		Name:           "tie-uses-server-preference",
+		AcceptEncoding: "deflate, gzip",
+		Response:       Ok(String(large)),
+		WantedEncoding: "gzip",
+		WantedBody:     large,
+	}, {
+		Name:           "wildcard",
+		AcceptEncoding: "*",
+		Response:       Ok(String(large)),
+		WantedEncoding: "gzip",
+		WantedBody:     large,
+	}, {
+		Name:           "q-zero",
+		AcceptEncoding: "gzip;q=0, *;q=0",
+		Response:       Ok(String(large)),
+		WantedBody:     large,
+	}, {
+		Name:           "identity-preferred",
+		AcceptEncoding: "gzip;q=0.5, identity",
+		Response:       Ok(String(large)),
+		WantedBody:     large,
+	}, {
+		Name:       "no-accept-encoding",
+		Response:   Ok(String(large)),
+		WantedBody: large,
+	}, {
+		Name:           "small-body",
+		AcceptEncoding: "gzip",
+		Response:       Ok(String("Hello, world!")),
+		WantedBody:     "Hello, world!",
+	}, {
+		Name:           "small-problem",
+		AcceptEncoding: "gzip",
+		Response:       NotFound(Problem(&HTTPError{Status: 404})),
+		WantedBody: `{"type":"about:blank","title":"Not Found",` +
+			`"status":404}`,
+	}, {
+		Name:           "unknown-size",
+		AcceptEncoding: "gzip",
+		Response:       Ok(Reader(strings.NewReader("Hello, world!"))),
+		WantedEncoding: "gzip",
+		WantedBody:     "Hello, world!",
+	}, {
+		Name:           "incompressible-type",
+		AcceptEncoding: "gzip",
+		Response: Ok(String(large)).WithHeaders(http.Header{
+			"Content-Type": []string{"image/png"},
+		}),
+		WantedBody: large,
+	}, {
+		Name:           "no-content",
+		AcceptEncoding: "gzip",
+		Response:       NoContent(),
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var logs []interface{}
+			handler := Handler(func(Request) Response {
+				return testCase.Response
+			}).With(Compress(CompressOptions{}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if testCase.AcceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", testCase.AcceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			handler.HTTP(func(v interface{}) { logs = append(logs, v) })(
+				w,
+				req,
+			)
+
+			if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Fatalf("Wanted `Vary: Accept-Encoding`; got `%s`", vary)
+			}
+
+			encoding := w.Header().Get("Content-Encoding")
+			if encoding != testCase.WantedEncoding {
+				t.Fatalf(
+					"Wanted Content-Encoding `%s`; got `%s`",
+					testCase.WantedEncoding,
+					encoding,
+				)
+			}
+
+			var body io.Reader = w.Body
+			switch encoding {
+			case "gzip":
+				zr, err := gzip.NewReader(body)
+				if err != nil {
+					t.Fatal("Unexpected error:", err)
+				}
+				body = zr
+			case "deflate":
+				zr, err := zlib.NewReader(body)
+				if err != nil {
+					t.Fatal("Unexpected error:", err)
+				}
+				body = zr
+			}
+			data, err := ioutil.ReadAll(body)
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			if string(data) != testCase.WantedBody {
+				t.Fatalf(
+					"Wanted body:\n%s\n\nGot body:\n%s",
+					testCase.WantedBody,
+					data,
+				)
+			}
+
+			if encoding != "" {
+				logData, err := json.Marshal(logs)
+				if err != nil {
+					t.Fatal("Unexpected error:", err)
+				}
+				if !bytes.Contains(logData, []byte(`"compressedBytes"`)) {
+					t.Fatalf("Wanted compressed sizes in log; got %s", logData)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/weberc2/httpeasy"
+	"github.com/weberc2/httpeasy/testsupport"
+)
+
+func TestConditional(t *testing.T) {
+	modified := time.Date(2021, 11, 18, 12, 0, 0, 0, time.UTC)
+	withValidators := func(r Request) Response {
+		rsp := Ok(String("Hello, world!"))
+		rsp.ETag = "v1"
+		rsp.LastModified = modified
+		return rsp
+	}
+
+	testCases := []struct {
+		Name         string
+		Method       string
+		Headers      http.Header
+		Handler      Handler
+		Options      ConditionalOptions
+		WantedStatus int
+		WantedBody   string
+	}{{
+		Name:         "unconditional",
+		Method:       "GET",
+		Handler:      withValidators,
+		WantedStatus: 200,
+		WantedBody:   "Hello, world!",
+	}, {
+		Name:         "if-none-match-matches",
+		Method:       "GET",
+		Headers:      http.Header{"If-None-Match": {`"v0", W/"v1"`}},
+		Handler:      withValidators,
+		WantedStatus: 304,
+	}, {
+		Name:         "if-none-match-mismatch",
+		Method:       "GET",
+		Headers:      http.Header{"If-None-Match": {`"v0"`}},
+		Handler:      withValidators,
+		WantedStatus: 200,
+		WantedBody:   "Hello, world!",
+	}, {
+		Name:   "if-modified-since-not-modified",
+		Method: "GET",
+		Headers: http.Header{
+			"If-Modified-Since": {modified.Format(http.TimeFormat)},
+		},
+		Handler:      withValidators,
+		WantedStatus: 304,
+	}, {
+		Name:   "if-modified-since-modified",
+		Method: "GET",
+		Headers: http.Header{
+			"If-Modified-Since": {
+				modified.Add(-time.Hour).Format(http.TimeFormat),
+			},
+		},
+		Handler:      withValidators,
+		WantedStatus: 200,
+		WantedBody:   "Hello, world!",
+	}, {
+		Name:   "if-none-match-takes-precedence",
+		Method: "GET",
+		Headers: http.Header{
+			"If-None-Match":     {`"v0"`},
+			"If-Modified-Since": {modified.Format(http.TimeFormat)},
+		},
+		Handler:      withValidators,
+		WantedStatus: 200,
+		WantedBody:   "Hello, world!",
+	}, {
+		Name:         "if-match-mismatch",
+		Method:       "GET",
+		Headers:      http.Header{"If-Match": {`"v0"`}},
+		Handler:      withValidators,
+		WantedStatus: 412,
+		WantedBody:   "412 Precondition Failed",
+	}, {
+		Name:    "auto-etag",
+		Method:  "GET",
+		Headers: http.Header{"If-None-Match": {autoETag(t)}},
+		Handler: func(Request) Response {
+			return Ok(String("Hello, world!"))
+		},
+		Options:      ConditionalOptions{AutoETag: true},
+		WantedStatus: 304,
+	}, {
+		Name:    "put-if-match-mismatch",
+		Method:  "PUT",
+		Headers: http.Header{"If-Match": {`"v0"`}},
+		Handler: func(r Request) Response {
+			if rsp, ok := r.CheckPreconditions("v1", time.Time{}); !ok {
+				return rsp
+			}
+			return Ok(String("updated"))
+		},
+		WantedStatus: 412,
+		WantedBody:   "412 Precondition Failed",
+	}, {
+		Name:    "put-if-match-matches",
+		Method:  "PUT",
+		Headers: http.Header{"If-Match": {`"v1"`}},
+		Handler: func(r Request) Response {
+			if rsp, ok := r.CheckPreconditions("v1", time.Time{}); !ok {
+				return rsp
+			}
+			return Ok(String("updated"))
+		},
+		WantedStatus: 200,
+		WantedBody:   "updated",
+	}, {
+		Name:    "put-if-none-match-star-creates",
+		Method:  "PUT",
+		Headers: http.Header{"If-None-Match": {"*"}},
+		Handler: func(r Request) Response {
+			if rsp, ok := r.CheckPreconditions("", time.Time{}); !ok {
+				return rsp
+			}
+			return Created(String("created"))
+		},
+		WantedStatus: 201,
+		WantedBody:   "created",
+	}, {
+		Name:    "put-if-none-match-star-exists",
+		Method:  "PUT",
+		Headers: http.Header{"If-None-Match": {"*"}},
+		Handler: func(r Request) Response {
+			if rsp, ok := r.CheckPreconditions("v1", time.Time{}); !ok {
+				return rsp
+			}
+			return Created(String("created"))
+		},
+		WantedStatus: 412,
+		WantedBody:   "412 Precondition Failed",
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			req := httptest.NewRequest(testCase.Method, "/", nil)
+			for key, values := range testCase.Headers {
+				req.Header[key] = values
+			}
+			w := httptest.NewRecorder()
+			testCase.Handler.With(Conditional(testCase.Options)).HTTP(
+				testsupport.TestLog(t),
+			)(w, req)
+
+			if w.Code != testCase.WantedStatus {
+				t.Fatalf(
+					"Wanted status `%d`; found `%d`",
+					testCase.WantedStatus,
+					w.Code,
+				)
+			}
+			if body := w.Body.String(); body != testCase.WantedBody {
+				t.Fatalf(
+					"Wanted body `%s`; found `%s`",
+					testCase.WantedBody,
+					body,
+				)
+			}
+		})
+	}
+}
+
+// autoETag fetches the entity-tag generated by `ConditionalOptions.AutoETag`
+// for the body `Hello, world!`.
+func autoETag(t *testing.T) string {
+	w := httptest.NewRecorder()
+	Handler(func(Request) Response {
+		return Ok(String("Hello, world!"))
+	}).With(Conditional(ConditionalOptions{AutoETag: true})).HTTP(
+		testsupport.TestLog(t),
+	)(w, httptest.NewRequest("GET", "/", nil))
+
+	etag := w.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("Wanted a weak ETag header; found `%s`", etag)
+	}
+	return etag
+}
+
+func TestConditionalKeepsCookies(t *testing.T) {
+	handler := Handler(func(Request) Response {
+		rsp := Ok(String("Hello, world!")).
+			WithCookies(&http.Cookie{Name: "session", Value: "renewed"}).
+			WithHeaders(http.Header{"Set-Cookie": {"csrf=token"}})
+		rsp.ETag = "v1"
+		return rsp
+	}).With(Conditional(ConditionalOptions{}))
+
+	for _, testCase := range []struct {
+		Header       string
+		Value        string
+		WantedStatus int
+	}{
+		{Header: "If-None-Match", Value: `"v1"`, WantedStatus: 304},
+		{Header: "If-Match", Value: `"v0"`, WantedStatus: 412},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(testCase.Header, testCase.Value)
+		w := httptest.NewRecorder()
+		handler.HTTP(testsupport.TestLog(t))(w, req)
+
+		if w.Code != testCase.WantedStatus {
+			t.Fatalf(
+				"Wanted status `%d`; found `%d`",
+				testCase.WantedStatus,
+				w.Code,
+			)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 2 {
+			t.Fatalf(
+				"%s: wanted 2 cookies; found `%v`",
+				testCase.Header,
+				cookies,
+			)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+func TestRouterCORS(t *testing.T) {
+	ok := func(Request) Response { return Ok(String("ok")) }
+	router := NewRouter()
+	router.CORS = &CORSOptions{
+		AllowedOrigins: []string{
+			"https://app.example.com",
+			"https://*.tenants.example.com",
+		},
+		AllowOrigin: func(origin string) bool {
+			return strings.HasSuffix(origin, ".partner.test")
+		},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+	router.Register(
+		func(interface{}) {},
+		Route{Path: "/items", Method: "GET", Handler: ok},
+		Route{Path: "/items", Method: "POST", Handler: ok},
+		Route{Path: "/items/{id}", Method: "DELETE", Handler: ok},
+	)
+
+	preflight := func(origin, method, headers string) http.Header {
+		h := http.Header{
+			"Origin":                        {origin},
+			"Access-Control-Request-Method": {method},
+		}
+		if headers != "" {
+			h.Set("Access-Control-Request-Headers", headers)
+		}
+		return h
+	}
+
+	testCases := []struct {
+		Name          string
+		Method        string
+		Path          string
+		Headers       http.Header
+		WantedStatus  int
+		WantedHeaders http.Header
+		WantedAbsent  []string
+	}{{
+		Name:   "preflight",
+		Method: "OPTIONS",
+		Path:   "/items",
+		Headers: preflight(
+			"https://app.example.com",
+			"POST",
+			"content-type",
+		),
+		WantedStatus: 204,
+		WantedHeaders: http.Header{
+			"Access-Control-Allow-Origin":      {"https://app.example.com"},
+			"Access-Control-Allow-Methods":     {"GET, POST"},
+			"Access-Control-Allow-Headers":     {"content-type"},
+			"Access-Control-Allow-Credentials": {"true"},
+			"Access-Control-Max-Age":           {"600"},
+		},
+	}, {
+		Name:   "preflight-path-methods",
+		Method: "OPTIONS",
+		Path:   "/items/1",
+		Headers: preflight(
+			"https://acme.tenants.example.com",
+			"DELETE",
+			"",
+		),
+		WantedStatus: 204,
+		WantedHeaders: http.Header{
+			"Access-Control-Allow-Origin": {
+				"https://acme.tenants.example.com",
+			},
+			"Access-Control-Allow-Methods": {"DELETE"},
+		},
+	}, {
+		Name:   "preflight-predicate",
+		Method: "OPTIONS",
+		Path:   "/items",
+		Headers: preflight(
+			"https://shop.partner.test",
+			"GET",
+			"",
+		),
+		WantedStatus: 204,
+		WantedHeaders: http.Header{
+			"Access-Control-Allow-Origin": {"https://shop.partner.test"},
+		},
+	}, {
+		Name:   "preflight-disallowed-origin",
+		Method: "OPTIONS",
+		Path:   "/items",
+		Headers: preflight(
+			"https://tenants.example.com",
+			"GET",
+			"",
+		),
+		WantedStatus: 204,
+		WantedAbsent: []string{"Access-Control-Allow-Origin"},
+	}, {
+		Name:   "preflight-unrouted-method",
+		Method: "OPTIONS",
+		Path:   "/items",
+		Headers: preflight(
+			"https://app.example.com",
+			"DELETE",
+			"",
+		),
+		WantedStatus: 204,
+		WantedAbsent: []string{"Access-Control-Allow-Origin"},
+	}, {
+		Name:   "preflight-disallowed-header",
+		Method: "OPTIONS",
+		Path:   "/items",
+		Headers: preflight(
+			"https://app.example.com",
+			"POST",
+			"X-Debug",
+		),
+		WantedStatus: 204,
+		WantedAbsent: []string{"Access-Control-Allow-Origin"},
+	}, {
+		Name:   "preflight-unknown-path",
+		Method: "OPTIONS",
+		Path:   "/gadgets",
+		Headers: preflight(
+			"https://app.example.com",
+			"GET",
+			"",
+		),
+		WantedStatus: 404,
+	}, {
+		Name:         "options-without-preflight",
+		Method:       "OPTIONS",
+		Path:         "/items",
+		WantedStatus: 405,
+	}, {
+		Name:         "actual",
+		Method:       "GET",
+		Path:         "/items",
+		Headers:      http.Header{"Origin": {"https://app.example.com"}},
+		WantedStatus: 200,
+		WantedHeaders: http.Header{
+			"Access-Control-Allow-Origin":      {"https://app.example.com"},
+			"Access-Control-Allow-Credentials": {"true"},
+			"Access-Control-Expose-Headers":    {"X-Request-Id"},
+			"Vary":                             {"Origin"},
+		},
+	}, {
+		Name:          "actual-disallowed-origin",
+		Method:        "GET",
+		Path:          "/items",
+		Headers:       http.Header{"Origin": {"https://evil.example"}},
+		WantedStatus:  200,
+		WantedHeaders: http.Header{"Vary": {"Origin"}},
+		WantedAbsent:  []string{"Access-Control-Allow-Origin"},
+	}, {
+		Name:         "same-origin",
+		Method:       "GET",
+		Path:         "/items",
+		WantedStatus: 200,
+		WantedAbsent: []string{"Access-Control-Allow-Origin"},
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			req := httptest.NewRequest(testCase.Method, testCase.Path, nil)
+			for key, values := range testCase.Headers {
+				req.Header[key] = values
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			wantCORSResponse(
+				t,
+				w,
+				testCase.WantedStatus,
+				testCase.WantedHeaders,
+				testCase.WantedAbsent,
+			)
+		})
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	handler := Handler(func(Request) Response {
+		return Ok(String("ok"))
+	}).With(CORS(CORSOptions{AllowedOrigins: []string{"*"}}))
+
+	serve := func(
+		method string,
+		headers http.Header,
+	) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		for key, values := range headers {
+			req.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		handler.HTTP(func(interface{}) {})(w, req)
+		return w
+	}
+
+	// Any origin gets the same answer, so there's no need to vary
+	w := serve("GET", http.Header{"Origin": {"https://anywhere.test"}})
+	wantCORSResponse(
+		t,
+		w,
+		200,
+		http.Header{"Access-Control-Allow-Origin": {"*"}},
+		[]string{"Vary", "Access-Control-Allow-Credentials"},
+	)
+
+	// Preflights which reach the handler are answered with the default
+	// methods
+	w = serve("OPTIONS", http.Header{
+		"Origin":                        {"https://anywhere.test"},
+		"Access-Control-Request-Method": {"POST"},
+	})
+	wantCORSResponse(
+		t,
+		w,
+		204,
+		http.Header{
+			"Access-Control-Allow-Origin":  {"*"},
+			"Access-Control-Allow-Methods": {"GET, HEAD, POST"},
+		},
+		nil,
+	)
+}
+
+func wantCORSResponse(
+	t *testing.T,
+	w *httptest.ResponseRecorder,
+	status int,
+	headers http.Header,
+	absent []string,
+) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("Wanted status `%d`; found `%d`", status, w.Code)
+	}
+	for key, wanted := range headers {
+		if found := w.Header().Values(key); strings.Join(found, "|") !=
+			strings.Join(wanted, "|") {
+			t.Fatalf("Wanted `%s: %v`; found `%v`", key, wanted, found)
+		}
+	}
+	for _, key := range absent {
+		if found := w.Header().Get(key); found != "" {
+			t.Fatalf("Wanted no `%s` header; found `%s`", key, found)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	html "html/template"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+var csrfForm = html.Must(html.New("form").Funcs(TemplateFuncs()).Parse(
+	`<form method="POST">{{csrfField}}<input name="name"></form>`,
+))
+
+var csrfFieldPattern = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// csrfHandler renders the form on GET and echoes the body otherwise.
+func csrfHandler(options CSRFOptions) Handler {
+	return Handler(func(r Request) Response {
+		if r.Method == "GET" {
+			return Ok(r.HTMLTemplate(csrfForm, nil))
+		}
+		body, err := r.Text()
+		if err != nil {
+			return HandleError("Error reading body", err)
+		}
+		return Ok(String(body))
+	}).With(CSRF(options))
+}
+
+// csrfServe serves `req` and returns the response and the request log.
+func csrfServe(
+	h Handler,
+	req *http.Request,
+) (*httptest.ResponseRecorder, string) {
+	var logs []string
+	w := httptest.NewRecorder()
+	h.HTTP(func(v interface{}) {
+		data, _ := json.Marshal(v)
+		logs = append(logs, string(data))
+	})(w, req)
+	return w, strings.Join(logs, "\n")
+}
+
+// csrfGet renders the form, returning the token in the form and the cookies
+// set by the response.
+func csrfGet(t *testing.T, h Handler) (string, []*http.Cookie) {
+	w, _ := csrfServe(h, httptest.NewRequest("GET", "/", nil))
+	match := csrfFieldPattern.FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Fatalf("Wanted a CSRF field; found `%s`", w.Body.String())
+	}
+	return match[1], w.Result().Cookies()
+}
+
+func TestCSRF(t *testing.T) {
+	options := CSRFOptions{
+		TrustedOrigins: []string{"https://admin.example.com"},
+		Exempt: func(r Request) bool {
+			return r.URL.Path == "/webhook"
+		},
+	}
+	handler := csrfHandler(options)
+	token, cookies := csrfGet(t, handler)
+	if len(cookies) != 1 || cookies[0].Name != "csrf" ||
+		!cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("Wanted a secure `csrf` cookie; found `%v`", cookies)
+	}
+
+	// Each rendering masks the token differently
+	if other, _ := csrfGet(t, handler); other == token {
+		t.Fatal("Wanted differently masked tokens")
+	}
+
+	form := func(token string) string {
+		return url.Values{"csrf_token": {token}, "name": {"alice"}}.Encode()
+	}
+	var multipartBody bytes.Buffer
+	writer := multipart.NewWriter(&multipartBody)
+	writer.WriteField("csrf_token", token)
+	writer.WriteField("name", "alice")
+	writer.Close()
+
+	// The token comes after a file too large to search through
+	var largeBody bytes.Buffer
+	largeWriter := multipart.NewWriter(&largeBody)
+	file, _ := largeWriter.CreateFormFile("upload", "large.bin")
+	file.Write(make([]byte, 10<<20))
+	largeWriter.WriteField("csrf_token", token)
+	largeWriter.Close()
+
+	testCases := []struct {
+		Name         string
+		Path         string
+		Body         string
+		ContentType  string
+		Headers      http.Header
+		NoCookie     bool
+		WantedStatus int
+		WantedBody   string
+	}{{
+		Name:         "form-field",
+		Body:         form(token),
+		WantedStatus: 200,
+		WantedBody:   form(token),
+	}, {
+		Name:         "header",
+		Body:         "{}",
+		ContentType:  "application/json",
+		Headers:      http.Header{"X-Csrf-Token": {token}},
+		WantedStatus: 200,
+		WantedBody:   "{}",
+	}, {
+		Name:         "multipart",
+		Body:         multipartBody.String(),
+		ContentType:  writer.FormDataContentType(),
+		WantedStatus: 200,
+		WantedBody:   multipartBody.String(),
+	}, {
+		Name:         "multipart-too-large",
+		Body:         largeBody.String(),
+		ContentType:  largeWriter.FormDataContentType(),
+		WantedStatus: 413,
+	}, {
+		Name:         "missing-token",
+		Body:         "name=alice",
+		WantedStatus: 403,
+	}, {
+		Name:         "invalid-token",
+		Body:         form(tamper(token)),
+		WantedStatus: 403,
+	}, {
+		Name:         "no-cookie",
+		Body:         form(token),
+		NoCookie:     true,
+		WantedStatus: 403,
+	}, {
+		Name:         "same-origin",
+		Body:         form(token),
+		Headers:      http.Header{"Origin": {"https://example.com"}},
+		WantedStatus: 200,
+		WantedBody:   form(token),
+	}, {
+		Name:         "cross-origin",
+		Body:         form(token),
+		Headers:      http.Header{"Origin": {"https://evil.example"}},
+		WantedStatus: 403,
+	}, {
+		Name:         "null-origin",
+		Body:         form(token),
+		Headers:      http.Header{"Origin": {"null"}},
+		WantedStatus: 403,
+	}, {
+		Name:         "cross-site-fetch",
+		Body:         form(token),
+		Headers:      http.Header{"Sec-Fetch-Site": {"cross-site"}},
+		WantedStatus: 403,
+	}, {
+		Name: "trusted-origin",
+		Body: form(token),
+		Headers: http.Header{
+			"Origin":         {"https://admin.example.com"},
+			"Sec-Fetch-Site": {"same-site"},
+		},
+		WantedStatus: 200,
+		WantedBody:   form(token),
+	}, {
+		Name:         "exempt",
+		Path:         "/webhook",
+		Body:         "payload",
+		NoCookie:     true,
+		WantedStatus: 200,
+		WantedBody:   "payload",
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			path := testCase.Path
+			if path == "" {
+				path = "/"
+			}
+			req := httptest.NewRequest(
+				"POST",
+				"https://example.com"+path,
+				strings.NewReader(testCase.Body),
+			)
+			contentType := testCase.ContentType
+			if contentType == "" {
+				contentType = "application/x-www-form-urlencoded"
+			}
+			req.Header.Set("Content-Type", contentType)
+			req.Header.Set("Content-Length", fmt.Sprint(len(testCase.Body)))
+			for key, values := range testCase.Headers {
+				req.Header[key] = values
+			}
+			if !testCase.NoCookie {
+				req.AddCookie(cookies[0])
+			}
+
+			w, log := csrfServe(handler, req)
+			if w.Code != testCase.WantedStatus {
+				t.Fatalf(
+					"Wanted status `%d`; found `%d`: %s",
+					testCase.WantedStatus,
+					w.Code,
+					w.Body.String(),
+				)
+			}
+			if w.Code == 403 && !strings.Contains(
+				log,
+				`"message":"Rejected request by CSRF protection"`,
+			) {
+				t.Fatalf("Wanted the rejection to be logged; found:\n%s", log)
+			}
+			if w.Code == 200 && w.Body.String() != testCase.WantedBody {
+				t.Fatalf(
+					"Wanted body `%s`; found `%s`",
+					testCase.WantedBody,
+					w.Body.String(),
+				)
+			}
+		})
+	}
+}
+
+func TestCSRFSession(t *testing.T) {
+	handler := csrfHandler(CSRFOptions{}).With(Sessions(SessionOptions{
+		Store: &MemoryStore{},
+	}))
+
+	// The token lives in the session rather than its own cookie
+	token, cookies := csrfGet(t, handler)
+	if len(cookies) != 1 || cookies[0].Name != "session" {
+		t.Fatalf("Wanted only a session cookie; found `%v`", cookies)
+	}
+
+	post := func(cookie *http.Cookie) int {
+		body := url.Values{"csrf_token": {token}}.Encode()
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Content-Length", fmt.Sprint(len(body)))
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w, _ := csrfServe(handler, req)
+		return w.Code
+	}
+	if status := post(cookies[0]); status != 200 {
+		t.Fatalf("Wanted status `200`; found `%d`", status)
+	}
+
+	// The token is bound to the session
+	_, otherCookies := csrfGet(t, handler)
+	if status := post(otherCookies[0]); status != 403 {
+		t.Fatalf("Wanted status `403`; found `%d`", status)
+	}
+}
+
+func TestCSRFTemplateFuncsRequireMiddleware(t *testing.T) {
+	handler := Handler(func(r Request) Response {
+		return Ok(r.HTMLTemplate(csrfForm, nil))
+	})
+	w, _ := csrfServe(handler, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 500 {
+		t.Fatalf("Wanted status `500`; found `%d`", w.Code)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+func TestErrHandler(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		ErrHandler   ErrHandler
+		Renderer     ErrorRenderer
+		WantedStatus int
+		WantedBody   string
+		WantedLogs   []string
+	}{{
+		Name: "ok",
+		ErrHandler: func(Request) (Response, error) {
+			return Ok(String("hello")), nil
+		},
+		WantedStatus: 200,
+		WantedBody:   "hello",
+	}, {
+		Name: "http-error",
+		ErrHandler: func(Request) (Response, error) {
+			return Response{}, fmt.Errorf(
+				"fetching widget: %w",
+				&HTTPError{Status: 404, Detail: "no such widget"},
+			)
+		},
+		WantedStatus: 404,
+		WantedBody:   `"detail":"no such widget"`,
+		WantedLogs: []string{
+			`"type":"*fmt.wrapError"`,
+			`"type":"*httpeasy.HTTPError"`,
+		},
+	}, {
+		Name: "unmapped-error",
+		ErrHandler: func(Request) (Response, error) {
+			return Response{}, errors.New("connection refused")
+		},
+		WantedStatus: 500,
+		WantedBody:   "500 Internal Server Error",
+		WantedLogs:   []string{`"error":"connection refused"`},
+	}, {
+		Name: "joined-errors",
+		ErrHandler: func(Request) (Response, error) {
+			return Response{}, errors.Join(
+				errors.New("first"),
+				errors.New("second"),
+			)
+		},
+		WantedStatus: 500,
+		WantedLogs: []string{
+			`"message":"first"`,
+			`"message":"second"`,
+		},
+	}, {
+		Name: "stack",
+		ErrHandler: func(Request) (Response, error) {
+			return Response{}, WithStack(errors.New("boom"))
+		},
+		WantedStatus: 500,
+		WantedLogs:   []string{`"stack":"boom\n`, "TestErrHandler"},
+	}, {
+		Name: "custom-renderer",
+		ErrHandler: func(Request) (Response, error) {
+			return Response{}, errors.New("boom")
+		},
+		Renderer: func(r Request, err error) Response {
+			return ServiceUnavailable(String("try again: " + err.Error()))
+		},
+		WantedStatus: 503,
+		WantedBody:   "try again: boom",
+		WantedLogs:   []string{`"error":"boom"`},
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var logs []string
+			router := NewRouter()
+			router.ErrorRenderer = testCase.Renderer
+			router.Register(
+				func(v interface{}) {
+					data, err := json.Marshal(v)
+					if err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					logs = append(logs, string(data))
+				},
+				Route{
+					Method:     "GET",
+					Path:       "/widgets",
+					ErrHandler: testCase.ErrHandler,
+				},
+			)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/widgets", nil))
+
+			if w.Code != testCase.WantedStatus {
+				t.Fatalf(
+					"Wanted status `%d`; found `%d`",
+					testCase.WantedStatus,
+					w.Code,
+				)
+			}
+			if body := w.Body.String(); !strings.Contains(
+				body,
+				testCase.WantedBody,
+			) {
+				t.Fatalf(
+					"Wanted body containing `%s`; found `%s`",
+					testCase.WantedBody,
+					body,
+				)
+			}
+
+			log := strings.Join(logs, "\n")
+			for _, wanted := range testCase.WantedLogs {
+				if !strings.Contains(log, wanted) {
+					t.Fatalf(
+						"Wanted log containing `%s`; found:\n%s",
+						wanted,
+						log,
+					)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/weberc2/httpeasy"
+	"github.com/weberc2/httpeasy/testsupport"
+)
+
+func TestHTTPErrorMarshalJSON(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Error  *HTTPError
+		Wanted string
+	}{{
+		Name:   "defaults",
+		Error:  &HTTPError{Status: 404},
+		Wanted: `{"type":"about:blank","title":"Not Found","status":404}`,
+	}, {
+		Name:  "message",
+		Error: &HTTPError{Status: 400, Message: "missing name"},
+		Wanted: `{"type":"about:blank","title":"Bad Request","status":400,` +
+			`"message":"missing name"}`,
+	}, {
+		Name: "full",
+		Error: &HTTPError{
+			Status:   403,
+			Type:     "https://example.com/probs/out-of-credit",
+			Title:    "You do not have enough credit.",
+			Detail:   "Your current balance is 30, but that costs 50.",
+			Instance: "/account/12345/msgs/abc",
+			Extensions: map[string]interface{}{
+				"balance":  30,
+				"accounts": []string{"/account/12345"},
+				"status":   "ignored",
+			},
+		},
+		Wanted: `{"type":"https://example.com/probs/out-of-credit",` +
+			`"title":"You do not have enough credit.",` +
+			`"status":403,` +
+			`"detail":"Your current balance is 30, but that costs 50.",` +
+			`"instance":"/account/12345/msgs/abc",` +
+			`"accounts":["/account/12345"],` +
+			`"balance":30}`,
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			data, err := json.Marshal(testCase.Error)
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			if string(data) != testCase.Wanted {
+				t.Fatalf(
+					"Wanted:\n%s\n\nFound:\n%s",
+					testCase.Wanted,
+					data,
+				)
+			}
+
+			// Make sure the error round-trips
+			if err := testCase.Error.CompareData(data); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestHTTPErrorCompareData(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		Wanted      *HTTPError
+		Data        string
+		WantedError string
+	}{{
+		Name:   "legacy-body",
+		Wanted: &HTTPError{Status: 404, Message: "not found"},
+		Data:   `{"status":404,"message":"not found"}`,
+	}, {
+		Name:   "detail-mismatch",
+		Wanted: &HTTPError{Status: 409, Detail: "version 3 is stale"},
+		Data:   `{"status":409,"detail":"version 2 is stale"}`,
+		WantedError: "HTTPError.Detail: wanted `version 3 is stale`; " +
+			"found `version 2 is stale`",
+	}, {
+		Name: "extension-mismatch",
+		Wanted: &HTTPError{
+			Status:     400,
+			Extensions: map[string]interface{}{"field": "name"},
+		},
+		Data: `{"status":400,"field":"age"}`,
+		WantedError: `HTTPError.Extensions["field"]: wanted ` +
+			"`\"name\"`; found `\"age\"`",
+	}, {
+		Name: "extension-numbers",
+		Wanted: &HTTPError{
+			Status:     400,
+			Extensions: map[string]interface{}{"balance": 30},
+		},
+		Data: `{"status":400,"balance":30}`,
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			err := testCase.Wanted.CompareData([]byte(testCase.Data))
+			found := fmt.Sprint(err)
+			if err == nil {
+				found = ""
+			}
+			if found != testCase.WantedError {
+				t.Fatalf(
+					"Wanted error `%s`; found `%s`",
+					testCase.WantedError,
+					found,
+				)
+			}
+		})
+	}
+}
+
+func TestHandleErrorProblem(t *testing.T) {
+	httpErr := &HTTPError{Status: 409, Detail: "widget was modified"}
+	rsp := HandleError("updating widget", fmt.Errorf("saving: %w", httpErr))
+	if rsp.Status != 409 {
+		t.Fatalf("Wanted status `409`; found `%d`", rsp.Status)
+	}
+
+	writerTo, err := rsp.Data()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	typed, ok := writerTo.(interface{ ContentType() string })
+	if !ok || typed.ContentType() != "application/problem+json" {
+		t.Fatal("Wanted the `application/problem+json` content type")
+	}
+	if err := testsupport.CompareSerializer(httpErr, rsp.Data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type validationError struct{ field string }
+
+func (err *validationError) Error() string { return "invalid " + err.field }
+
+type conflictError struct{ err error }
+
+func (err *conflictError) Error() string {
+	return "conflict: " + err.err.Error()
+}
+
+func (err *conflictError) Unwrap() error { return err.err }
+
+func (err *conflictError) HTTPError() *HTTPError {
+	return &HTTPError{Status: 409, Message: "conflict"}
+}
+
+var errNoRows = errors.New("no rows in result set")
+
+func TestErrorMapper(t *testing.T) {
+	mapper := &ErrorMapper{}
+	mapper.MapSentinel(errNoRows, 404, "not found")
+	mapper.Map(func(err error) *HTTPError {
+		var e *validationError
+		if errors.As(err, &e) {
+			return &HTTPError{Status: 422, Detail: e.Error()}
+		}
+		return nil
+	})
+
+	testCases := []struct {
+		Name         string
+		Error        error
+		WantedStatus int
+	}{{
+		Name: "http-error-wrapping-cause",
+		Error: fmt.Errorf("fetching widget: %w", &HTTPError{
+			Status: 400,
+			Cause_: errNoRows,
+		}),
+		WantedStatus: 400,
+	}, {
+		Name: "outermost-wins",
+		Error: &conflictError{fmt.Errorf(
+			"saving: %w",
+			&HTTPError{Status: 400},
+		)},
+		WantedStatus: 409,
+	}, {
+		Name: "joined",
+		Error: errors.Join(
+			errors.New("unrelated"),
+			fmt.Errorf("saving: %w", &conflictError{errors.New("stale")}),
+		),
+		WantedStatus: 409,
+	}, {
+		Name:         "sentinel",
+		Error:        fmt.Errorf("querying widget: %w", errNoRows),
+		WantedStatus: 404,
+	}, {
+		Name:         "custom-type",
+		Error:        fmt.Errorf("parsing: %w", &validationError{"name"}),
+		WantedStatus: 422,
+	}, {
+		Name:         "unmapped",
+		Error:        errors.New("connection refused"),
+		WantedStatus: 500,
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			rsp := mapper.HandleError("handling request", testCase.Error)
+			if rsp.Status != testCase.WantedStatus {
+				t.Fatalf(
+					"Wanted status `%d`; found `%d`",
+					testCase.WantedStatus,
+					rsp.Status,
+				)
+			}
+		})
+	}
+}
+
+func TestErrorMapperSharedValue(t *testing.T) {
+	shared := &HTTPError{Status: 404, Message: "not found"}
+	mapper := &ErrorMapper{}
+	mapper.Map(func(err error) *HTTPError {
+		if errors.Is(err, errNoRows) {
+			return shared
+		}
+		return nil
+	})
+
+	err := fmt.Errorf("querying widget: %w", errNoRows)
+	httpErr, ok := mapper.HTTPError(err)
+	if !ok {
+		t.Fatal("Wanted a mapped error")
+	}
+	if httpErr.Cause() != err {
+		t.Fatalf("Wanted cause `%v`; found `%v`", err, httpErr.Cause())
+	}
+	if shared.Cause_ != nil {
+		t.Fatalf("Wanted the shared value untouched; found `%v`", shared.Cause_)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	. "github.com/weberc2/httpeasy"
+	"github.com/weberc2/httpeasy/testsupport"
+)
+
+var modified = time.Date(2021, 11, 18, 12, 0, 0, 0, time.UTC)
+
+var staticFS = fstest.MapFS{
+	"hello.txt": &fstest.MapFile{
+		Data:    []byte("Hello, world!"),
+		ModTime: modified,
+	},
+	"app.3f9a2b1c.js": &fstest.MapFile{
+		Data:    []byte("console.log('hi');"),
+		ModTime: modified,
+	},
+	"docs/index.html": &fstest.MapFile{
+		Data:    []byte("<h1>Docs</h1>"),
+		ModTime: modified,
+	},
+	"assets/logo.svg": &fstest.MapFile{
+		Data:    []byte("<svg></svg>"),
+		ModTime: modified,
+	},
+}
+
+func TestStaticRoute(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Route        StaticRoute
+		Path         string
+		Headers      http.Header
+		WantedStatus int
+		WantedBody   string
+		WantedHeader http.Header
+	}{{
+		Name:         "file",
+		Path:         "/static/hello.txt",
+		WantedStatus: 200,
+		WantedBody:   "Hello, world!",
+		WantedHeader: http.Header{
+			"Content-Type":  {"text/plain; charset=utf-8"},
+			"Cache-Control": {"no-cache"},
+			"Accept-Ranges": {"bytes"},
+			"Last-Modified": {modified.Format(http.TimeFormat)},
+		},
+	}, {
+		Name:         "range",
+		Path:         "/static/hello.txt",
+		Headers:      http.Header{"Range": {"bytes=7-11"}},
+		WantedStatus: 206,
+		WantedBody:   "world",
+		WantedHeader: http.Header{"Content-Range": {"bytes 7-11/13"}},
+	}, {
+		Name:         "suffix-range",
+		Path:         "/static/hello.txt",
+		Headers:      http.Header{"Range": {"bytes=-6"}},
+		WantedStatus: 206,
+		WantedBody:   "world!",
+	}, {
+		Name:         "unsatisfiable-range",
+		Path:         "/static/hello.txt",
+		Headers:      http.Header{"Range": {"bytes=100-"}},
+		WantedStatus: 416,
+		WantedBody:   "416 Requested Range Not Satisfiable",
+		WantedHeader: http.Header{"Content-Range": {"bytes */13"}},
+	}, {
+		Name: "stale-if-range",
+		Path: "/static/hello.txt",
+		Headers: http.Header{
+			"Range":    {"bytes=7-11"},
+			"If-Range": {modified.Add(-time.Hour).Format(http.TimeFormat)},
+		},
+		WantedStatus: 200,
+		WantedBody:   "Hello, world!",
+	}, {
+		Name: "not-modified",
+		Path: "/static/hello.txt",
+		Headers: http.Header{
+			"If-Modified-Since": {modified.Format(http.TimeFormat)},
+		},
+		WantedStatus: 304,
+	}, {
+		Name:         "fingerprinted",
+		Path:         "/static/app.3f9a2b1c.js",
+		WantedStatus: 200,
+		WantedBody:   "console.log('hi');",
+		WantedHeader: http.Header{
+			"Cache-Control": {"public, max-age=31536000, immutable"},
+		},
+	}, {
+		Name:         "index",
+		Path:         "/static/docs/",
+		WantedStatus: 200,
+		WantedBody:   "<h1>Docs</h1>",
+	}, {
+		Name:         "directory-redirect",
+		Path:         "/static/docs",
+		WantedStatus: 301,
+		WantedHeader: http.Header{"Location": {"/static/docs/"}},
+	}, {
+		Name:         "listing-disabled",
+		Path:         "/static/assets/",
+		WantedStatus: 404,
+	}, {
+		Name: "listing-enabled",
+		Route: StaticRoute{
+			Path:            "/static/",
+			FS:              staticFS,
+			ListDirectories: true,
+		},
+		Path:         "/static/assets/",
+		WantedStatus: 200,
+	}, {
+		Name:         "not-found",
+		Path:         "/static/missing.txt",
+		WantedStatus: 404,
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			route := testCase.Route
+			if route.FS == nil {
+				route = StaticRoute{Path: "/static/", FS: staticFS}
+			}
+			req := httptest.NewRequest("GET", testCase.Path, nil)
+			for key, values := range testCase.Headers {
+				req.Header[key] = values
+			}
+			w := httptest.NewRecorder()
+			NewRouter().
+				RegisterStatic(testsupport.TestLog(t), route).
+				ServeHTTP(w, req)
+
+			if w.Code != testCase.WantedStatus {
+				t.Fatalf(
+					"Wanted status `%d`; found `%d`",
+					testCase.WantedStatus,
+					w.Code,
+				)
+			}
+			if testCase.WantedBody != "" &&
+				w.Body.String() != testCase.WantedBody {
+				t.Fatalf(
+					"Wanted body `%s`; found `%s`",
+					testCase.WantedBody,
+					w.Body.String(),
+				)
+			}
+			for key, values := range testCase.WantedHeader {
+				if found := w.Header().Get(key); found != values[0] {
+					t.Fatalf(
+						"Wanted header `%s: %s`; found `%s`",
+						key,
+						values[0],
+						found,
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestContentMultipartRanges(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Range", "bytes=0-4,7-11")
+	Handler(func(r Request) Response {
+		return Content(
+			r,
+			"hello.txt",
+			modified,
+			strings.NewReader("Hello, world!"),
+		)
+	}).HTTP(testsupport.TestLog(t))(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("Wanted status `206`; found `%d`", w.Code)
+	}
+	mediaType, params, err := mime.ParseMediaType(
+		w.Header().Get("Content-Type"),
+	)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if mediaType != "multipart/byteranges" {
+		t.Fatalf("Wanted multipart/byteranges; found `%s`", mediaType)
+	}
+
+	wanted := []struct{ ContentRange, Body string }{
+		{"bytes 0-4/13", "Hello"},
+		{"bytes 7-11/13", "world"},
+	}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	for i, wantedPart := range wanted {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("Reading part %d: %v", i, err)
+		}
+		found := part.Header.Get("Content-Range")
+		if found != wantedPart.ContentRange {
+			t.Fatalf(
+				"Part %d: wanted Content-Range `%s`; found `%s`",
+				i,
+				wantedPart.ContentRange,
+				found,
+			)
+		}
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatalf("Reading part %d: %v", i, err)
+		}
+		if string(data) != wantedPart.Body {
+			t.Fatalf(
+				"Part %d: wanted body `%s`; found `%s`",
+				i,
+				wantedPart.Body,
+				data,
+			)
+		}
+	}
+}
+
+// closeCountingFS counts the files opened from it which are still open.
+type closeCountingFS struct {
+	fs.FS
+	open int
+}
+
+func (cfs *closeCountingFS) Open(name string) (fs.File, error) {
+	f, err := cfs.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	cfs.open++
+	return closeCountingFile{f, f.(io.Seeker), cfs}, nil
+}
+
+type closeCountingFile struct {
+	fs.File
+	io.Seeker
+	fs *closeCountingFS
+}
+
+func (f closeCountingFile) Close() error {
+	f.fs.open--
+	return f.File.Close()
+}
+
+func TestFileClosedWhenReplaced(t *testing.T) {
+	fsys := &closeCountingFS{FS: staticFS}
+	handler := Handler(func(r Request) Response {
+		rsp := File(r, fsys, "hello.txt")
+		rsp.ETag = "v1"
+		return rsp
+	}).With(Conditional(ConditionalOptions{}))
+
+	for _, testCase := range []struct {
+		Header, Value string
+		WantedStatus  int
+	}{
+		{"If-None-Match", `"v0"`, 200},
+		{"If-None-Match", `"v1"`, 304},
+		{"If-Match", `"v0"`, 412},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(testCase.Header, testCase.Value)
+		w := httptest.NewRecorder()
+		handler.HTTP(testsupport.TestLog(t))(w, req)
+		if w.Code != testCase.WantedStatus {
+			t.Fatalf(
+				"Wanted status `%d`; found `%d`",
+				testCase.WantedStatus,
+				w.Code,
+			)
+		}
+		if fsys.open != 0 {
+			t.Fatalf(
+				"Wanted the file closed after a %d; found it open",
+				testCase.WantedStatus,
+			)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
@@ -98,6 +99,33 @@ func TestHelpers(t *testing.T) {
 			Logging: []interface{}{"logs", "go", "here"},
 			Headers: http.Header{"Location": []string{"http://yahoo.com"}},
 		},
+	}, {
+		Name:   "moved-permanently",
+		Actual: MovedPermanently("/new"),
+		Wanted: Response{
+			Status:  301,
+			Data:    String("301 Moved Permanently"),
+			Logging: nil,
+			Headers: http.Header{"Location": []string{"/new"}},
+		},
+	}, {
+		Name:   "found",
+		Actual: Found("/elsewhere", "logging"),
+		Wanted: Response{
+			Status:  302,
+			Data:    String("302 Found"),
+			Logging: []interface{}{"logging"},
+			Headers: http.Header{"Location": []string{"/elsewhere"}},
+		},
+	}, {
+		Name:   "permanent-redirect",
+		Actual: PermanentRedirect("/new"),
+		Wanted: Response{
+			Status:  308,
+			Data:    String("308 Permanent Redirect"),
+			Logging: nil,
+			Headers: http.Header{"Location": []string{"/new"}},
+		},
 	}, {
 		Name:   "bad-request",
 		Actual: BadRequest(String("400 BAD REQUEST")),
@@ -146,6 +174,14 @@ func TestHelpers(t *testing.T) {
 			Data:    String("401 Unauthorized"),
 			Logging: []interface{}{"some", "logs", "here"},
 		},
+	}, {
+		Name:   "forbidden-nil-data",
+		Actual: Forbidden(nil),
+		Wanted: Response{
+			Status:  403,
+			Data:    String("403 Forbidden"),
+			Logging: nil,
+		},
 	}, {
 		Name:   "not-found",
 		Actual: NotFound(String("404 NOT FOUND")),
@@ -170,6 +206,63 @@ func TestHelpers(t *testing.T) {
 			Data:    String("404 Not Found"),
 			Logging: []interface{}{"logging"},
 		},
+	}, {
+		Name:   "not-modified",
+		Actual: NotModified("logging"),
+		Wanted: Response{
+			Status:  304,
+			Data:    String(""),
+			Logging: []interface{}{"logging"},
+		},
+	}, {
+		Name:   "method-not-allowed",
+		Actual: MethodNotAllowed([]string{"GET", "HEAD"}, nil),
+		Wanted: Response{
+			Status:  405,
+			Data:    String("405 Method Not Allowed"),
+			Logging: nil,
+			Headers: http.Header{"Allow": []string{"GET", "HEAD"}},
+		},
+	}, {
+		Name:   "gone",
+		Actual: Gone(String("it's gone"), "logging"),
+		Wanted: Response{
+			Status:  410,
+			Data:    String("it's gone"),
+			Logging: []interface{}{"logging"},
+		},
+	}, {
+		Name:   "not-acceptable-nil-data",
+		Actual: NotAcceptable(nil),
+		Wanted: Response{
+			Status:  406,
+			Data:    String("406 Not Acceptable"),
+			Logging: nil,
+		},
+	}, {
+		Name:   "precondition-failed-nil-data",
+		Actual: PreconditionFailed(nil),
+		Wanted: Response{
+			Status:  412,
+			Data:    String("412 Precondition Failed"),
+			Logging: nil,
+		},
+	}, {
+		Name:   "unprocessable-entity-nil-data",
+		Actual: UnprocessableEntity(nil),
+		Wanted: Response{
+			Status:  422,
+			Data:    String("422 Unprocessable Entity"),
+			Logging: nil,
+		},
+	}, {
+		Name:   "too-many-requests-nil-data",
+		Actual: TooManyRequests(nil),
+		Wanted: Response{
+			Status:  429,
+			Data:    String("429 Too Many Requests"),
+			Logging: nil,
+		},
 	}, {
 		Name:   "internal-server-error",
 		Actual: InternalServerError(),
@@ -186,6 +279,14 @@ func TestHelpers(t *testing.T) {
 			Data:    String("500 Internal Server Error"),
 			Logging: []interface{}{"logging", "goes", "here"},
 		},
+	}, {
+		Name:   "service-unavailable-nil-data",
+		Actual: ServiceUnavailable(nil),
+		Wanted: Response{
+			Status:  503,
+			Data:    String("503 Service Unavailable"),
+			Logging: nil,
+		},
 	}}
 
 	for _, testCase := range testCases {
@@ -200,6 +301,80 @@ func TestHelpers(t *testing.T) {
 	}
 }
 
+func TestProblemErrorBody(t *testing.T) {
+	// Middleware which returns a default error body before Compress sees it
+	denied := func(next Handler) Handler {
+		return func(r Request) Response {
+			if r.URL.Path == "/denied" {
+				return Unauthorized(nil)
+			}
+			return next(r)
+		}
+	}
+	router := NewRouter()
+	router.ErrorBody = ProblemErrorBody
+	router.Register(func(interface{}) {}, Group([]Route{{
+		Method:  "GET",
+		Path:    "/{path}",
+		Handler: func(Request) Response { return NotFound(nil) },
+	}}, Compress(CompressOptions{}), denied)...)
+
+	for _, testCase := range []struct {
+		Path   string
+		Status int
+		Wanted string
+	}{{
+		Path:   "/missing",
+		Status: 404,
+		Wanted: `{"type":"about:blank","title":"Not Found","status":404}`,
+	}, {
+		Path:   "/denied",
+		Status: 401,
+		Wanted: `{"type":"about:blank","title":"Unauthorized",` +
+			`"status":401}`,
+	}} {
+		req := httptest.NewRequest("GET", testCase.Path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != testCase.Status {
+			t.Fatalf(
+				"%s: wanted status `%d`; found `%d`",
+				testCase.Path,
+				testCase.Status,
+				w.Code,
+			)
+		}
+		contentType := w.Header().Get("Content-Type")
+		if contentType != "application/problem+json" {
+			t.Fatalf(
+				"%s: wanted Content-Type `application/problem+json`; "+
+					"found `%s`",
+				testCase.Path,
+				contentType,
+			)
+		}
+		if body := w.Body.String(); body != testCase.Wanted {
+			t.Fatalf(
+				"%s: wanted body `%s`; found `%s`",
+				testCase.Path,
+				testCase.Wanted,
+				body,
+			)
+		}
+	}
+
+	// Handlers served without a router keep the plain text body
+	w := httptest.NewRecorder()
+	Handler(func(Request) Response { return NotFound(nil) }).HTTP(
+		func(interface{}) {},
+	)(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); body != "404 Not Found" {
+		t.Fatalf("Wanted body `404 Not Found`; found `%s`", body)
+	}
+}
+
 func compareResponses(wanted, actual Response) error {
 	if wanted.Status != actual.Status {
 		return fmt.Errorf(
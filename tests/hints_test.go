@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+func TestEarlyHintsAndTrailers(t *testing.T) {
+	var logs []string
+	server := httptest.NewServer(NewRouter().Register(
+		func(v interface{}) {
+			data, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			logs = append(logs, string(data))
+		},
+		Route{
+			Method: "GET",
+			Path:   "/",
+			Handler: func(r Request) Response {
+				err := r.EarlyHints(Preload("/app.css", "style"))
+				if err != nil {
+					return HandleError("sending early hints", err)
+				}
+
+				// The digest is computed as the body is written
+				hash := sha256.New()
+				rsp := Ok(func() (io.WriterTo, error) {
+					return writerToFunc(func(w io.Writer) (int64, error) {
+						w = io.MultiWriter(w, hash)
+						n, err := io.WriteString(w, "hello")
+						return int64(n), err
+					}), nil
+				})
+				rsp.Trailers = func() http.Header {
+					sum := hex.EncodeToString(hash.Sum(nil))
+					return http.Header{"Digest": []string{sum}}
+				}
+				return rsp
+			},
+		},
+	))
+	defer server.Close()
+
+	var hints []textproto.MIMEHeader
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	req = req.WithContext(httptrace.WithClientTrace(
+		req.Context(),
+		&httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				if code == http.StatusEarlyHints {
+					hints = append(hints, header)
+				}
+				return nil
+			},
+		},
+	))
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer rsp.Body.Close()
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if string(body) != "hello" {
+		t.Fatalf("Wanted body `hello`; found `%s`", body)
+	}
+	wantedLink := "</app.css>; rel=preload; as=style"
+	if len(hints) != 1 || hints[0].Get("Link") != wantedLink {
+		t.Fatalf(
+			"Wanted early hint `Link: %s`; found `%v`",
+			wantedLink,
+			hints,
+		)
+	}
+	if link := rsp.Header.Get("Link"); link != "" {
+		t.Fatalf("Wanted no `Link` header on the response; found `%s`", link)
+	}
+
+	sum := sha256.Sum256([]byte("hello"))
+	wantedDigest := hex.EncodeToString(sum[:])
+	if digest := rsp.Trailer.Get("Digest"); digest != wantedDigest {
+		t.Fatalf(
+			"Wanted `Digest` trailer `%s`; found `%s`",
+			wantedDigest,
+			digest,
+		)
+	}
+
+	log := strings.Join(logs, "\n")
+	for _, wanted := range []string{
+		`"earlyHints":[{"Link":[`,
+		"rel=preload; as=style",
+		`"trailers":{"Digest":["` + wantedDigest + `"]}`,
+	} {
+		if !strings.Contains(log, wanted) {
+			t.Fatalf("Wanted log containing `%s`; found:\n%s", wanted, log)
+		}
+	}
+}
+
+func TestEarlyHintsUnavailable(t *testing.T) {
+	err := (Request{}).EarlyHints("</app.css>")
+	if err != ErrEarlyHintsUnavailable {
+		t.Fatalf("Wanted `ErrEarlyHintsUnavailable`; found `%v`", err)
+	}
+}
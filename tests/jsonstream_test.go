@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+func TestJSONStream(t *testing.T) {
+	testCases := []struct {
+		Name             string
+		Serializer       func(Request) Serializer
+		WantedBody       string
+		WantedWriteError string
+		WantedFlushed    bool
+	}{{
+		Name: "array",
+		Serializer: func(Request) Serializer {
+			return JSONArray(valuesOf(1, "two", map[string]int{"three": 3}))
+		},
+		WantedBody: `[1,"two",{"three":3}]`,
+	}, {
+		Name:       "empty-array",
+		Serializer: func(Request) Serializer { return JSONArray(valuesOf()) },
+		WantedBody: `[]`,
+	}, {
+		Name: "channel",
+		Serializer: func(r Request) Serializer {
+			ch := make(chan interface{}, 3)
+			ch <- 1
+			ch <- 2
+			ch <- 3
+			close(ch)
+			return JSONChannel(r, ch)
+		},
+		WantedBody: `[1,2,3]`,
+	}, {
+		Name: "rows",
+		Serializer: func(r Request) Serializer {
+			rows := &fakeRows{values: []string{"a", "b"}}
+			return JSONRows(r, rows, func() (interface{}, error) {
+				return rows.current, nil
+			})
+		},
+		WantedBody: `["a","b"]`,
+	}, {
+		Name: "rows-error",
+		Serializer: func(r Request) Serializer {
+			rows := &fakeRows{values: []string{"a"}, err: sentinelErr}
+			return JSONRows(r, rows, func() (interface{}, error) {
+				return rows.current, nil
+			})
+		},
+		WantedBody:       `["a"`,
+		WantedWriteError: `"error":"streaming item 1: Sentinel error"`,
+	}, {
+		Name: "object",
+		Serializer: func(Request) Serializer {
+			return JSONObjectStream(
+				map[string]interface{}{"total": 2, "page": 1},
+				"items",
+				valuesOf("x", "y"),
+			)
+		},
+		WantedBody: `{"page":1,"total":2,"items":["x","y"]}`,
+	}, {
+		Name: "flushes",
+		Serializer: func(Request) Serializer {
+			values := make([]interface{}, 150)
+			for i := range values {
+				values[i] = 0
+			}
+			return JSONArray(valuesOf(values...))
+		},
+		WantedBody: "[" + strings.TrimSuffix(
+			strings.Repeat("0,", 150),
+			",",
+		) + "]",
+		WantedFlushed: true,
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var logs []json.RawMessage
+			w := httptest.NewRecorder()
+			Handler(func(r Request) Response {
+				return Ok(testCase.Serializer(r))
+			}).HTTP(func(v interface{}) {
+				data, err := json.Marshal(v)
+				if err != nil {
+					t.Fatal("Unexpected error:", err)
+				}
+				logs = append(logs, data)
+			})(w, httptest.NewRequest("GET", "/", nil))
+
+			if body := w.Body.String(); body != testCase.WantedBody {
+				t.Fatalf(
+					"Wanted body `%s`; found `%s`",
+					testCase.WantedBody,
+					body,
+				)
+			}
+			found := w.Header().Get("Content-Type")
+			if found != "application/json" {
+				t.Fatalf(
+					"Wanted Content-Type `application/json`; found `%s`",
+					found,
+				)
+			}
+			if testCase.WantedFlushed && !w.Flushed {
+				t.Fatal("Wanted the response to be flushed")
+			}
+
+			var log struct {
+				WriteError json.RawMessage `json:"writeError"`
+			}
+			if err := json.Unmarshal(logs[0], &log); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			wanted := testCase.WantedWriteError
+			if wanted == "" {
+				wanted = "null"
+			}
+			if !strings.Contains(string(log.WriteError), wanted) {
+				t.Fatalf(
+					"Wanted write error containing `%s`; found `%s`",
+					wanted,
+					log.WriteError,
+				)
+			}
+		})
+	}
+}
+
+func TestJSONStreamReplaced(t *testing.T) {
+	// Middleware which rejects the request after the handler has built its
+	// streaming response
+	reject := func(next Handler) Handler {
+		return func(r Request) Response {
+			next(r)
+			return Unauthorized(nil)
+		}
+	}
+
+	rows := &fakeRows{values: []string{"a", "b"}}
+	ch := make(chan interface{})
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for i := 0; i < 3; i++ {
+			ch <- i
+		}
+		close(ch)
+	}()
+
+	for _, serializer := range []func(Request) Serializer{
+		func(r Request) Serializer {
+			return JSONRows(r, rows, func() (interface{}, error) {
+				return rows.current, nil
+			})
+		},
+		func(r Request) Serializer { return JSONChannel(r, ch) },
+	} {
+		w := httptest.NewRecorder()
+		Handler(func(r Request) Response {
+			return Ok(serializer(r))
+		}).With(reject).HTTP(func(interface{}) {})(
+			w,
+			httptest.NewRequest("GET", "/", nil),
+		)
+		if w.Code != 401 {
+			t.Fatalf("Wanted status `401`; found `%d`", w.Code)
+		}
+	}
+
+	if !rows.closed {
+		t.Fatal("Wanted the rows to be closed")
+	}
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("Wanted the channel to be drained")
+	}
+}
+
+func TestStreamErrorUnwrap(t *testing.T) {
+	err := error(&StreamError{Index: 3, Err: sentinelErr})
+	if !errors.Is(err, sentinelErr) {
+		t.Fatalf("Wanted `%v` to wrap the sentinel error", err)
+	}
+}
+
+type fakeRows struct {
+	values  []string
+	current string
+	err     error
+	closed  bool
+}
+
+func (rows *fakeRows) Next() bool {
+	if len(rows.values) < 1 {
+		return false
+	}
+	rows.current, rows.values = rows.values[0], rows.values[1:]
+	return true
+}
+
+func (rows *fakeRows) Err() error { return rows.err }
+
+func (rows *fakeRows) Close() error {
+	rows.closed = true
+	return nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/weberc2/httpeasy"
+	"github.com/weberc2/httpeasy/testsupport"
+)
+
+// jwtKeys generates a signing key for each supported algorithm.
+func jwtKeys(t *testing.T) []JWTKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return []JWTKey{
+		{ID: "hs", Key: []byte("0123456789abcdef0123456789abcdef")},
+		{ID: "rs", Key: rsaKey},
+		{ID: "es", Key: ecKey},
+		{ID: "ed", Key: edKey},
+	}
+}
+
+// serveBearer serves a request with a bearer token.
+func serveBearer(handler Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.HTTP(func(interface{}) {})(w, req)
+	return w
+}
+
+type jwtUser struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email"`
+	Roles   []string `json:"roles"`
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	keys := jwtKeys(t)
+	now := time.Unix(1700000000, 0)
+	verifier := &JWTVerifier{
+		Keys:     StaticJWTKeys(keys),
+		Issuer:   "https://auth.example.com",
+		Audience: "orders",
+		Now:      func() time.Time { return now },
+	}
+	handler := Handler(func(r Request) Response {
+		var user jwtUser
+		if err := r.Claims(&user); err != nil {
+			return HandleError("Decoding claims", err)
+		}
+		return Ok(String(user.Subject + " " + user.Email + " " +
+			strings.Join(user.Roles, ",")))
+	}).With(Authenticate(BearerAuth{Realm: "api", Verify: verifier.Verify}))
+
+	for _, key := range keys {
+		t.Run(key.ID, func(t *testing.T) {
+			token, err := JWTSigner{
+				Key:    key,
+				Issuer: "https://auth.example.com",
+				TTL:    time.Hour,
+				Now:    func() time.Time { return now },
+			}.Sign(map[string]interface{}{
+				"sub":   "u1",
+				"aud":   []string{"billing", "orders"},
+				"email": "u1@example.com",
+				"roles": []string{"admin"},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			w := serveBearer(handler, token)
+			if w.Code != 200 {
+				t.Fatalf("Wanted status `200`; found `%d`", w.Code)
+			}
+			if wanted := "u1 u1@example.com admin"; w.Body.String() != wanted {
+				t.Fatalf("Wanted `%s`; found `%s`", wanted, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestJWTVerifierRejects(t *testing.T) {
+	keys := jwtKeys(t)
+	now := time.Unix(1700000000, 0)
+	sign := func(key JWTKey, claims map[string]interface{}) string {
+		token, err := JWTSigner{
+			Key: key,
+			Now: func() time.Time { return now },
+		}.Sign(claims)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return token
+	}
+	valid := func(overrides ...interface{}) map[string]interface{} {
+		claims := map[string]interface{}{
+			"sub": "u1",
+			"iss": "issuer",
+			"aud": "orders",
+			"exp": now.Add(time.Minute).Unix(),
+		}
+		for i := 0; i+1 < len(overrides); i += 2 {
+			claims[overrides[i].(string)] = overrides[i+1]
+		}
+		return claims
+	}
+	tampered := sign(keys[2], valid())
+	parts := strings.Split(tampered, ".")
+	tampered = parts[0] + "." + strings.Split(
+		sign(keys[2], valid("sub", "admin")),
+		".",
+	)[1] + "." + parts[2]
+
+	// A token claiming HS256 keyed with the RSA key's ID mustn't be verified
+	// using the public key as an HMAC secret
+	confused := sign(JWTKey{ID: "rs", Key: []byte("public key bytes")}, valid())
+
+	testCases := []struct {
+		Name   string
+		Token  string
+		Wanted string // "" for a valid token
+	}{
+		{Name: "valid", Token: sign(keys[1], valid())},
+		{
+			Name:  "expired-within-skew",
+			Token: sign(keys[1], valid("exp", now.Add(-30*time.Second).Unix())),
+		},
+		{
+			Name:   "expired",
+			Token:  sign(keys[1], valid("exp", now.Add(-time.Hour).Unix())),
+			Wanted: "expired",
+		},
+		{
+			Name:   "not-yet-valid",
+			Token:  sign(keys[1], valid("nbf", now.Add(time.Hour).Unix())),
+			Wanted: "not valid before",
+		},
+		{
+			Name:   "wrong-issuer",
+			Token:  sign(keys[1], valid("iss", "other")),
+			Wanted: "wrong issuer",
+		},
+		{
+			Name:   "wrong-audience",
+			Token:  sign(keys[1], valid("aud", []string{"billing"})),
+			Wanted: "audience",
+		},
+		{Name: "tampered", Token: tampered, Wanted: "signature"},
+		{Name: "algorithm-confusion", Token: confused, Wanted: "signature"},
+		{Name: "malformed", Token: "not.a-jwt", Wanted: "malformed"},
+	}
+
+	verifier := &JWTVerifier{
+		Keys:     StaticJWTKeys(keys),
+		Issuer:   "issuer",
+		Audience: "orders",
+		Now:      func() time.Time { return now },
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			principal, err := verifier.Verify(testCase.Token)
+			if testCase.Wanted == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if principal.Subject != "u1" || principal.Scheme != "jwt" {
+					t.Fatalf("Wanted subject `u1`; found `%+v`", principal)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidCredentials) ||
+				!strings.Contains(err.Error(), testCase.Wanted) {
+				t.Fatalf(
+					"Wanted an invalid credentials error containing `%s`; "+
+						"found `%v`",
+					testCase.Wanted,
+					err,
+				)
+			}
+		})
+	}
+}
+
+func TestRemoteJWKS(t *testing.T) {
+	keys := jwtKeys(t)
+	server := testsupport.NewJWKSServer(keys[1], keys[2])
+	defer server.Close()
+	verifier := &JWTVerifier{Keys: &RemoteJWKS{
+		URL:                server.URL,
+		MinRefreshInterval: time.Nanosecond,
+	}}
+	verify := func(key JWTKey) error {
+		token, err := JWTSigner{Key: key, TTL: time.Minute}.Sign(
+			map[string]string{"sub": "u1"},
+		)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_, err = verifier.Verify(token)
+		return err
+	}
+
+	for _, key := range keys[1:3] {
+		if err := verify(key); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if server.Requests() != 1 {
+		t.Fatalf(
+			"Wanted the key set to be cached; found %d fetches",
+			server.Requests(),
+		)
+	}
+
+	// Unknown keys trigger a refetch, which picks up rotated keys
+	if err := verify(keys[3]); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Wanted an invalid credentials error; found `%v`", err)
+	}
+	server.SetKeys(keys[2], keys[3])
+	if err := verify(keys[3]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server.Requests() != 3 {
+		t.Fatalf("Wanted 3 fetches; found %d", server.Requests())
+	}
+
+	// Secrets can't be published
+	if _, err := MarshalJWKS(keys[0]); err == nil {
+		t.Fatal("Wanted an error publishing an HS256 secret")
+	}
+}
+
+func TestRemoteJWKSOutage(t *testing.T) {
+	var requests int64
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&requests, 1)
+			<-release
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	))
+	defer server.Close()
+	keys := &RemoteJWKS{URL: server.URL}
+
+	// Concurrent lookups share a single fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := keys.JWTKeys("k1"); err == nil {
+				t.Error("Wanted an error while the provider is down")
+			}
+		}()
+	}
+	for atomic.LoadInt64(&requests) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	// Failures aren't retried until MinRefreshInterval has passed
+	if _, err := keys.JWTKeys("k1"); err == nil {
+		t.Fatal("Wanted an error while the provider is down")
+	}
+	if found := atomic.LoadInt64(&requests); found != 1 {
+		t.Fatalf("Wanted 1 fetch; found %d", found)
+	}
+}
+
+func TestLoadJWKSFile(t *testing.T) {
+	keys := jwtKeys(t)
+	data, err := MarshalJWKS(keys[1:]...)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	loaded, err := LoadJWKSFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	verifier := &JWTVerifier{Keys: loaded}
+	for _, key := range keys[1:] {
+		token, err := JWTSigner{Key: key}.Sign(map[string]string{"sub": "u1"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := verifier.Verify(token); err != nil {
+			t.Fatalf("Key `%s`: unexpected error: %v", key.ID, err)
+		}
+	}
+}
+
+func TestRemoteJWKSRejectsSecrets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(
+				`{"keys":[{"kty":"oct","kid":"k1","k":"c2VjcmV0"}]}`,
+			))
+		},
+	))
+	defer server.Close()
+
+	keys := &RemoteJWKS{URL: server.URL}
+	if _, err := keys.JWTKeys("k1"); err == nil ||
+		!strings.Contains(err.Error(), "symmetric keys") {
+		t.Fatalf("Wanted the symmetric key to be rejected; found `%v`", err)
+	}
+}
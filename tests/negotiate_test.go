@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []Offer{
+		{MediaType: "application/json", Data: String(`{"hello":"world"}`)},
+		{MediaType: "text/html; charset=utf-8", Data: String("<p>hello</p>")},
+		{MediaType: "text/plain", Data: String("hello")},
+	}
+
+	testCases := []struct {
+		Name            string
+		Accept          []string
+		WantedStatus    int
+		WantedType      string
+		WantedSerialize string
+	}{{
+		Name:            "no-accept-header",
+		WantedStatus:    200,
+		WantedType:      "application/json",
+		WantedSerialize: `{"hello":"world"}`,
+	}, {
+		Name:            "exact",
+		Accept:          []string{"text/plain"},
+		WantedStatus:    200,
+		WantedType:      "text/plain",
+		WantedSerialize: "hello",
+	}, {
+		Name: "browser",
+		Accept: []string{
+			"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		},
+		WantedStatus:    200,
+		WantedType:      "text/html; charset=utf-8",
+		WantedSerialize: "<p>hello</p>",
+	}, {
+		Name:            "q-values",
+		Accept:          []string{"application/json;q=0.5, text/plain;q=0.7"},
+		WantedStatus:    200,
+		WantedType:      "text/plain",
+		WantedSerialize: "hello",
+	}, {
+		Name:            "subtype-wildcard",
+		Accept:          []string{"text/*"},
+		WantedStatus:    200,
+		WantedType:      "text/html; charset=utf-8",
+		WantedSerialize: "<p>hello</p>",
+	}, {
+		Name:            "more-specific-range-wins",
+		Accept:          []string{"text/*;q=0.9, text/html;q=0.1"},
+		WantedStatus:    200,
+		WantedType:      "text/plain",
+		WantedSerialize: "hello",
+	}, {
+		Name:            "params",
+		Accept:          []string{"text/html;charset=utf-8"},
+		WantedStatus:    200,
+		WantedType:      "text/html; charset=utf-8",
+		WantedSerialize: "<p>hello</p>",
+	}, {
+		Name:            "params-mismatch",
+		Accept:          []string{"text/html;charset=iso-8859-1"},
+		WantedStatus:    406,
+		WantedSerialize: "406 Not Acceptable",
+	}, {
+		Name:            "excluded",
+		Accept:          []string{"*/*, application/json;q=0, text/*;q=0"},
+		WantedStatus:    406,
+		WantedSerialize: "406 Not Acceptable",
+	}, {
+		Name:            "not-acceptable",
+		Accept:          []string{"image/png"},
+		WantedStatus:    406,
+		WantedSerialize: "406 Not Acceptable",
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			rsp := Negotiate(
+				Request{Headers: http.Header{"Accept": testCase.Accept}},
+				http.StatusOK,
+				offers...,
+			)
+			if rsp.Status != testCase.WantedStatus {
+				t.Fatalf(
+					"Wanted status `%d`; found `%d`",
+					testCase.WantedStatus,
+					rsp.Status,
+				)
+			}
+			found := rsp.Headers.Get("Content-Type")
+			if found != testCase.WantedType {
+				t.Fatalf(
+					"Wanted Content-Type `%s`; found `%s`",
+					testCase.WantedType,
+					found,
+				)
+			}
+			if vary := rsp.Headers.Get("Vary"); vary != "Accept" {
+				t.Fatalf("Wanted `Vary: Accept`; found `%s`", vary)
+			}
+			if err := compareSerializers(
+				String(testCase.WantedSerialize),
+				rsp.Data,
+			); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
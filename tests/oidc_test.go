@@ -0,0 +1,252 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/weberc2/httpeasy"
+	"github.com/weberc2/httpeasy/testsupport"
+)
+
+// oidcApp is an app which logs users in with a fake identity provider.
+type oidcApp struct {
+	t      *testing.T
+	idp    *testsupport.FakeIdP
+	server *httptest.Server
+	client *http.Client
+}
+
+func newOIDCApp(t *testing.T) *oidcApp {
+	idp := testsupport.NewFakeIdP("app", "s3cret")
+	router := NewRouter()
+	server := httptest.NewServer(router)
+	codec := &CookieCodec{Mode: Encrypted, Keys: [][]byte{newKey}}
+	login, err := NewOIDC(OIDCOptions{
+		Issuer:                idp.URL,
+		ClientID:              "app",
+		ClientSecret:          "s3cret",
+		RedirectURL:           server.URL + "/auth/callback",
+		PostLogoutRedirectURL: server.URL + "/",
+		Codec:                 codec,
+		Insecure:              true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	sessions := Sessions(SessionOptions{Store: &MemoryStore{}, Insecure: true})
+	home := func(r Request) Response {
+		if user := r.OIDCUser(); user != nil {
+			return Ok(String("Hello, " + user.Name))
+		}
+		return Ok(String("Hello, stranger"))
+	}
+	profile := func(r Request) Response {
+		user := r.OIDCUser()
+		return Ok(String(r.Principal().Subject + " " + user.Email))
+	}
+	router.Register(
+		func(interface{}) {},
+		Group(
+			append(
+				login.Routes(),
+				Route{Path: "/", Method: "GET", Handler: home},
+			),
+			sessions,
+		)...,
+	)
+	router.Register(
+		func(interface{}) {},
+		Group(
+			[]Route{{Path: "/profile", Method: "GET", Handler: profile}},
+			sessions,
+			login.RequireLogin(),
+		)...,
+	)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return &oidcApp{
+		t:      t,
+		idp:    idp,
+		server: server,
+		client: &http.Client{Jar: jar},
+	}
+}
+
+func (app *oidcApp) Close() {
+	app.server.Close()
+	app.idp.Close()
+}
+
+// do sends a request, following redirects, and returns the final status,
+// path and body.
+func (app *oidcApp) do(method, path string) (int, string, string) {
+	app.t.Helper()
+	req, err := http.NewRequest(method, app.server.URL+path, nil)
+	if err != nil {
+		app.t.Fatalf("Unexpected error: %v", err)
+	}
+	rsp, err := app.client.Do(req)
+	if err != nil {
+		app.t.Fatalf("Unexpected error: %v", err)
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		app.t.Fatalf("Unexpected error: %v", err)
+	}
+	return rsp.StatusCode, rsp.Request.URL.Path, string(body)
+}
+
+func (app *oidcApp) want(
+	method string,
+	path string,
+	wantedStatus int,
+	wantedPath string,
+	wantedBody string,
+) {
+	app.t.Helper()
+	status, finalPath, body := app.do(method, path)
+	if status != wantedStatus || finalPath != wantedPath ||
+		!strings.Contains(body, wantedBody) {
+		app.t.Fatalf(
+			"%s %s: wanted `%d` at `%s` with `%s`; found `%d` at `%s` "+
+				"with `%s`",
+			method,
+			path,
+			wantedStatus,
+			wantedPath,
+			wantedBody,
+			status,
+			finalPath,
+			body,
+		)
+	}
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	app := newOIDCApp(t)
+	defer app.Close()
+
+	// Visiting a protected page logs the user in and returns them to it
+	app.want("GET", "/", 200, "/", "Hello, stranger")
+	app.want("GET", "/profile", 200, "/profile", "alice alice@example.com")
+	app.want("GET", "/", 200, "/", "Hello, Alice")
+
+	// Logging out ends the app's session and the provider's, and the
+	// provider returns the user to the app
+	app.want("POST", "/logout", 200, "/", "Hello, stranger")
+	app.client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	rsp, err := app.client.Get(app.server.URL + "/profile")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rsp.Body.Close()
+	if found := rsp.Header.Get("Location"); found !=
+		"/login?return_to=%2Fprofile" {
+		t.Fatalf("Wanted a redirect to log in; found `%s`", found)
+	}
+	app.client.CheckRedirect = nil
+
+	// Logins can't redirect off-site
+	for _, returnTo := range []string{
+		"//evil.example/",
+		"/\\evil.example/",
+		"/\t/evil.example/",
+		"/\r\n/evil.example/",
+		"https://evil.example/",
+	} {
+		app.want(
+			"GET",
+			"/login?return_to="+url.QueryEscape(returnTo),
+			200,
+			"/",
+			"Hello, Alice",
+		)
+	}
+}
+
+func TestOIDCRejectedLogins(t *testing.T) {
+	app := newOIDCApp(t)
+	defer app.Close()
+
+	// Callbacks which the app didn't start are rejected
+	app.want("GET", "/auth/callback?code=x&state=y", 401, "/auth/callback", "")
+
+	// So are logins the provider denies
+	app.idp.SetUser(nil)
+	status, _, body := app.do("GET", "/login")
+	if status != 401 || !strings.Contains(body, "declined") {
+		t.Fatalf("Wanted a declined login; found `%d`: %s", status, body)
+	}
+
+	// A replayed callback fails: its flow cookie has been cleared and its
+	// code has been redeemed
+	app.idp.SetUser(map[string]interface{}{"sub": "bob", "name": "Bob"})
+	app.client.CheckRedirect = func(r *http.Request, _ []*http.Request) error {
+		if r.URL.Path == "/auth/callback" {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+	rsp, err := app.client.Get(app.server.URL + "/login")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rsp.Body.Close()
+	callback := rsp.Header.Get("Location")
+	app.client.CheckRedirect = nil
+	callbackURL, err := url.Parse(callback)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	app.want("GET", callbackURL.RequestURI(), 200, "/", "Hello, Bob")
+	app.want("GET", callbackURL.RequestURI(), 401, "/auth/callback", "")
+}
+
+func TestOIDCProviderOutage(t *testing.T) {
+	var requests int64
+	provider := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&requests, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	))
+	defer provider.Close()
+	login, err := NewOIDC(OIDCOptions{
+		Issuer:      provider.URL,
+		ClientID:    "app",
+		RedirectURL: "https://app.example.com/auth/callback",
+		Codec:       &CookieCodec{Mode: Encrypted, Keys: [][]byte{newKey}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	router := Register(testsupport.TestLog(t), Group(
+		login.Routes(),
+		Sessions(SessionOptions{Store: &MemoryStore{}}),
+	)...)
+
+	// Discovery failures are cached rather than retried on every login
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("Wanted status `500`; found `%d`", w.Code)
+		}
+	}
+	if found := atomic.LoadInt64(&requests); found != 1 {
+		t.Fatalf("Wanted 1 discovery request; found %d", found)
+	}
+}
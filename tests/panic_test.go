@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+type panickingWriterTo struct{}
+
+func (panickingWriterTo) WriteTo(w io.Writer) (int64, error) {
+	w.Write([]byte("partial"))
+	panic("boom")
+}
+
+func TestPanicRecovery(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Handler      Handler
+		Renderer     ErrorRenderer
+		ErrorBody    func(int) Serializer
+		Repanic      bool
+		WantedStatus int
+		WantedBody   string
+		WantedPanic  interface{}
+		WantedLogs   []string
+	}{{
+		Name:         "handler",
+		Handler:      func(Request) Response { panic("boom") },
+		WantedStatus: 500,
+		WantedBody:   "500 Internal Server Error",
+		WantedLogs: []string{
+			`"panic":"boom"`,
+			`"route":"/widgets/{id}"`,
+			"TestPanicRecovery",
+		},
+	}, {
+		Name:         "handler-problem-body",
+		Handler:      func(Request) Response { panic("boom") },
+		ErrorBody:    ProblemErrorBody,
+		WantedStatus: 500,
+		WantedBody: `{"type":"about:blank","title":"Internal Server Error",` +
+			`"status":500}`,
+		WantedLogs: []string{`"panic":"boom"`},
+	}, {
+		Name: "serializer",
+		Handler: func(Request) Response {
+			return Ok(func() (io.WriterTo, error) { panic("boom") })
+		},
+		WantedStatus: 500,
+		WantedLogs:   []string{`"panic":"boom"`},
+	}, {
+		Name: "custom-renderer",
+		Handler: func(Request) Response {
+			var m map[string]int
+			m["x"] = 1
+			return Ok(nil)
+		},
+		Renderer: func(r Request, err error) Response {
+			var runtimeErr runtime.Error
+			if !errors.As(err, &runtimeErr) {
+				return InternalServerError()
+			}
+			return ServiceUnavailable(String("runtime error"))
+		},
+		WantedStatus: 503,
+		WantedBody:   "runtime error",
+		WantedLogs:   []string{`"panic":"assignment to entry in nil map"`},
+	}, {
+		Name:         "repanic",
+		Handler:      func(Request) Response { panic("boom") },
+		Repanic:      true,
+		WantedPanic:  "boom",
+		WantedStatus: 200, // nothing was written to the recorder
+		WantedLogs:   []string{`"panic":"boom"`},
+	}, {
+		Name: "abort-handler",
+		Handler: func(Request) Response {
+			panic(http.ErrAbortHandler)
+		},
+		WantedPanic:  http.ErrAbortHandler,
+		WantedStatus: 200,
+	}, {
+		Name: "writing",
+		Handler: func(Request) Response {
+			return Ok(func() (io.WriterTo, error) {
+				return panickingWriterTo{}, nil
+			})
+		},
+		WantedPanic:  http.ErrAbortHandler,
+		WantedStatus: 200,
+		WantedBody:   "partial",
+		WantedLogs: []string{
+			`"context":"Recovered from panic writing response"`,
+			`"panic":"boom"`,
+		},
+	}, {
+		Name: "trailers",
+		Handler: func(Request) Response {
+			rsp := Ok(String("complete"))
+			rsp.Trailers = func() http.Header { panic("boom") }
+			return rsp
+		},
+		WantedPanic:  http.ErrAbortHandler,
+		WantedStatus: 200,
+		WantedBody:   "complete",
+		WantedLogs: []string{
+			`"context":"Recovered from panic computing trailers"`,
+			`"panic":"boom"`,
+		},
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var logs []string
+			router := NewRouter()
+			router.ErrorRenderer = testCase.Renderer
+			router.ErrorBody = testCase.ErrorBody
+			router.Repanic = testCase.Repanic
+			router.Register(
+				func(v interface{}) {
+					data, err := json.Marshal(v)
+					if err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					logs = append(logs, string(data))
+				},
+				Route{
+					Method:  "GET",
+					Path:    "/widgets/{id}",
+					Handler: testCase.Handler,
+				},
+			)
+
+			w := httptest.NewRecorder()
+			func() {
+				defer func() {
+					if v := recover(); v != testCase.WantedPanic {
+						t.Fatalf(
+							"Wanted panic `%v`; found `%v`",
+							testCase.WantedPanic,
+							v,
+						)
+					}
+				}()
+				router.ServeHTTP(
+					w,
+					httptest.NewRequest("GET", "/widgets/1", nil),
+				)
+			}()
+
+			if w.Code != testCase.WantedStatus {
+				t.Fatalf(
+					"Wanted status `%d`; found `%d`",
+					testCase.WantedStatus,
+					w.Code,
+				)
+			}
+			if body := w.Body.String(); !strings.Contains(
+				body,
+				testCase.WantedBody,
+			) {
+				t.Fatalf(
+					"Wanted body containing `%s`; found `%s`",
+					testCase.WantedBody,
+					body,
+				)
+			}
+
+			log := strings.Join(logs, "\n")
+			for _, wanted := range testCase.WantedLogs {
+				if !strings.Contains(log, wanted) {
+					t.Fatalf(
+						"Wanted log containing `%s`; found:\n%s",
+						wanted,
+						log,
+					)
+				}
+			}
+		})
+	}
+}
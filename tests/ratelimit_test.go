@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/weberc2/httpeasy"
+	"github.com/weberc2/httpeasy/testsupport"
+)
+
+// rateLimitTest drives a handler wrapped in the `RateLimit` middleware with a
+// fake clock.
+type rateLimitTest struct {
+	*testsupport.FakeClock
+	t       *testing.T
+	handler Handler
+}
+
+func newRateLimitTest(
+	t *testing.T,
+	options RateLimitOptions,
+) *rateLimitTest {
+	test := &rateLimitTest{
+		FakeClock: testsupport.NewFakeClock(time.Unix(1700000000, 0)),
+		t:         t,
+	}
+	options.Now = test.Now
+	test.handler = Handler(func(Request) Response {
+		return Ok(String("ok"))
+	}).With(RateLimit(options))
+	return test
+}
+
+// get serves a request from `remoteAddr` with the provided headers
+// (alternating names and values) and returns the response and request log.
+func (test *rateLimitTest) get(
+	remoteAddr string,
+	headers ...string,
+) (*httptest.ResponseRecorder, string) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteAddr
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	var logs []string
+	w := httptest.NewRecorder()
+	test.handler.HTTP(func(v interface{}) {
+		data, _ := json.Marshal(v)
+		logs = append(logs, string(data))
+	})(w, req)
+	return w, strings.Join(logs, "\n")
+}
+
+// want checks the status and rate limit headers of a response.
+func (test *rateLimitTest) want(
+	w *httptest.ResponseRecorder,
+	status int,
+	remaining string,
+	retryAfter string,
+) {
+	test.t.Helper()
+	if w.Code != status {
+		test.t.Fatalf("Wanted status `%d`; found `%d`", status, w.Code)
+	}
+	if found := w.Header().Get("RateLimit-Remaining"); found != remaining {
+		test.t.Fatalf(
+			"Wanted `RateLimit-Remaining: %s`; found `%s`",
+			remaining,
+			found,
+		)
+	}
+	if found := w.Header().Get("Retry-After"); found != retryAfter {
+		test.t.Fatalf("Wanted `Retry-After: %s`; found `%s`", retryAfter, found)
+	}
+}
+
+func TestRateLimitTokenBucket(t *testing.T) {
+	test := newRateLimitTest(t, RateLimitOptions{
+		Algorithm: TokenBucket{Limit: 3, Period: 3 * time.Second},
+	})
+
+	for _, remaining := range []string{"2", "1", "0"} {
+		w, _ := test.get("192.0.2.1:1234")
+		test.want(w, 200, remaining, "")
+	}
+	w, log := test.get("192.0.2.1:1234")
+	test.want(w, 429, "0", "1")
+	for _, header := range []string{"RateLimit-Limit", "RateLimit-Policy"} {
+		if w.Header().Get(header) == "" {
+			t.Fatalf("Wanted a `%s` header", header)
+		}
+	}
+	if !strings.Contains(log, `"context":"Rate limit exceeded"`) ||
+		!strings.Contains(log, `"key":"ip:192.0.2.1"`) ||
+		!strings.Contains(log, `"remaining":0`) {
+		t.Fatalf("Wanted the rejection to be logged; found:\n%s", log)
+	}
+
+	// Other clients have their own buckets
+	w, _ = test.get("192.0.2.2:1234")
+	test.want(w, 200, "2", "")
+
+	// Tokens refill steadily
+	test.Advance(time.Second)
+	w, _ = test.get("192.0.2.1:5678")
+	test.want(w, 200, "0", "")
+	test.Advance(time.Hour)
+	w, _ = test.get("192.0.2.1:5678")
+	test.want(w, 200, "2", "")
+}
+
+func TestRateLimitSlidingWindow(t *testing.T) {
+	test := newRateLimitTest(t, RateLimitOptions{
+		Algorithm: SlidingWindow{Limit: 4, Window: 10 * time.Second},
+		Key:       RateLimitByHeader("X-API-Key"),
+	})
+
+	for _, remaining := range []string{"3", "2", "1", "0"} {
+		w, _ := test.get("192.0.2.1:1234", "X-API-Key", "alpha")
+		test.want(w, 200, remaining, "")
+	}
+
+	// The window is full until the previous window's weighted count drops
+	// below the limit: 4 * (1 - 2.5s/10s) = 3
+	w, log := test.get("192.0.2.1:1234", "X-API-Key", "alpha")
+	test.want(w, 429, "0", "13")
+
+	// The header's value is hashed before it's used as the key
+	if !strings.Contains(log, `"key":"header:`) ||
+		strings.Contains(log, `"key":"header:alpha"`) {
+		t.Fatalf("Wanted a hashed key in the log; found:\n%s", log)
+	}
+
+	// Keys are independent of the client's address
+	w, _ = test.get("192.0.2.1:1234", "X-API-Key", "beta")
+	test.want(w, 200, "3", "")
+
+	test.Advance(12500 * time.Millisecond)
+	w, _ = test.get("192.0.2.9:1234", "X-API-Key", "alpha")
+	test.want(w, 200, "0", "")
+
+	// 4 * (1 - 2.5s/10s) + 1 = 4, which drops to 3 at 5s: 2.5s from now
+	w, _ = test.get("192.0.2.9:1234", "X-API-Key", "alpha")
+	test.want(w, 429, "0", "3")
+}
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Update(
+	string,
+	time.Time,
+	time.Duration,
+	func([]byte) []byte,
+) error {
+	return errors.New("connection refused")
+}
+
+func TestMemoryRateLimitStoreExpiry(t *testing.T) {
+	// The store expires state by the clock it's passed, however far that
+	// is from the real one
+	var store MemoryRateLimitStore
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, testCase := range []struct {
+		Now    time.Time
+		Wanted string
+	}{
+		{Now: start, Wanted: ""},
+		{Now: start.Add(59 * time.Second), Wanted: "a"},
+		{Now: start.Add(2 * time.Minute), Wanted: ""},
+	} {
+		var found string
+		err := store.Update(
+			"key",
+			testCase.Now,
+			time.Minute,
+			func(state []byte) []byte {
+				found = string(state)
+				return []byte("a")
+			},
+		)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if found != testCase.Wanted {
+			t.Fatalf(
+				"At %v: wanted state `%s`; found `%s`",
+				testCase.Now,
+				testCase.Wanted,
+				found,
+			)
+		}
+	}
+}
+
+func TestRateLimitExemptionsAndFailures(t *testing.T) {
+	test := newRateLimitTest(t, RateLimitOptions{
+		Algorithm: TokenBucket{Limit: 1, Period: time.Minute},
+		Key: func(r Request) string {
+			if r.Headers.Get("X-Internal") != "" {
+				return ""
+			}
+			return RateLimitByIP(r)
+		},
+	})
+	for i := 0; i < 3; i++ {
+		w, _ := test.get("192.0.2.1:1234", "X-Internal", "1")
+		test.want(w, 200, "", "")
+	}
+
+	test = newRateLimitTest(t, RateLimitOptions{
+		Algorithm: TokenBucket{Limit: 1, Period: time.Minute},
+		Store:     failingRateLimitStore{},
+	})
+	w, log := test.get("192.0.2.1:1234")
+	test.want(w, 200, "", "")
+	if !strings.Contains(log, "connection refused") {
+		t.Fatalf("Wanted the store failure to be logged; found:\n%s", log)
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	limit := func(key func(Request) string) Middleware {
+		return RateLimit(RateLimitOptions{
+			Algorithm: TokenBucket{Limit: 1, Period: time.Minute},
+			Key:       key,
+		})
+	}
+	ok := func(Request) Response { return Ok(String("ok")) }
+	router := Register(
+		func(interface{}) {},
+		Group(
+			[]Route{{Method: "GET", Path: "/principal", Handler: ok}},
+			Authenticate(BearerAuth{
+				Verify: func(token string) (*Principal, error) {
+					return &Principal{Subject: token}, nil
+				},
+			}),
+			limit(RateLimitByPrincipal),
+		)...,
+	).Register(
+		func(interface{}) {},
+		Group(
+			[]Route{
+				{Method: "GET", Path: "/widgets/{id}", Handler: ok},
+				{Method: "GET", Path: "/gadgets/{id}", Handler: ok},
+			},
+			limit(RateLimitByRoute),
+		)...,
+	)
+
+	testCases := []struct {
+		Path         string
+		RemoteAddr   string
+		Token        string
+		WantedStatus int
+	}{
+		// Principals are limited wherever they connect from
+		{"/principal", "192.0.2.1:1234", "alice", 200},
+		{"/principal", "192.0.2.2:1234", "alice", 429},
+		{"/principal", "192.0.2.1:1234", "bob", 200},
+
+		// Routes are limited whatever their path variables
+		{"/widgets/1", "192.0.2.1:1234", "", 200},
+		{"/widgets/2", "192.0.2.2:1234", "", 429},
+		{"/gadgets/1", "192.0.2.1:1234", "", 200},
+	}
+	for _, testCase := range testCases {
+		req := httptest.NewRequest("GET", testCase.Path, nil)
+		req.RemoteAddr = testCase.RemoteAddr
+		if testCase.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+testCase.Token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != testCase.WantedStatus {
+			t.Fatalf(
+				"%s as `%s`: wanted status `%d`; found `%d`",
+				testCase.Path,
+				testCase.Token,
+				testCase.WantedStatus,
+				w.Code,
+			)
+		}
+	}
+}
+
+func TestRateLimitOptionsValidated(t *testing.T) {
+	for _, options := range []RateLimitOptions{
+		{},
+		{Algorithm: TokenBucket{Limit: 0, Period: time.Minute}},
+		{Algorithm: TokenBucket{Limit: 1}},
+		{Algorithm: SlidingWindow{Limit: -1, Window: time.Minute}},
+		{Algorithm: SlidingWindow{Limit: 1}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Wanted `%#v` to be rejected", options.Algorithm)
+				}
+			}()
+			RateLimit(options)
+		}()
+	}
+}
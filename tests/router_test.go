@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+func TestRouterErrors(t *testing.T) {
+	failing := func() (io.WriterTo, error) {
+		return nil, errors.New("marshaling widget")
+	}
+
+	testCases := []struct {
+		Name          string
+		Method        string
+		Path          string
+		Body          string
+		Renderer      ErrorRenderer
+		ErrorBody     func(int) Serializer
+		WantedStatus  int
+		WantedBody    string
+		WantedHeaders http.Header
+		WantedAbsent  []string
+		WantedLogs    []string
+	}{{
+		Name:         "not-found",
+		Method:       "GET",
+		Path:         "/gadgets",
+		WantedStatus: 404,
+		WantedBody:   "404 Not Found",
+		WantedLogs:   []string{`"status":404`},
+	}, {
+		Name:         "not-found-problem",
+		Method:       "GET",
+		Path:         "/gadgets",
+		ErrorBody:    ProblemErrorBody,
+		WantedStatus: 404,
+		WantedBody:   `"title":"Not Found"`,
+		WantedHeaders: http.Header{
+			"Content-Type": []string{"application/problem+json"},
+		},
+	}, {
+		Name:         "not-found-custom-renderer",
+		Method:       "GET",
+		Path:         "/gadgets",
+		Renderer:     DefaultErrorRenderer,
+		WantedStatus: 404,
+		WantedBody:   `"title":"Not Found"`,
+	}, {
+		Name:         "method-not-allowed",
+		Method:       "DELETE",
+		Path:         "/widgets",
+		WantedStatus: 405,
+		WantedHeaders: http.Header{
+			"Allow": []string{"GET", "POST"},
+		},
+	}, {
+		Name:         "body-too-large",
+		Method:       "POST",
+		Path:         "/widgets",
+		Body:         strings.Repeat("x", 17),
+		WantedStatus: 413,
+		WantedBody:   "413 Request Entity Too Large",
+		WantedLogs:   []string{"The request body exceeds 16 bytes."},
+	}, {
+		Name:         "body-within-limit",
+		Method:       "POST",
+		Path:         "/widgets",
+		Body:         strings.Repeat("x", 16),
+		WantedStatus: 201,
+	}, {
+		Name:         "serializer-failure",
+		Method:       "GET",
+		Path:         "/widgets",
+		WantedStatus: 500,
+		WantedBody:   "500 Internal Server Error",
+		WantedAbsent: []string{"Etag", "Set-Cookie", "X-Widget-Count"},
+		WantedLogs: []string{
+			`"message":["fetched widgets",`,
+			`"context":"Error serializing response data"`,
+		},
+	}, {
+		Name:   "custom-renderer",
+		Method: "GET",
+		Path:   "/widgets",
+		Renderer: func(r Request, err error) Response {
+			var serializerErr *SerializerError
+			if errors.As(err, &serializerErr) {
+				return InternalServerError().WithHeaders(http.Header{
+					"Content-Type": []string{"text/html; charset=utf-8"},
+				})
+			}
+			return DefaultErrorRenderer(r, err)
+		},
+		WantedStatus: 500,
+		WantedHeaders: http.Header{
+			"Content-Type": []string{"text/html; charset=utf-8"},
+		},
+		WantedAbsent: []string{"X-Widget-Count"},
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var logs []string
+			router := NewRouter()
+			router.ErrorRenderer = testCase.Renderer
+			router.ErrorBody = testCase.ErrorBody
+			router.MaxBodyBytes = 16
+			router.Log = func(v interface{}) {
+				data, err := json.Marshal(v)
+				if err != nil {
+					t.Fatal("Unexpected error:", err)
+				}
+				logs = append(logs, string(data))
+			}
+			router.Register(
+				router.Log,
+				Route{
+					Method: "GET",
+					Path:   "/widgets",
+					Handler: func(Request) Response {
+						rsp := Ok(failing, "fetched widgets").
+							WithHeaders(http.Header{
+								"X-Widget-Count": []string{"3"},
+							}).
+							WithCookies(&http.Cookie{Name: "a", Value: "b"})
+						rsp.ETag = "v1"
+						return rsp
+					},
+				},
+				Route{
+					Method: "POST",
+					Path:   "/widgets",
+					Handler: func(Request) Response {
+						return Created(nil)
+					},
+				},
+			)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(
+				testCase.Method,
+				testCase.Path,
+				strings.NewReader(testCase.Body),
+			))
+
+			if w.Code != testCase.WantedStatus {
+				t.Fatalf(
+					"Wanted status `%d`; found `%d`",
+					testCase.WantedStatus,
+					w.Code,
+				)
+			}
+			if body := w.Body.String(); !strings.Contains(
+				body,
+				testCase.WantedBody,
+			) {
+				t.Fatalf(
+					"Wanted body containing `%s`; found `%s`",
+					testCase.WantedBody,
+					body,
+				)
+			}
+			for key, wanted := range testCase.WantedHeaders {
+				found := strings.Join(w.Header()[key], ", ")
+				if found != strings.Join(wanted, ", ") {
+					t.Fatalf(
+						"Wanted header `%s: %s`; found `%s`",
+						key,
+						strings.Join(wanted, ", "),
+						found,
+					)
+				}
+			}
+			for _, key := range testCase.WantedAbsent {
+				if values, ok := w.Header()[key]; ok {
+					t.Fatalf("Wanted no `%s` header; found `%v`", key, values)
+				}
+			}
+
+			log := strings.Join(logs, "\n")
+			for _, wanted := range testCase.WantedLogs {
+				if !strings.Contains(log, wanted) {
+					t.Fatalf(
+						"Wanted log containing `%s`; found:\n%s",
+						wanted,
+						log,
+					)
+				}
+			}
+		})
+	}
+}
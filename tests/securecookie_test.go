@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+type prefs struct {
+	Theme string `json:"theme"`
+	Size  int    `json:"size"`
+}
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestCookieCodec(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	clock := func() time.Time { return now }
+
+	for _, mode := range []CookieMode{Signed, Encrypted} {
+		encoder := &CookieCodec{Mode: mode, Keys: [][]byte{oldKey}, Now: clock}
+		value, err := encoder.Encode("prefs", prefs{"dark", 3})
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		testCases := []struct {
+			Name        string
+			Codec       *CookieCodec
+			CookieName  string
+			Value       string
+			WantedError error
+		}{{
+			Name:       "valid",
+			Codec:      encoder,
+			CookieName: "prefs",
+			Value:      value,
+		}, {
+			Name: "rotated-key",
+			Codec: &CookieCodec{
+				Mode: mode,
+				Keys: [][]byte{newKey, oldKey},
+				Now:  clock,
+			},
+			CookieName: "prefs",
+			Value:      value,
+		}, {
+			Name: "retired-key",
+			Codec: &CookieCodec{
+				Mode: mode,
+				Keys: [][]byte{newKey},
+				Now:  clock,
+			},
+			CookieName:  "prefs",
+			Value:       value,
+			WantedError: ErrInvalidCookie,
+		}, {
+			Name:        "tampered",
+			Codec:       encoder,
+			CookieName:  "prefs",
+			Value:       tamper(value),
+			WantedError: ErrInvalidCookie,
+		}, {
+			Name:        "wrong-name",
+			Codec:       encoder,
+			CookieName:  "session",
+			Value:       value,
+			WantedError: ErrInvalidCookie,
+		}, {
+			Name: "expired",
+			Codec: &CookieCodec{
+				Mode: mode,
+				Keys: [][]byte{oldKey},
+				Now: func() time.Time {
+					return now.Add(31 * 24 * time.Hour)
+				},
+			},
+			CookieName:  "prefs",
+			Value:       value,
+			WantedError: ErrCookieExpired,
+		}}
+
+		for _, testCase := range testCases {
+			name := []string{"signed", "encrypted"}[mode] + "/" + testCase.Name
+			t.Run(name, func(t *testing.T) {
+				var found prefs
+				err := testCase.Codec.Decode(
+					testCase.CookieName,
+					testCase.Value,
+					&found,
+				)
+				if err != testCase.WantedError {
+					t.Fatalf(
+						"Wanted error `%v`; found `%v`",
+						testCase.WantedError,
+						err,
+					)
+				}
+				if err == nil && found != (prefs{"dark", 3}) {
+					t.Fatalf("Wanted `{dark 3}`; found `%v`", found)
+				}
+			})
+		}
+	}
+}
+
+// tamper flips a character in the middle of `value`.
+func tamper(value string) string {
+	i := len(value) / 2
+	c := byte('A')
+	if value[i] == c {
+		c = 'B'
+	}
+	return value[:i] + string(c) + value[i+1:]
+}
+
+func TestCookieCodecEncrypts(t *testing.T) {
+	codec := &CookieCodec{Mode: Encrypted, Keys: [][]byte{oldKey}}
+	value, err := codec.Encode("prefs", prefs{"dark", 3})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if strings.Contains(value, "dark") {
+		t.Fatalf("Wanted an opaque value; found `%s`", value)
+	}
+}
+
+func TestSecureCookies(t *testing.T) {
+	codec := &CookieCodec{Keys: [][]byte{newKey}}
+	handler := Handler(func(r Request) Response {
+		var p prefs
+		if err := r.SecureCookie("prefs", &p); err != nil {
+			return Ok(String("no prefs")).WithSecureCookie(
+				&http.Cookie{Name: "prefs", Path: "/", HttpOnly: true},
+				prefs{"light", 1},
+			)
+		}
+		return Ok(Stringf("%s %d", p.Theme, p.Size))
+	})
+
+	serve := func(
+		h Handler,
+		cookie *http.Cookie,
+	) (*httptest.ResponseRecorder, string) {
+		var logs []string
+		req := httptest.NewRequest("GET", "/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		h.HTTP(func(v interface{}) {
+			data, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			logs = append(logs, string(data))
+		})(w, req)
+		return w, strings.Join(logs, "\n")
+	}
+
+	// Without a cookie, the handler sets one
+	w, _ := serve(handler.With(SecureCookies(codec)), nil)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "prefs" ||
+		!cookies[0].HttpOnly || cookies[0].MaxAge != 30*24*60*60 {
+		t.Fatalf("Wanted an HttpOnly `prefs` cookie; found `%v`", cookies)
+	}
+
+	// The cookie round-trips
+	w, _ = serve(handler.With(SecureCookies(codec)), cookies[0])
+	if body := w.Body.String(); body != "light 1" {
+		t.Fatalf("Wanted `light 1`; found `%s`", body)
+	}
+
+	// Tampered cookies are treated as missing and logged
+	tampered := *cookies[0]
+	tampered.Value = strings.Replace(tampered.Value, ".", "x.", 1)
+	w, log := serve(handler.With(SecureCookies(codec)), &tampered)
+	if body := w.Body.String(); body != "no prefs" {
+		t.Fatalf("Wanted `no prefs`; found `%s`", body)
+	}
+	if !strings.Contains(log, `"context":"Rejected secure cookie"`) {
+		t.Fatalf("Wanted the rejected cookie to be logged; found:\n%s", log)
+	}
+
+	// Secure cookies without the middleware are a server error
+	w, _ = serve(handler, nil)
+	if w.Code != 500 {
+		t.Fatalf("Wanted status `500`; found `%d`", w.Code)
+	}
+}
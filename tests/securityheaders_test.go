@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	html "html/template"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+func TestCSPString(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		CSP    CSP
+		Nonce  string
+		Wanted string
+	}{{
+		Name:   "empty",
+		Wanted: "",
+	}, {
+		Name: "strict",
+		CSP: CSP{
+			DefaultSrc: []string{CSPSelf},
+			ScriptSrc:  []string{CSPNonce, CSPStrictDynamic},
+			ObjectSrc:  []string{CSPNone},
+			BaseURI:    []string{CSPNone},
+			ReportURI:  "/csp-reports",
+		},
+		Nonce: "abc",
+		Wanted: "default-src 'self'; script-src 'nonce-abc' " +
+			"'strict-dynamic'; object-src 'none'; base-uri 'none'; " +
+			"report-uri /csp-reports",
+	}, {
+		Name: "no-nonce",
+		CSP: CSP{
+			ScriptSrc:               []string{CSPSelf, CSPNonce},
+			UpgradeInsecureRequests: true,
+			ReportTo:                "csp",
+		},
+		Wanted: "script-src 'self'; upgrade-insecure-requests; report-to csp",
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			if found := testCase.CSP.String(testCase.Nonce); found !=
+				testCase.Wanted {
+				t.Fatalf("Wanted `%s`; found `%s`", testCase.Wanted, found)
+			}
+		})
+	}
+}
+
+var nonceTemplate = html.Must(html.New("page").Funcs(TemplateFuncs()).Parse(
+	`<script nonce="{{cspNonce}}">init()</script>`,
+))
+
+var noncePattern = regexp.MustCompile(`nonce="([^"]+)"`)
+
+func TestSecurityHeaders(t *testing.T) {
+	page := Handler(func(r Request) Response {
+		return Ok(r.HTMLTemplate(nonceTemplate, nil))
+	})
+	serve := func(h Handler) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.HTTP(func(interface{}) {})(w, httptest.NewRequest("GET", "/", nil))
+		return w
+	}
+
+	// Defaults
+	w := serve(Handler(func(Request) Response {
+		return Ok(String("ok")).WithHeaders(http.Header{
+			"X-Frame-Options": []string{"SAMEORIGIN"},
+		})
+	}).With(SecurityHeaders(SecurityHeadersOptions{})))
+	for key, wanted := range map[string]string{
+		"Strict-Transport-Security": "max-age=63072000",
+		"X-Content-Type-Options":    "nosniff",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"X-Frame-Options":           "SAMEORIGIN", // set by the handler
+		"Content-Security-Policy":   "",
+		"Permissions-Policy":        "",
+	} {
+		if found := w.Header().Get(key); found != wanted {
+			t.Fatalf("Wanted `%s: %s`; found `%s`", key, wanted, found)
+		}
+	}
+
+	// Configured
+	options := SecurityHeadersOptions{
+		HSTSMaxAge:            time.Hour,
+		HSTSIncludeSubdomains: true,
+		HSTSPreload:           true,
+		PermissionsPolicy:     "camera=()",
+		CSP: &CSP{
+			DefaultSrc: []string{CSPSelf},
+			ScriptSrc:  []string{CSPNonce},
+		},
+	}
+	w = serve(page.With(SecurityHeaders(options)))
+	if found := w.Header().Get("Strict-Transport-Security"); found !=
+		"max-age=3600; includeSubDomains; preload" {
+		t.Fatalf("Wanted a configured HSTS header; found `%s`", found)
+	}
+	if found := w.Header().Get("Permissions-Policy"); found != "camera=()" {
+		t.Fatalf("Wanted `Permissions-Policy: camera=()`; found `%s`", found)
+	}
+
+	// The template's nonce matches the policy's
+	match := noncePattern.FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Fatalf("Wanted a nonce in the body; found `%s`", w.Body.String())
+	}
+	wanted := fmt.Sprintf(
+		"default-src 'self'; script-src 'nonce-%s'; frame-ancestors 'none'",
+		match[1],
+	)
+	if found := w.Header().Get("Content-Security-Policy"); found != wanted {
+		t.Fatalf("Wanted `%s`; found `%s`", wanted, found)
+	}
+
+	// Nonces are per-request
+	other := noncePattern.FindStringSubmatch(
+		serve(page.With(SecurityHeaders(options))).Body.String(),
+	)
+	if other == nil || other[1] == match[1] {
+		t.Fatalf("Wanted a fresh nonce; found `%v`", other)
+	}
+
+	// Report-only mode
+	options.CSPReportOnly = true
+	w = serve(page.With(SecurityHeaders(options)))
+	if w.Header().Get("Content-Security-Policy") != "" ||
+		w.Header().Get("Content-Security-Policy-Report-Only") == "" {
+		t.Fatalf("Wanted a report-only policy; found `%v`", w.Header())
+	}
+
+	// Without the middleware, the template function fails
+	if w := serve(page); w.Code != 500 {
+		t.Fatalf("Wanted status `500`; found `%d`", w.Code)
+	}
+}
+
+func TestCSPReportHandler(t *testing.T) {
+	testCases := []struct {
+		Name             string
+		Body             string
+		WantedStatus     int
+		WantedViolations []CSPViolation
+	}{{
+		Name: "legacy",
+		Body: `{"csp-report":{"document-uri":"https://example.com/",` +
+			`"blocked-uri":"inline","violated-directive":"script-src",` +
+			`"original-policy":"script-src 'self'","line-number":3}}`,
+		WantedStatus: 204,
+		WantedViolations: []CSPViolation{{
+			DocumentURI:        "https://example.com/",
+			BlockedURI:         "inline",
+			EffectiveDirective: "script-src",
+			OriginalPolicy:     "script-src 'self'",
+			LineNumber:         3,
+		}},
+	}, {
+		Name: "reporting-api",
+		Body: `[{"type":"csp-violation","body":{` +
+			`"documentURL":"https://example.com/",` +
+			`"blockedURL":"https://evil.example/x.js",` +
+			`"effectiveDirective":"script-src-elem",` +
+			`"disposition":"report"}},` +
+			`{"type":"deprecation","body":{}}]`,
+		WantedStatus: 204,
+		WantedViolations: []CSPViolation{{
+			DocumentURI:        "https://example.com/",
+			BlockedURI:         "https://evil.example/x.js",
+			EffectiveDirective: "script-src-elem",
+			Disposition:        "report",
+		}},
+	}, {
+		Name:         "malformed",
+		Body:         `{"hello":"world"}`,
+		WantedStatus: 400,
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var found []CSPViolation
+			var logs []string
+			handler := CSPReportHandler(func(_ Request, v CSPViolation) {
+				found = append(found, v)
+			})
+			req := httptest.NewRequest(
+				"POST",
+				"/csp-reports",
+				strings.NewReader(testCase.Body),
+			)
+			req.Header.Set("Content-Length", fmt.Sprint(len(testCase.Body)))
+			w := httptest.NewRecorder()
+			handler.HTTP(func(v interface{}) {
+				data, _ := json.Marshal(v)
+				logs = append(logs, string(data))
+			})(w, req)
+
+			if w.Code != testCase.WantedStatus {
+				t.Fatalf(
+					"Wanted status `%d`; found `%d`",
+					testCase.WantedStatus,
+					w.Code,
+				)
+			}
+			if fmt.Sprint(found) != fmt.Sprint(testCase.WantedViolations) {
+				t.Fatalf(
+					"Wanted violations `%v`; found `%v`",
+					testCase.WantedViolations,
+					found,
+				)
+			}
+			if len(found) > 0 && !strings.Contains(
+				strings.Join(logs, "\n"),
+				`"context":"CSP violation"`,
+			) {
+				t.Fatalf("Wanted the violation to be logged; found %v", logs)
+			}
+		})
+	}
+}
@@ -3,8 +3,12 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	. "github.com/weberc2/httpeasy"
@@ -16,6 +20,10 @@ func TestSerializer(t *testing.T) {
 		Serializer   Serializer
 		WantedOutput string
 		WantedError  func(err error) error
+
+		// WantedWriteError validates errors returned while writing the
+		// output, which is when streaming serializers report their errors.
+		WantedWriteError func(err error) error
 	}{{
 		Name:         "string",
 		Serializer:   String("Hello"),
@@ -35,6 +43,42 @@ func TestSerializer(t *testing.T) {
 			Age  int    `json:"age"`
 		}{"Bob", 54}),
 		WantedOutput: `{"name":"Bob","age":54}`,
+	}, {
+		Name: "xml",
+		Serializer: XML(struct {
+			XMLName struct{} `xml:"person"`
+			Name    string   `xml:"name"`
+			Age     int      `xml:"age"`
+		}{Name: "Bob", Age: 54}),
+		WantedOutput: xml.Header +
+			"<person><name>Bob</name><age>54</age></person>",
+	}, {
+		Name: "csv",
+		Serializer: CSV(
+			[]string{"name", "age"},
+			[][]string{{"Bob", "54"}, {"Smith, Alice", "32"}},
+		),
+		WantedOutput: "name,age\nBob,54\n\"Smith, Alice\",32\n",
+	}, {
+		Name:         "csv-rows",
+		Serializer:   CSVRows(nil, rowsOf([]string{"a", "b"}, []string{"c"})),
+		WantedOutput: "a,b\nc\n",
+	}, {
+		Name:       "csv-rows-error",
+		Serializer: CSVRows(nil, failingRows),
+		WantedWriteError: func(err error) error {
+			if err != sentinelErr {
+				return fmt.Errorf("Expected the sentinel error; got '%v'", err)
+			}
+			return nil
+		},
+	}, {
+		Name: "ndjson",
+		Serializer: NDJSON(valuesOf(
+			map[string]int{"a": 1},
+			map[string]int{"b": 2},
+		)),
+		WantedOutput: "{\"a\":1}\n{\"b\":2}\n",
 	}, {
 		Name:       "json-marshal-error",
 		Serializer: JSON(marshalErrorer{}),
@@ -70,8 +114,17 @@ func TestSerializer(t *testing.T) {
 				t.Fatal("Expected an error but got none")
 			}
 			if _, err := writerTo.WriteTo(&buf); err != nil {
+				if testCase.WantedWriteError != nil {
+					if err := testCase.WantedWriteError(err); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
 				t.Fatal("Unexpected error writing to buffer:", err)
 			}
+			if testCase.WantedWriteError != nil {
+				t.Fatal("Expected a write error but got none")
+			}
 
 			if testCase.WantedOutput != buf.String() {
 				t.Fatalf(
@@ -91,3 +144,109 @@ var sentinelErr = errors.New("Sentinel error")
 func (me marshalErrorer) MarshalJSON() ([]byte, error) {
 	return nil, sentinelErr
 }
+
+func rowsOf(rows ...[]string) func() ([]string, error) {
+	return func() ([]string, error) {
+		if len(rows) < 1 {
+			return nil, io.EOF
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	}
+}
+
+func failingRows() ([]string, error) { return nil, sentinelErr }
+
+func valuesOf(values ...interface{}) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		if len(values) < 1 {
+			return nil, io.EOF
+		}
+		v := values[0]
+		values = values[1:]
+		return v, nil
+	}
+}
+
+func TestCSVReusable(t *testing.T) {
+	serializer := CSV([]string{"name"}, [][]string{{"Bob"}, {"Alice"}})
+	for i := 0; i < 2; i++ {
+		writerTo, err := serializer()
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		var buf bytes.Buffer
+		if _, err := writerTo.WriteTo(&buf); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if wanted := "name\nBob\nAlice\n"; buf.String() != wanted {
+			t.Fatalf(
+				"Wanted output `%s` on call %d; found `%s`",
+				wanted,
+				i+1,
+				buf.String(),
+			)
+		}
+	}
+}
+
+func TestSerializerContentType(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Response   Response
+		WantedType string
+	}{{
+		Name: "xml",
+		Response: Ok(XML(struct {
+			XMLName struct{} `xml:"empty"`
+		}{})),
+		WantedType: "application/xml; charset=utf-8",
+	}, {
+		Name:       "csv",
+		Response:   Ok(CSV(nil, nil)),
+		WantedType: "text/csv; charset=utf-8",
+	}, {
+		Name:       "ndjson",
+		Response:   Ok(NDJSON(valuesOf())),
+		WantedType: "application/x-ndjson",
+	}, {
+		Name: "explicit-header-wins",
+		Response: Ok(CSV(nil, nil)).WithHeaders(http.Header{
+			"Content-Type": []string{"text/plain"},
+		}),
+		WantedType: "text/plain",
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Handler(func(Request) Response { return testCase.Response }).HTTP(
+				func(v interface{}) {
+					data, err := json.Marshal(v)
+					if err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					var log struct {
+						WriteError json.RawMessage `json:"writeError"`
+					}
+					if err := json.Unmarshal(data, &log); err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					if string(log.WriteError) != "null" {
+						t.Fatalf("Unexpected write error: %s", log.WriteError)
+					}
+				},
+			)(w, httptest.NewRequest("GET", "/", nil))
+
+			found := w.Header().Get("Content-Type")
+			if found != testCase.WantedType {
+				t.Fatalf(
+					"Wanted Content-Type `%s`; found `%s`",
+					testCase.WantedType,
+					found,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/weberc2/httpeasy"
+	"github.com/weberc2/httpeasy/testsupport"
+)
+
+// sessionApp is a tiny application which logs users in and out, served
+// through the `Sessions` middleware with a fake clock.
+type sessionApp struct {
+	*testsupport.FakeClock
+	t       *testing.T
+	handler Handler
+}
+
+func newSessionApp(t *testing.T, store SessionStore) *sessionApp {
+	// Start far from the real clock, so stores which expire sessions by it
+	// rather than the middleware's are caught
+	app := &sessionApp{
+		FakeClock: testsupport.NewFakeClock(
+			time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		),
+		t: t,
+	}
+	app.handler = Handler(func(r Request) Response {
+		session := r.Session()
+		switch r.URL.Path {
+		case "/login":
+			if err := session.Set("user", "alice"); err != nil {
+				return HandleError("Error setting user", err)
+			}
+			session.RenewID()
+			session.Flash("welcome")
+			return Ok(String("logged in"))
+		case "/logout":
+			session.Destroy()
+			return Ok(String("logged out"))
+		default:
+			var user string
+			if !session.Get("user", &user) {
+				return Ok(String("anonymous"))
+			}
+			return Ok(Stringf("%s %v", user, session.Flashes()))
+		}
+	}).With(Sessions(SessionOptions{
+		Store:           store,
+		IdleTimeout:     time.Hour,
+		AbsoluteTimeout: 3 * time.Hour,
+		Now:             app.Now,
+	}))
+	return app
+}
+
+// get requests `path` with the session cookie (if any) and returns the body
+// and the session cookie set by the response (if any).
+func (app *sessionApp) get(
+	path string,
+	cookie *http.Cookie,
+) (string, *http.Cookie) {
+	req := httptest.NewRequest("GET", path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	app.handler.HTTP(func(interface{}) {})(w, req)
+	for _, set := range w.Result().Cookies() {
+		if set.Name == "session" {
+			return w.Body.String(), set
+		}
+	}
+	return w.Body.String(), nil
+}
+
+func TestSessions(t *testing.T) {
+	testCases := []struct {
+		Name string
+		// Revocable is whether the store can invalidate cookies it issued
+		// before they expire.
+		Revocable bool
+		Store     func(t *testing.T) SessionStore
+	}{{
+		Name:      "memory",
+		Revocable: true,
+		Store:     func(*testing.T) SessionStore { return &MemoryStore{} },
+	}, {
+		Name:      "file",
+		Revocable: true,
+		Store: func(t *testing.T) SessionStore {
+			return FileStore{Dir: t.TempDir()}
+		},
+	}, {
+		Name: "cookie",
+		Store: func(*testing.T) SessionStore {
+			return CookieStore{Codec: &CookieCodec{
+				Mode: Encrypted,
+				Keys: [][]byte{newKey},
+			}}
+		},
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			app := newSessionApp(t, testCase.Store(t))
+
+			// want requests `path` like a browser would, updating `cookie`
+			// from the response
+			want := func(path string, cookie **http.Cookie, wanted string) {
+				t.Helper()
+				body, set := app.get(path, *cookie)
+				if body != wanted {
+					t.Fatalf(
+						"GET %s: wanted `%s`; found `%s`",
+						path,
+						wanted,
+						body,
+					)
+				}
+				if set != nil {
+					*cookie = set
+				}
+			}
+
+			// Anonymous visitors don't get a session cookie
+			if body, cookie := app.get("/", nil); body != "anonymous" ||
+				cookie != nil {
+				t.Fatalf("Wanted no session; found `%s` `%v`", body, cookie)
+			}
+
+			_, first := app.get("/login", nil)
+			if first == nil || !first.HttpOnly || !first.Secure {
+				t.Fatalf("Wanted a secure session cookie; found `%v`", first)
+			}
+
+			// Flashes are shown once
+			session := first
+			want("/", &session, "alice [welcome]")
+			want("/", &session, "alice []")
+
+			// Unchanged sessions aren't saved again
+			if _, set := app.get("/", session); set != nil {
+				t.Fatalf("Wanted no session cookie; found `%v`", set)
+			}
+
+			// Logging in again issues a new ID, invalidating the old one
+			_, renewed := app.get("/login", session)
+			if renewed == nil || renewed.Value == session.Value {
+				t.Fatalf("Wanted a new session cookie; found `%v`", renewed)
+			}
+			if testCase.Revocable {
+				want("/", &first, "anonymous")
+			}
+
+			// Using the session keeps it alive past the idle timeout...
+			session = renewed
+			want("/", &session, "alice [welcome]")
+			for i := 0; i < 3; i++ {
+				app.Advance(50 * time.Minute)
+				want("/", &session, "alice []")
+			}
+
+			// ...but not past the absolute timeout
+			app.Advance(40 * time.Minute)
+			want("/", &session, "anonymous")
+
+			// Idle sessions expire
+			_, session = app.get("/login", nil)
+			app.Advance(61 * time.Minute)
+			want("/", &session, "anonymous")
+
+			// Logging out expires the cookie and the session
+			_, session = app.get("/login", nil)
+			_, expired := app.get("/logout", session)
+			if expired == nil || expired.MaxAge >= 0 {
+				t.Fatalf(
+					"Wanted an expired session cookie; found `%v`",
+					expired,
+				)
+			}
+			if testCase.Revocable {
+				want("/", &session, "anonymous")
+			}
+		})
+	}
+}
+
+func TestSessionsStoreError(t *testing.T) {
+	handler := Handler(func(r Request) Response {
+		if err := r.Session().Set("user", "alice"); err != nil {
+			return HandleError("Error setting user", err)
+		}
+		return Ok(String("ok"))
+	}).With(Sessions(SessionOptions{Store: failingStore{}}))
+
+	w := httptest.NewRecorder()
+	handler.HTTP(func(interface{}) {})(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 500 {
+		t.Fatalf("Wanted status `500`; found `%d`", w.Code)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) > 0 {
+		t.Fatalf("Wanted no cookies; found `%v`", cookies)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Load(string, time.Time) ([]byte, error) {
+	return nil, ErrSessionNotFound
+}
+
+func (failingStore) Save(
+	string,
+	[]byte,
+	time.Time,
+	time.Time,
+) (string, error) {
+	return "", fmt.Errorf("disk full")
+}
+
+func (failingStore) Delete(string) error { return nil }
+
+func TestFileStoreRejectsPaths(t *testing.T) {
+	store := FileStore{Dir: t.TempDir()}
+	for _, token := range []string{"../../etc/passwd", "", "a/b"} {
+		_, err := store.Load(token, time.Now())
+		if !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf(
+				"Token `%s`: wanted `%v`; found `%v`",
+				token,
+				ErrSessionNotFound,
+				err,
+			)
+		}
+	}
+}
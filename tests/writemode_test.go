@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/weberc2/httpeasy"
+)
+
+// partialSerializer writes some output before failing.
+func partialSerializer() (io.WriterTo, error) {
+	return writerToFunc(func(w io.Writer) (int64, error) {
+		n, err := io.WriteString(w, "partial")
+		if err != nil {
+			return int64(n), err
+		}
+		return int64(n), errors.New("database went away")
+	}), nil
+}
+
+type writerToFunc func(w io.Writer) (int64, error)
+
+func (f writerToFunc) WriteTo(w io.Writer) (int64, error) { return f(w) }
+
+func TestWriteMode(t *testing.T) {
+	testCases := []struct {
+		Name                string
+		RouteMode           WriteMode
+		ResponseMode        WriteMode
+		Serializer          Serializer
+		ProtoMajor          int
+		WantedStatus        int
+		WantedBody          string
+		WantedContentLength string
+		WantedAbort         bool
+		WantedTrailer       string
+		WantedWriteError    string
+	}{{
+		Name:                "buffered-success",
+		RouteMode:           Buffered,
+		Serializer:          String("hello"),
+		WantedStatus:        200,
+		WantedBody:          "hello",
+		WantedContentLength: "5",
+		WantedWriteError:    "null",
+	}, {
+		Name:             "buffered-failure",
+		RouteMode:        Buffered,
+		Serializer:       partialSerializer,
+		WantedStatus:     500,
+		WantedBody:       "500 Internal Server Error",
+		WantedWriteError: "null",
+	}, {
+		Name:             "response-overrides-route",
+		RouteMode:        Streaming,
+		ResponseMode:     Buffered,
+		Serializer:       partialSerializer,
+		WantedStatus:     500,
+		WantedBody:       "500 Internal Server Error",
+		WantedWriteError: "null",
+	}, {
+		Name:             "streaming-failure-truncates",
+		Serializer:       partialSerializer,
+		WantedStatus:     200,
+		WantedBody:       "partial",
+		WantedWriteError: `"outcome":"truncated"`,
+	}, {
+		Name:             "streaming-abort-failure-aborts",
+		RouteMode:        StreamingAbort,
+		Serializer:       partialSerializer,
+		WantedStatus:     200,
+		WantedBody:       "partial",
+		WantedAbort:      true,
+		WantedWriteError: `"outcome":"aborted"`,
+	}, {
+		Name:             "streaming-failure-http2",
+		RouteMode:        Streaming,
+		Serializer:       partialSerializer,
+		ProtoMajor:       2,
+		WantedStatus:     200,
+		WantedBody:       "partial",
+		WantedTrailer:    "Internal Server Error",
+		WantedWriteError: `"bytesWritten":7`,
+	}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var logs []json.RawMessage
+			router := NewRouter().Register(
+				func(v interface{}) {
+					data, err := json.Marshal(v)
+					if err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					logs = append(logs, data)
+				},
+				Route{
+					Method:    "GET",
+					Path:      "/",
+					WriteMode: testCase.RouteMode,
+					Handler: func(Request) Response {
+						rsp := Ok(testCase.Serializer)
+						rsp.WriteMode = testCase.ResponseMode
+						return rsp
+					},
+				},
+			)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if testCase.ProtoMajor != 0 {
+				req.ProtoMajor = testCase.ProtoMajor
+			}
+			w := httptest.NewRecorder()
+			func() {
+				defer func() {
+					v := recover()
+					if aborted := v == http.ErrAbortHandler; aborted !=
+						testCase.WantedAbort {
+						t.Fatalf(
+							"Wanted abort `%t`; found panic `%v`",
+							testCase.WantedAbort,
+							v,
+						)
+					}
+				}()
+				router.ServeHTTP(w, req)
+			}()
+
+			rsp := w.Result()
+			if rsp.StatusCode != testCase.WantedStatus {
+				t.Fatalf(
+					"Wanted status `%d`; found `%d`",
+					testCase.WantedStatus,
+					rsp.StatusCode,
+				)
+			}
+			if body := w.Body.String(); body != testCase.WantedBody {
+				t.Fatalf(
+					"Wanted body `%s`; found `%s`",
+					testCase.WantedBody,
+					body,
+				)
+			}
+			found := rsp.Header.Get("Content-Length")
+			if found != testCase.WantedContentLength {
+				t.Fatalf(
+					"Wanted Content-Length `%s`; found `%s`",
+					testCase.WantedContentLength,
+					found,
+				)
+			}
+			trailer := rsp.Trailer.Get("Stream-Error")
+			if trailer != testCase.WantedTrailer {
+				t.Fatalf(
+					"Wanted Stream-Error trailer `%s`; found `%s`",
+					testCase.WantedTrailer,
+					trailer,
+				)
+			}
+
+			var log struct {
+				WriteError json.RawMessage `json:"writeError"`
+			}
+			if err := json.Unmarshal(logs[0], &log); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			if !strings.Contains(
+				string(log.WriteError),
+				testCase.WantedWriteError,
+			) {
+				t.Fatalf(
+					"Wanted write error containing `%s`; found `%s`",
+					testCase.WantedWriteError,
+					log.WriteError,
+				)
+			}
+		})
+	}
+}
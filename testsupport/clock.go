@@ -0,0 +1,33 @@
+package testsupport
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock for testing time-dependent middleware (via its
+// options' `Now` field) which only moves when advanced. It's safe for
+// concurrent use.
+type FakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// NewFakeClock returns a clock set to `now`.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by `d`.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,47 @@
+package testsupport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+
+	"github.com/weberc2/httpeasy"
+)
+
+// JWKSServer serves a JSON Web Key Set for testing `httpeasy.RemoteJWKS`.
+type JWKSServer struct {
+	*httptest.Server
+	keys     atomic.Value
+	requests int64
+}
+
+// NewJWKSServer starts a server publishing the public halves of `keys` at
+// its root URL. Close it when done.
+func NewJWKSServer(keys ...httpeasy.JWTKey) *JWKSServer {
+	s := &JWKSServer{}
+	s.SetKeys(keys...)
+	s.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&s.requests, 1)
+			keys := s.keys.Load().([]httpeasy.JWTKey)
+			data, err := httpeasy.MarshalJWKS(keys...)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/jwk-set+json")
+			w.Write(data)
+		},
+	))
+	return s
+}
+
+// SetKeys replaces the published keys, e.g., to simulate a key rotation.
+func (s *JWKSServer) SetKeys(keys ...httpeasy.JWTKey) {
+	s.keys.Store(append([]httpeasy.JWTKey{}, keys...))
+}
+
+// Requests returns how many times the key set has been fetched.
+func (s *JWKSServer) Requests() int {
+	return int(atomic.LoadInt64(&s.requests))
+}
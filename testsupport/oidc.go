@@ -0,0 +1,238 @@
+package testsupport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weberc2/httpeasy"
+)
+
+// FakeIdP is an OpenID Connect provider for testing `httpeasy.OIDC`
+// offline. It supports discovery, the authorization code flow with PKCE,
+// user info and RP-initiated logout, and logs in its current user without
+// prompting. Its issuer is its URL.
+type FakeIdP struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	key    httpeasy.JWTKey
+	lock   sync.Mutex
+	user   map[string]interface{}
+	grants map[string]fakeGrant
+	tokens map[string]map[string]interface{}
+}
+
+// fakeGrant is an issued authorization code.
+type fakeGrant struct {
+	redirectURI string
+	nonce       string
+	challenge   string
+	user        map[string]interface{}
+}
+
+// NewFakeIdP starts a provider for the client, whose user is `alice`. Close
+// it when done.
+func NewFakeIdP(clientID, clientSecret string) *FakeIdP {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	p := &FakeIdP{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          httpeasy.JWTKey{ID: "fake", Key: key},
+		user: map[string]interface{}{
+			"sub":   "alice",
+			"name":  "Alice",
+			"email": "alice@example.com",
+		},
+		grants: map[string]fakeGrant{},
+		tokens: map[string]map[string]interface{}{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/userinfo", p.userInfo)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/logout", p.logout)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// SetUser sets the claims of the user who logs in. A nil user makes the
+// provider deny logins with `access_denied`.
+func (p *FakeIdP) SetUser(claims map[string]interface{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.user = claims
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (p *FakeIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"userinfo_endpoint":      p.URL + "/userinfo",
+		"jwks_uri":               p.URL + "/jwks",
+		"end_session_endpoint":   p.URL + "/logout",
+	})
+}
+
+func (p *FakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("client_id") != p.ClientID {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+	params := url.Values{"state": {query.Get("state")}}
+
+	p.lock.Lock()
+	user := p.user
+	switch {
+	case query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" ||
+		!strings.Contains(query.Get("scope"), "openid"):
+		params.Set("error", "invalid_request")
+	case user == nil:
+		params.Set("error", "access_denied")
+	default:
+		code := randomString()
+		p.grants[code] = fakeGrant{
+			redirectURI: redirect.String(),
+			nonce:       query.Get("nonce"),
+			challenge:   query.Get("code_challenge"),
+			user:        user,
+		}
+		params.Set("code", code)
+	}
+	p.lock.Unlock()
+
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *FakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	invalid := func(code string) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+	}
+	if err := r.ParseForm(); err != nil {
+		invalid("invalid_request")
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || secret != p.ClientSecret {
+		invalid("invalid_client")
+		return
+	}
+
+	p.lock.Lock()
+	code := r.PostForm.Get("code")
+	grant, ok := p.grants[code]
+	delete(p.grants, code) // codes are single-use
+	p.lock.Unlock()
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("redirect_uri") != grant.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) !=
+			grant.challenge {
+		invalid("invalid_grant")
+		return
+	}
+
+	claims := map[string]interface{}{}
+	for key, value := range grant.user {
+		claims[key] = value
+	}
+	claims["aud"] = p.ClientID
+	claims["nonce"] = grant.nonce
+	idToken, err := httpeasy.JWTSigner{
+		Key:    p.key,
+		Issuer: p.URL,
+		TTL:    5 * time.Minute,
+	}.Sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	accessToken := randomString()
+	p.lock.Lock()
+	p.tokens[accessToken] = grant.user
+	p.lock.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (p *FakeIdP) userInfo(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	user, ok := p.tokens[strings.TrimPrefix(
+		r.Header.Get("Authorization"),
+		"Bearer ",
+	)]
+	p.lock.Unlock()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"error": "invalid_token",
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (p *FakeIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	data, err := httpeasy.MarshalJWKS(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Write(data)
+}
+
+func (p *FakeIdP) logout(w http.ResponseWriter, r *http.Request) {
+	redirect := r.URL.Query().Get("post_logout_redirect_uri")
+	if redirect != "" {
+		http.Redirect(w, r, redirect, http.StatusFound)
+		return
+	}
+	w.Write([]byte("Logged out"))
+}
@@ -0,0 +1,107 @@
+package httpeasy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// WriteMode determines how a response body is written and, consequently, what
+// happens if its serializer fails partway through.
+type WriteMode int
+
+const (
+	// DefaultWriteMode defers to the route's write mode, or to `Streaming` if
+	// the route doesn't set one.
+	DefaultWriteMode WriteMode = iota
+
+	// Streaming writes the body to the client as it's produced. Since the
+	// status has already been sent, a serializer failure can't become a 500;
+	// the error is logged and the response ends where the serializer stopped.
+	// On HTTP/2 the response also gets a `Stream-Error` trailer, but on
+	// HTTP/1 the client gets no error signal: a Content-Length-less body
+	// simply looks shorter than it should. Streaming remains the default for
+	// compatibility, since aborting panics, which handlers served outside
+	// net/http's server (e.g., by `httptest.ResponseRecorder`) don't expect.
+	// Use `StreamingAbort` where clients must be able to detect truncation.
+	Streaming
+
+	// Buffered serializes the entire body before anything is sent, so a
+	// serializer failure becomes a proper error response (see
+	// `Router.ErrorRenderer`). Buffered responses also get a
+	// `Content-Length` header. Avoid it for large or long-lived bodies.
+	Buffered
+
+	// StreamingAbort is like Streaming, except that on HTTP/1 a serializer
+	// failure aborts the request (by panicking with `http.ErrAbortHandler`)
+	// so the client doesn't mistake the truncated body for a complete one.
+	// Since a client disconnect is also a write error, this is only suitable
+	// under net/http's server, which recovers the panic.
+	StreamingAbort
+)
+
+// streamErrorTrailer is the trailer which signals a serializer failure on a
+// streaming HTTP/2 response.
+const streamErrorTrailer = "Stream-Error"
+
+// writeMode returns a copy of `h` whose responses use `mode` unless they set
+// their own WriteMode.
+func (h Handler) writeMode(mode WriteMode) Handler {
+	if mode == DefaultWriteMode {
+		return h
+	}
+	return func(r Request) Response {
+		rsp := h(r)
+		if rsp.WriteMode == DefaultWriteMode {
+			rsp.WriteMode = mode
+		}
+		return rsp
+	}
+}
+
+// bufferedWriterTo holds a serializer's complete output.
+type bufferedWriterTo struct {
+	*bytes.Buffer
+	contentType string
+}
+
+func (bwt bufferedWriterTo) ContentType() string { return bwt.contentType }
+
+// buffer writes `writerTo`'s complete output into memory.
+func buffer(writerTo io.WriterTo) (io.WriterTo, error) {
+	var buf bytes.Buffer
+	if _, err := writerTo.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return bufferedWriterTo{&buf, contentType(writerTo)}, nil
+}
+
+// setContentLength sets the `Content-Length` header for buffered output if it
+// isn't already set.
+func setContentLength(header http.Header, writerTo io.WriterTo) {
+	buffered, ok := writerTo.(bufferedWriterTo)
+	if ok && buffered.Len() > 0 && header.Get("Content-Length") == "" {
+		header.Set("Content-Length", strconv.Itoa(buffered.Len()))
+	}
+}
+
+// writeErrorLog describes an error which occurred after the status was sent.
+type writeErrorLog struct {
+	errorLog
+
+	// BytesWritten is the number of body bytes written before the error.
+	BytesWritten int64 `json:"bytesWritten"`
+
+	// Outcome is how the error was signaled to the client: `truncated`,
+	// `aborted` or `trailer`.
+	Outcome string `json:"outcome"`
+}
+
+func newWriteErrorLog(err error, n int64, outcome string) *writeErrorLog {
+	return &writeErrorLog{
+		errorLog:     newErrorLog("Error writing response", err),
+		BytesWritten: n,
+		Outcome:      outcome,
+	}
+}